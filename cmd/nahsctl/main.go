@@ -0,0 +1,218 @@
+// Command nahsctl inspects and drives a node run by nahsd through its
+// control API.
+//
+//	nahsctl [-addr host:port|unix:path] [-token-file path] [-json] command [arguments]
+//
+// The token required by the control API is read from the token file
+// or, if not set, from NAHS_CONTROL_TOKEN.
+//
+// The commands are:
+//
+//	contacts                       list the contacts and their services
+//	instances                      list the open instances and their owners
+//	ping <peer>                    ping a peer with the echo protocol
+//	add-protocol <file> <role>...  add a BSPL protocol and the roles played
+//	send <file>                    send an event described in JSON, - for stdin
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/libp2p/go-libp2p-core/peer"
+	"github.com/mikelsr/nahs/control"
+)
+
+const (
+	// requestTimeout limits each call to the control API
+	requestTimeout = time.Minute
+	// tokenEnv is the environment variable the token is read from
+	// if no token file is set
+	tokenEnv = "NAHS_CONTROL_TOKEN"
+)
+
+// errUsage is returned when the command line is not valid
+var errUsage = errors.New("usage: nahsctl [-addr host:port|unix:path] [-token-file path] [-json] " +
+	"contacts | instances | ping <peer> | add-protocol <file> <role>... | send <file>")
+
+func main() {
+	if err := run(os.Args[1:], os.Stdin, os.Stdout); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		if err == errUsage {
+			os.Exit(2)
+		}
+		os.Exit(1)
+	}
+}
+
+// run parses the command line and runs the command
+func run(args []string, stdin io.Reader, stdout io.Writer) error {
+	flags := flag.NewFlagSet("nahsctl", flag.ContinueOnError)
+	flags.SetOutput(ioutil.Discard)
+	addr := flags.String("addr", control.DefaultAddr, "address of the control API")
+	tokenFile := flags.String("token-file", "", "path of the token of the control API")
+	asJSON := flags.Bool("json", false, "print the responses as JSON")
+	if err := flags.Parse(args); err != nil || flags.NArg() == 0 {
+		return errUsage
+	}
+	client := control.NewClient(*addr)
+	client.Token = os.Getenv(tokenEnv)
+	if *tokenFile != "" {
+		token, err := control.LoadToken(*tokenFile)
+		if err != nil {
+			return err
+		}
+		client.Token = token
+	}
+	c := cli{client: client, json: *asJSON, out: stdout}
+	ctx, cancel := context.WithTimeout(context.Background(), requestTimeout)
+	defer cancel()
+
+	args = flags.Args()
+	switch args[0] {
+	case "contacts":
+		return c.contacts(ctx)
+	case "instances":
+		return c.instances(ctx)
+	case "ping":
+		if len(args) != 2 {
+			return errUsage
+		}
+		return c.ping(ctx, args[1])
+	case "add-protocol":
+		if len(args) < 3 {
+			return errUsage
+		}
+		return c.addProtocol(ctx, args[1], args[2:])
+	case "send":
+		if len(args) != 2 {
+			return errUsage
+		}
+		return c.send(ctx, args[1], stdin)
+	}
+	return errUsage
+}
+
+// cli runs the commands and prints their results
+type cli struct {
+	client *control.Client
+	json   bool
+	out    io.Writer
+}
+
+func (c cli) contacts(ctx context.Context) error {
+	contacts, err := c.client.Contacts(ctx)
+	if err != nil || c.json {
+		return c.printJSON(contacts, err)
+	}
+	w := tabwriter.NewWriter(c.out, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "ID\tLAST SEEN\tPROTOCOL\tROLES")
+	for _, contact := range contacts {
+		seen := contact.LastSeen.Format(time.RFC3339)
+		if len(contact.Services) == 0 {
+			fmt.Fprintf(w, "%s\t%s\t-\t-\n", contact.ID, seen)
+		}
+		for _, s := range contact.Services {
+			fmt.Fprintf(w, "%s\t%s\t%s\t%s\n", contact.ID, seen, s.ProtocolKey, strings.Join(s.Roles, ","))
+		}
+	}
+	return w.Flush()
+}
+
+func (c cli) instances(ctx context.Context) error {
+	instances, err := c.client.Instances(ctx)
+	if err != nil || c.json {
+		return c.printJSON(instances, err)
+	}
+	w := tabwriter.NewWriter(c.out, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "KEY\tCREATOR\tROLES")
+	for _, instance := range instances {
+		roles := make([]string, 0, len(instance.Roles))
+		for role, id := range instance.Roles {
+			roles = append(roles, role+"="+id.Pretty())
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\n", instance.Key, instance.Creator, strings.Join(roles, ","))
+	}
+	return w.Flush()
+}
+
+func (c cli) ping(ctx context.Context, target string) error {
+	id, err := peer.Decode(target)
+	if err != nil {
+		return err
+	}
+	rtt, err := c.client.Ping(ctx, id)
+	if err != nil || c.json {
+		return c.printJSON(control.PingResponse{RTT: rtt}, err)
+	}
+	_, err = fmt.Fprintf(c.out, "Pong from %s in %s\n", id, rtt)
+	return err
+}
+
+func (c cli) addProtocol(ctx context.Context, path string, roles []string) error {
+	source, err := ioutil.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	service, err := c.client.AddProtocol(ctx, string(source), roles...)
+	if err != nil || c.json {
+		return c.printJSON(service, err)
+	}
+	_, err = fmt.Fprintf(c.out, "Added protocol %s playing %s\n", service.ProtocolKey, strings.Join(service.Roles, ","))
+	return err
+}
+
+func (c cli) send(ctx context.Context, path string, stdin io.Reader) error {
+	var r io.Reader = stdin
+	if path != "-" {
+		file, err := os.Open(path)
+		if err != nil {
+			return err
+		}
+		defer file.Close()
+		r = file
+	}
+	var req control.EventRequest
+	decoder := json.NewDecoder(r)
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(&req); err != nil {
+		return fmt.Errorf("Invalid event: %s", err)
+	}
+	response, err := c.client.SendEvent(ctx, req)
+	if err != nil || c.json {
+		return c.printJSON(response, err)
+	}
+	fmt.Fprintf(c.out, "Sent event %s for instance %s\n", response.ID, response.InstanceKey)
+	failed := 0
+	for id, e := range response.Peers {
+		if e == "" {
+			fmt.Fprintf(c.out, "  %s: ok\n", id)
+			continue
+		}
+		failed++
+		fmt.Fprintf(c.out, "  %s: %s\n", id, e)
+	}
+	if failed > 0 {
+		return fmt.Errorf("%d of %d peers failed", failed, len(response.Peers))
+	}
+	return nil
+}
+
+// printJSON prints a response as JSON unless the request failed
+func (c cli) printJSON(v interface{}, err error) error {
+	if err != nil {
+		return err
+	}
+	encoder := json.NewEncoder(c.out)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(v)
+}
@@ -0,0 +1,98 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/mikelsr/nahs/control"
+	"github.com/mikelsr/nahs/net"
+)
+
+const testProtocol = `A {
+	role Ra, Rb
+	parameter out ID key
+
+	Ra -> Rb: Aa[out ID key]
+}`
+
+func TestRun(t *testing.T) {
+	node, err := net.LocalNode(nil, net.WithListenAddrs("/ip4/127.0.0.1/tcp/0"))
+	if err != nil {
+		t.Log(err)
+		t.FailNow()
+	}
+	defer node.Close(context.Background())
+	handler, err := control.NewServer(node, control.WithToken("secret"))
+	if err != nil {
+		t.Log(err)
+		t.FailNow()
+	}
+	server := httptest.NewServer(handler)
+	defer server.Close()
+	dir, err := ioutil.TempDir("", "nahsctl")
+	if err != nil {
+		t.Log(err)
+		t.FailNow()
+	}
+	defer os.RemoveAll(dir)
+	protocol := filepath.Join(dir, "a.bspl")
+	if err := ioutil.WriteFile(protocol, []byte(testProtocol), 0600); err != nil {
+		t.Log(err)
+		t.FailNow()
+	}
+	token := filepath.Join(dir, "token")
+	if err := ioutil.WriteFile(token, []byte("secret\n"), 0600); err != nil {
+		t.Log(err)
+		t.FailNow()
+	}
+
+	var out bytes.Buffer
+	cmd := func(stdin string, args ...string) error {
+		out.Reset()
+		return run(append([]string{"-addr", server.URL, "-token-file", token}, args...), strings.NewReader(stdin), &out)
+	}
+	// the token is required
+	if err := run([]string{"-addr", server.URL, "contacts"}, nil, &out); err == nil {
+		t.FailNow()
+	}
+	if err := cmd("", "add-protocol", protocol, "Ra"); err != nil || !strings.Contains(out.String(), "Added protocol") {
+		t.Log(err)
+		t.FailNow()
+	}
+	if len(node.Services()) != 1 {
+		t.FailNow()
+	}
+	if err := cmd("", "contacts"); err != nil || !strings.HasPrefix(out.String(), "ID") {
+		t.Log(err)
+		t.FailNow()
+	}
+	var instances []control.Instance
+	if err := cmd("", "-json", "instances"); err != nil || json.Unmarshal(out.Bytes(), &instances) != nil {
+		t.Log(err)
+		t.FailNow()
+	}
+
+	// errors of the node are returned
+	if err := cmd(`{"type": "drop", "instance_key": "unknown"}`, "send", "-"); err == nil {
+		t.FailNow()
+	}
+	if err := cmd(`{"unknown": 1}`, "send", "-"); err == nil {
+		t.FailNow()
+	}
+	if err := cmd("", "ping", "invalid"); err == nil {
+		t.FailNow()
+	}
+	for _, args := range [][]string{{}, {"unknown"}, {"ping"}, {"add-protocol", protocol}, {"send"}} {
+		if err := cmd("", args...); err != errUsage {
+			t.Log(args)
+			t.FailNow()
+		}
+	}
+}
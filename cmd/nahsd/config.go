@@ -0,0 +1,238 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/mikelsr/bspl"
+	"github.com/mikelsr/nahs/control"
+	"github.com/mikelsr/nahs/net"
+	"github.com/multiformats/go-multiaddr"
+)
+
+const (
+	discoveryDHT  = "dht"
+	discoveryMDNS = "mdns"
+
+	// unixPrefix marks the control addresses that are Unix sockets
+	unixPrefix = "unix:"
+	// defaultControlToken is the token file of the control API when
+	// it listens on a TCP address
+	defaultControlToken = "control.token"
+
+	// keyPassphraseEnv is the environment variable the passphrase
+	// of an encrypted identity key is read from
+	keyPassphraseEnv = "NAHSD_KEY_PASSPHRASE"
+)
+
+// Config of the daemon, read from a JSON file. Relative paths are
+// resolved from the directory of the file.
+type Config struct {
+	// Key is the path of the identity key of the node, generated
+	// on the first run
+	Key string `json:"key"`
+	// Data is the directory the state of the node and its
+	// instances are stored in
+	Data string `json:"data"`
+	// Listen are the multiaddrs the node listens on, every
+	// interface if empty
+	Listen []string `json:"listen"`
+	// PSK is the path of the private network PSK, the node joins
+	// the public network if empty
+	PSK string `json:"psk"`
+	// Discovery is either "dht" (default) or "mdns"
+	Discovery string `json:"discovery"`
+	// Bootstrap are the multiaddrs of the DHT bootstrap peers, the
+	// public IPFS peers if not set. An empty list uses none.
+	Bootstrap []string `json:"bootstrap"`
+	// Rendezvous is the namespace the node is announced in
+	Rendezvous string `json:"rendezvous"`
+	// Protocols the node offers
+	Protocols []ProtocolConfig `json:"protocols"`
+	// Control is the address the control API listens on, a
+	// loopback address or a Unix socket as "unix:<path>".
+	// control.DefaultAddr if empty.
+	Control string `json:"control"`
+	// ControlToken is the path of the token the control API and
+	// the gRPC service require, generated on the first run.
+	// "control.token" if empty and Control is a TCP address. No
+	// token is required if empty and Control is a Unix socket.
+	ControlToken string `json:"control_token"`
+	// RPC is the address the gRPC service listens on, a loopback
+	// address or a Unix socket as "unix:<path>". The service is
+	// disabled if empty.
+	RPC string `json:"rpc"`
+	// Metrics serves the Prometheus metrics of the node on
+	// /metrics of the control API
+	Metrics bool `json:"metrics"`
+}
+
+// ProtocolConfig is a BSPL protocol file and the roles the node
+// plays in it
+type ProtocolConfig struct {
+	File  string   `json:"file"`
+	Roles []string `json:"roles"`
+}
+
+// LoadConfig reads the configuration file in path
+func LoadConfig(path string) (Config, error) {
+	var c Config
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return c, err
+	}
+	if err := json.Unmarshal(b, &c); err != nil {
+		return c, fmt.Errorf("Invalid configuration file '%s': %s", path, err)
+	}
+	if c.Key == "" {
+		c.Key = "nahsd.key"
+	}
+	if c.Data == "" {
+		c.Data = "data"
+	}
+	if c.Discovery == "" {
+		c.Discovery = discoveryDHT
+	}
+	if c.Control == "" {
+		c.Control = control.DefaultAddr
+	}
+	// any local process or page loaded by a browser can reach a
+	// TCP address, so the control API requires a token
+	if c.ControlToken == "" && !strings.HasPrefix(c.Control, unixPrefix) {
+		c.ControlToken = defaultControlToken
+	}
+	dir := filepath.Dir(path)
+	c.Key, c.Data = resolve(dir, c.Key), resolve(dir, c.Data)
+	if c.PSK != "" {
+		c.PSK = resolve(dir, c.PSK)
+	}
+	c.Control, c.RPC = resolveSocket(dir, c.Control), resolveSocket(dir, c.RPC)
+	if c.ControlToken != "" {
+		c.ControlToken = resolve(dir, c.ControlToken)
+	}
+	for i := range c.Protocols {
+		c.Protocols[i].File = resolve(dir, c.Protocols[i].File)
+	}
+	return c, nil
+}
+
+// resolve makes a relative path relative to dir
+func resolve(dir, path string) string {
+	if filepath.IsAbs(path) {
+		return path
+	}
+	return filepath.Join(dir, path)
+}
+
+// resolveSocket makes the path of a Unix socket address relative
+// to dir
+func resolveSocket(dir, addr string) string {
+	if !strings.HasPrefix(addr, unixPrefix) {
+		return addr
+	}
+	return unixPrefix + resolve(dir, strings.TrimPrefix(addr, unixPrefix))
+}
+
+// token loads the token of the control API and the gRPC service,
+// generating it on the first run, or returns an empty token if
+// none is configured
+func (c Config) token() (string, error) {
+	if c.ControlToken == "" {
+		return "", nil
+	}
+	token, created, err := control.LoadOrCreateToken(c.ControlToken)
+	if err != nil {
+		return "", err
+	}
+	if created {
+		logger.Infof("Generated control token '%s'", c.ControlToken)
+	}
+	return token, nil
+}
+
+// options translates the configuration to node options
+func (c Config) options() ([]net.Option, error) {
+	opts := make([]net.Option, 0)
+	if len(c.Listen) > 0 {
+		opts = append(opts, net.WithListenAddrs(c.Listen...))
+	}
+	if c.PSK != "" {
+		psk, err := net.LoadPSK(c.PSK)
+		if err != nil {
+			return nil, err
+		}
+		opts = append(opts, net.WithPrivateNetwork(psk))
+	}
+	switch c.Discovery {
+	case discoveryDHT:
+		opts = append(opts, net.WithDiscoveryMode(net.DiscoveryDHT))
+	case discoveryMDNS:
+		opts = append(opts, net.WithDiscoveryMode(net.DiscoveryMDNS))
+	default:
+		return nil, fmt.Errorf("Unknown discovery '%s'", c.Discovery)
+	}
+	if c.Bootstrap != nil {
+		addrs := make([]multiaddr.Multiaddr, len(c.Bootstrap))
+		for i, s := range c.Bootstrap {
+			addr, err := multiaddr.NewMultiaddr(s)
+			if err != nil {
+				return nil, fmt.Errorf("Invalid bootstrap address '%s': %s", s, err)
+			}
+			addrs[i] = addr
+		}
+		opts = append(opts, net.WithBootstrapPeers(addrs...))
+	}
+	if c.Rendezvous != "" {
+		opts = append(opts, net.WithRendezvous(c.Rendezvous))
+	}
+	return opts, nil
+}
+
+// services parses the protocol files and checks the roles
+func (c Config) services() ([]net.Service, error) {
+	services := make([]net.Service, len(c.Protocols))
+	for i, pc := range c.Protocols {
+		p, err := parseProtocol(pc.File)
+		if err != nil {
+			return nil, err
+		}
+		roles := make([]bspl.Role, len(pc.Roles))
+		for j, r := range pc.Roles {
+			role := bspl.Role(r)
+			if !hasRole(p, role) {
+				return nil, fmt.Errorf("Protocol '%s' has no role '%s'", p.Name, r)
+			}
+			roles[j] = role
+		}
+		services[i] = net.Service{Protocol: p, Roles: roles}
+	}
+	return services, nil
+}
+
+// parseProtocol reads a BSPL protocol file
+func parseProtocol(path string) (bspl.Protocol, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return bspl.Protocol{}, err
+	}
+	defer file.Close()
+	p, err := bspl.Parse(file)
+	if err != nil {
+		return p, fmt.Errorf("Invalid protocol file '%s': %s", path, err)
+	}
+	return p, nil
+}
+
+// hasRole checks if a role is defined in a protocol
+func hasRole(p bspl.Protocol, role bspl.Role) bool {
+	for _, r := range p.Roles {
+		if r == role {
+			return true
+		}
+	}
+	return false
+}
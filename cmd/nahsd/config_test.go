@@ -0,0 +1,117 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+const testProtocol = `A {
+	role Ra, Rb
+	parameter out ID key
+
+	Ra -> Rb: Aa[out ID key]
+}`
+
+// writeTestConfig writes a configuration file and the protocol it
+// offers to a temporary directory
+func writeTestConfig(t *testing.T, config string) (string, string) {
+	dir, err := ioutil.TempDir("", "nahsd")
+	if err != nil {
+		t.Log(err)
+		t.FailNow()
+	}
+	if err := ioutil.WriteFile(filepath.Join(dir, "a.bspl"), []byte(testProtocol), 0600); err != nil {
+		t.Log(err)
+		t.FailNow()
+	}
+	path := filepath.Join(dir, "nahsd.json")
+	if err := ioutil.WriteFile(path, []byte(config), 0600); err != nil {
+		t.Log(err)
+		t.FailNow()
+	}
+	return dir, path
+}
+
+func TestLoadConfig(t *testing.T) {
+	dir, path := writeTestConfig(t, `{
+		"listen": ["/ip4/127.0.0.1/tcp/0"],
+		"bootstrap": [],
+		"control": "unix:control.sock",
+		"protocols": [{"file": "a.bspl", "roles": ["Ra"]}]
+	}`)
+	defer os.RemoveAll(dir)
+
+	c, err := LoadConfig(path)
+	if err != nil {
+		t.Log(err)
+		t.FailNow()
+	}
+	// defaults and paths relative to the file
+	if c.Key != filepath.Join(dir, "nahsd.key") || c.Data != filepath.Join(dir, "data") ||
+		c.Discovery != discoveryDHT || c.Protocols[0].File != filepath.Join(dir, "a.bspl") {
+		t.Log(c)
+		t.FailNow()
+	}
+	if c.Bootstrap == nil || len(c.Bootstrap) != 0 {
+		t.FailNow()
+	}
+	if c.Control != "unix:"+filepath.Join(dir, "control.sock") || c.ControlToken != "" {
+		t.Log(c.Control)
+		t.FailNow()
+	}
+	if opts, err := c.options(); err != nil || len(opts) != 3 {
+		t.Log(err)
+		t.FailNow()
+	}
+	services, err := c.services()
+	if err != nil || len(services) != 1 || services[0].Protocol.Name != "A" || services[0].Roles[0] != "Ra" {
+		t.Log(err)
+		t.FailNow()
+	}
+
+	// TCP control addresses always require a token
+	if err := ioutil.WriteFile(path, []byte(`{"control": "127.0.0.1:4101"}`), 0600); err != nil {
+		t.Log(err)
+		t.FailNow()
+	}
+	if c, err = LoadConfig(path); err != nil || c.ControlToken != filepath.Join(dir, defaultControlToken) {
+		t.Log(err)
+		t.FailNow()
+	}
+
+	if _, err := LoadConfig(filepath.Join(dir, "missing.json")); err == nil {
+		t.FailNow()
+	}
+}
+
+func TestConfig_invalid(t *testing.T) {
+	dir, path := writeTestConfig(t, `{"protocols": [{"file": "a.bspl", "roles": ["Rx"]}]}`)
+	defer os.RemoveAll(dir)
+	c, err := LoadConfig(path)
+	if err != nil {
+		t.Log(err)
+		t.FailNow()
+	}
+	if _, err := c.services(); err == nil {
+		t.FailNow()
+	}
+	for _, invalid := range []Config{
+		{Discovery: "unknown"},
+		{Discovery: discoveryDHT, Bootstrap: []string{"invalid"}},
+		{Discovery: discoveryDHT, PSK: filepath.Join(dir, "missing.psk")},
+	} {
+		if _, err := invalid.options(); err == nil {
+			t.Log(invalid)
+			t.FailNow()
+		}
+	}
+	if err := ioutil.WriteFile(path, []byte("{"), 0600); err != nil {
+		t.Log(err)
+		t.FailNow()
+	}
+	if _, err := LoadConfig(path); err == nil {
+		t.FailNow()
+	}
+}
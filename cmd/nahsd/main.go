@@ -0,0 +1,246 @@
+// Command nahsd runs a NaHS node configured by a JSON file until it
+// receives SIGINT or SIGTERM.
+//
+//	nahsd -config nahsd.json
+//
+// The identity key of the node is generated on the first run. If it
+// is encrypted, the passphrase is read from NAHSD_KEY_PASSPHRASE.
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"net/http"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"syscall"
+	"time"
+
+	log "github.com/ipfs/go-log"
+	"github.com/libp2p/go-libp2p-core/crypto"
+	"github.com/mikelsr/nahs"
+	"github.com/mikelsr/nahs/control"
+	"github.com/mikelsr/nahs/keystore"
+	"github.com/mikelsr/nahs/net"
+	"github.com/mikelsr/nahs/reasoner"
+	"github.com/mikelsr/nahs/rpc"
+	"github.com/prometheus/client_golang/prometheus"
+	"google.golang.org/grpc"
+)
+
+const (
+	// outboxInterval is how often undelivered events are retried
+	outboxInterval = time.Minute
+	// shutdownTimeout is how long in-flight events are waited
+	// for when stopping
+	shutdownTimeout = 10 * time.Second
+)
+
+var logger = log.Logger("nahsd")
+
+func main() {
+	configPath := flag.String("config", "nahsd.json", "path of the configuration file")
+	logLevel := flag.String("log-level", "info", "log level: debug, info, warn or error")
+	flag.Parse()
+
+	level, err := log.LevelFromString(*logLevel)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(2)
+	}
+	log.SetAllLoggers(level)
+	if err := run(*configPath); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+}
+
+// run starts the daemon and stops it on SIGINT or SIGTERM
+func run(configPath string) error {
+	c, err := LoadConfig(configPath)
+	if err != nil {
+		return err
+	}
+	d, err := startDaemon(c)
+	if err != nil {
+		return err
+	}
+	logger.Infof("Node '%s' listening on %s", d.node.ID(), d.node.Addrs())
+	logger.Infof("Control API listening on %s", d.controlAddr)
+	if d.rpc != nil {
+		logger.Infof("gRPC service listening on %s", d.rpcAddr)
+	}
+
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+	s := <-signals
+	logger.Infof("Received %s, stopping", s)
+	signal.Stop(signals)
+
+	ctx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+	defer cancel()
+	return d.stop(ctx)
+}
+
+// daemon is a running node along with its store, reasoner, control
+// API and gRPC service
+type daemon struct {
+	node       *nahs.Node
+	store      *net.BoltStore
+	reasoner   *reasoner.Reasoner
+	control    *http.Server
+	rpc        *grpc.Server
+	rpcService *rpc.Server
+	// metrics of the node and the process, nil if disabled
+	metrics *prometheus.Registry
+	// controlAddr and rpcAddr are the addresses the control API
+	// and the gRPC service listen on
+	controlAddr string
+	rpcAddr     string
+}
+
+// startDaemon creates the node described by a configuration, adds
+// the protocols it offers and serves its control API and gRPC service
+func startDaemon(c Config) (*daemon, error) {
+	opts, err := c.options()
+	if err != nil {
+		return nil, err
+	}
+	services, err := c.services()
+	if err != nil {
+		return nil, err
+	}
+	sk, created, err := keystore.LoadOrCreate(c.Key, crypto.Ed25519, []byte(os.Getenv(keyPassphraseEnv)))
+	if err != nil {
+		return nil, err
+	}
+	if created {
+		logger.Infof("Generated identity key '%s'", c.Key)
+	}
+
+	d := new(daemon)
+	if d.store, err = net.OpenBoltStore(filepath.Join(c.Data, "node.db")); err != nil {
+		return nil, err
+	}
+	if d.reasoner, err = reasoner.Open(filepath.Join(c.Data, "reasoner.db")); err != nil {
+		d.store.Close()
+		return nil, err
+	}
+	opts = append(opts, net.WithStore(d.store), net.WithOutbox(d.store, outboxInterval))
+	if c.Metrics {
+		d.metrics = prometheus.NewRegistry()
+		d.metrics.MustRegister(prometheus.NewGoCollector(), prometheus.NewProcessCollector(prometheus.ProcessCollectorOpts{}))
+		opts = append(opts, net.WithMetrics(d.metrics))
+	}
+	if d.node, err = nahs.MakeNode(d.reasoner, sk, opts...); err != nil {
+		d.reasoner.Close()
+		d.store.Close()
+		return nil, err
+	}
+	for _, s := range services {
+		d.node.AddProtocol(s.Protocol, s.Roles...)
+	}
+
+	token, err := c.token()
+	if err != nil {
+		d.stop(context.Background())
+		return nil, err
+	}
+	if err := d.serveControl(c, token); err != nil {
+		d.stop(context.Background())
+		return nil, err
+	}
+	if c.RPC != "" {
+		if err := d.serveRPC(c, token); err != nil {
+			d.stop(context.Background())
+			return nil, err
+		}
+	}
+	return d, nil
+}
+
+// serveControl serves the control API of the node, requiring a token
+// if it is not empty
+func (d *daemon) serveControl(c Config, token string) error {
+	var opts []control.Option
+	if token != "" {
+		opts = append(opts, control.WithToken(token))
+	}
+	if d.metrics != nil {
+		opts = append(opts, control.WithMetrics(d.metrics))
+	}
+	server, err := control.NewServer(d.node, opts...)
+	if err != nil {
+		return err
+	}
+	listener, err := control.Listen(c.Control)
+	if err != nil {
+		return err
+	}
+	d.controlAddr = listener.Addr().String()
+	d.control = &http.Server{Handler: server}
+	d.control.RegisterOnShutdown(server.Close)
+	go func() {
+		if err := d.control.Serve(listener); err != http.ErrServerClosed {
+			logger.Errorf("Control API stopped: %s", err)
+		}
+	}()
+	return nil
+}
+
+// serveRPC serves the gRPC service of the node, requiring a token if
+// it is not empty
+func (d *daemon) serveRPC(c Config, token string) error {
+	listener, err := control.Listen(c.RPC)
+	if err != nil {
+		return err
+	}
+	var opts []grpc.ServerOption
+	if token != "" {
+		opts = rpc.TokenAuth(token)
+	}
+	d.rpcAddr = listener.Addr().String()
+	d.rpc = grpc.NewServer(opts...)
+	d.rpcService = rpc.Register(d.rpc, d.node)
+	go func() {
+		if err := d.rpc.Serve(listener); err != nil {
+			logger.Errorf("gRPC service stopped: %s", err)
+		}
+	}()
+	return nil
+}
+
+// stop closes the control API, the gRPC service and the node, waiting
+// for in-flight requests and events until ctx is done, and then the
+// reasoner and the store
+func (d *daemon) stop(ctx context.Context) error {
+	var err error
+	if d.control != nil {
+		err = d.control.Shutdown(ctx)
+	}
+	if d.rpc != nil {
+		d.rpcService.Close()
+		stopped := make(chan struct{})
+		go func() {
+			d.rpc.GracefulStop()
+			close(stopped)
+		}()
+		select {
+		case <-stopped:
+		case <-ctx.Done():
+			d.rpc.Stop()
+		}
+	}
+	if nerr := d.node.Close(ctx); err == nil {
+		err = nerr
+	}
+	if rerr := d.reasoner.Close(); err == nil {
+		err = rerr
+	}
+	if serr := d.store.Close(); err == nil {
+		err = serr
+	}
+	return err
+}
@@ -0,0 +1,89 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"os"
+	"testing"
+
+	"github.com/mikelsr/nahs/control"
+	"github.com/mikelsr/nahs/rpc"
+	"github.com/mikelsr/nahs/rpc/pb"
+	"google.golang.org/grpc"
+)
+
+func TestStartDaemon(t *testing.T) {
+	dir, path := writeTestConfig(t, `{
+		"listen": ["/ip4/127.0.0.1/tcp/0"],
+		"discovery": "mdns",
+		"control": "127.0.0.1:0",
+		"control_token": "control.token",
+		"rpc": "unix:rpc.sock",
+		"metrics": true,
+		"protocols": [{"file": "a.bspl", "roles": ["Ra", "Rb"]}]
+	}`)
+	defer os.RemoveAll(dir)
+	c, err := LoadConfig(path)
+	if err != nil {
+		t.Log(err)
+		t.FailNow()
+	}
+
+	d, err := startDaemon(c)
+	if err != nil {
+		t.Log(err)
+		t.FailNow()
+	}
+	id := d.node.ID()
+	// the control API is served with the generated token
+	client := control.NewClient(d.controlAddr)
+	if _, err := client.Contacts(context.Background()); err == nil {
+		t.FailNow()
+	}
+	if client.Token, err = control.LoadToken(c.ControlToken); err != nil {
+		t.Log(err)
+		t.FailNow()
+	}
+	services, err := client.Protocols(context.Background())
+	if err != nil || len(services) != 1 {
+		t.Log(err)
+		t.FailNow()
+	}
+	// along with the metrics
+	req, _ := http.NewRequest(http.MethodGet, "http://"+d.controlAddr+"/metrics", nil)
+	req.Header.Set("Authorization", "Bearer "+client.Token)
+	metrics, err := http.DefaultClient.Do(req)
+	if err != nil || metrics.StatusCode != http.StatusOK {
+		t.Log(err)
+		t.FailNow()
+	}
+	metrics.Body.Close()
+	// and so is the gRPC service
+	conn, err := grpc.Dial(c.RPC, grpc.WithInsecure(), rpc.TokenCredentials(client.Token))
+	if err != nil {
+		t.Log(err)
+		t.FailNow()
+	}
+	res, err := pb.NewNodeClient(conn).ID(context.Background(), &pb.IDRequest{})
+	conn.Close()
+	if err != nil || res.Id != id.Pretty() {
+		t.Log(err)
+		t.FailNow()
+	}
+	if err := d.stop(context.Background()); err != nil {
+		t.Log(err)
+		t.FailNow()
+	}
+	// the identity is kept between runs
+	if d, err = startDaemon(c); err != nil {
+		t.Log(err)
+		t.FailNow()
+	}
+	if d.node.ID() != id {
+		t.FailNow()
+	}
+	if err := d.stop(context.Background()); err != nil {
+		t.Log(err)
+		t.FailNow()
+	}
+}
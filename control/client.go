@@ -0,0 +1,161 @@
+package control
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/libp2p/go-libp2p-core/peer"
+	"github.com/mikelsr/nahs/net"
+)
+
+// Client calls the control API of a node
+type Client struct {
+	// URL of the control API, such as http://127.0.0.1:4101
+	URL string
+	// HTTP client the requests are sent with
+	HTTP *http.Client
+	// Token sent in the requests if the server requires one
+	Token string
+}
+
+// NewClient returns a Client for the control API listening on addr,
+// either a host and port, a URL or a Unix socket as "unix:<path>"
+func NewClient(addr string) *Client {
+	if path, ok := socketPath(addr); ok {
+		return &Client{URL: "http://" + unixHost, HTTP: unixClient(path)}
+	}
+	url := addr
+	if !strings.HasPrefix(url, "http://") && !strings.HasPrefix(url, "https://") {
+		url = "http://" + url
+	}
+	return &Client{URL: strings.TrimRight(url, "/"), HTTP: http.DefaultClient}
+}
+
+// Contacts returns the contacts of the node sorted by ID
+func (c *Client) Contacts(ctx context.Context) ([]Contact, error) {
+	var contacts []Contact
+	err := c.do(ctx, http.MethodGet, pathContacts, nil, &contacts)
+	return contacts, err
+}
+
+// FindContacts returns the contacts of the node matching a query
+// sorted by ID
+func (c *Client) FindContacts(ctx context.Context, query net.ContactQuery) ([]Contact, error) {
+	values := make(url.Values)
+	if query.ProtocolKey != "" {
+		values.Set("protocol_key", query.ProtocolKey)
+	}
+	if query.ProtocolName != "" {
+		values.Set("protocol", query.ProtocolName)
+	}
+	for _, role := range query.Roles {
+		values.Add("role", string(role))
+	}
+	if query.Connected {
+		values.Set("connected", "true")
+	}
+	if query.SeenWithin > 0 {
+		values.Set("seen_within", query.SeenWithin.String())
+	}
+	var contacts []Contact
+	err := c.do(ctx, http.MethodGet, pathContacts+"?"+values.Encode(), nil, &contacts)
+	return contacts, err
+}
+
+// Instances returns the instances open in the node sorted by key
+func (c *Client) Instances(ctx context.Context) ([]Instance, error) {
+	var instances []Instance
+	err := c.do(ctx, http.MethodGet, pathInstances, nil, &instances)
+	return instances, err
+}
+
+// ProtocolInstances returns the instances of a protocol kept by the
+// reasoner of the node, with their values, sorted by key
+func (c *Client) ProtocolInstances(ctx context.Context, protocolKey string) ([]Instance, error) {
+	values := url.Values{"protocol_key": {protocolKey}}
+	var instances []Instance
+	err := c.do(ctx, http.MethodGet, pathInstances+"?"+values.Encode(), nil, &instances)
+	return instances, err
+}
+
+// Protocols returns the services of the node sorted by protocol key
+func (c *Client) Protocols(ctx context.Context) ([]Service, error) {
+	var services []Service
+	err := c.do(ctx, http.MethodGet, pathProtocols, nil, &services)
+	return services, err
+}
+
+// Ping makes the node ping a peer and returns the round trip time
+func (c *Client) Ping(ctx context.Context, id peer.ID) (time.Duration, error) {
+	var response PingResponse
+	err := c.do(ctx, http.MethodPost, pathPing, PingRequest{Peer: id}, &response)
+	return response.RTT, err
+}
+
+// AddProtocol adds a protocol to the node given its BSPL source and
+// the roles the node plays in it
+func (c *Client) AddProtocol(ctx context.Context, source string, roles ...string) (Service, error) {
+	var service Service
+	err := c.do(ctx, http.MethodPost, pathProtocols, ProtocolRequest{Protocol: source, Roles: roles}, &service)
+	return service, err
+}
+
+// SendEvent makes the node create an event and send it
+func (c *Client) SendEvent(ctx context.Context, req EventRequest) (EventResponse, error) {
+	var response EventResponse
+	err := c.do(ctx, http.MethodPost, pathEvents, req, &response)
+	return response, err
+}
+
+// do sends a request with an optional JSON body and decodes the
+// JSON response into v
+func (c *Client) do(ctx context.Context, method, path string, body, v interface{}) error {
+	res, err := c.send(ctx, method, path, body)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+	return json.NewDecoder(res.Body).Decode(v)
+}
+
+// send sends a request with an optional JSON body, returning an
+// error with the message of the server if it failed
+func (c *Client) send(ctx context.Context, method, path string, body interface{}) (*http.Response, error) {
+	var b bytes.Buffer
+	if body != nil {
+		if err := json.NewEncoder(&b).Encode(body); err != nil {
+			return nil, err
+		}
+	}
+	req, err := http.NewRequest(method, c.URL+path, &b)
+	if err != nil {
+		return nil, err
+	}
+	req = req.WithContext(ctx)
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	if c.Token != "" {
+		req.Header.Set("Authorization", bearerPrefix+c.Token)
+	}
+	res, err := c.HTTP.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if res.StatusCode != http.StatusOK {
+		defer res.Body.Close()
+		path = strings.SplitN(path, "?", 2)[0]
+		var e errorResponse
+		if err := json.NewDecoder(res.Body).Decode(&e); err != nil || e.Error == "" {
+			return nil, fmt.Errorf("%s %s: %s", method, path, res.Status)
+		}
+		return nil, fmt.Errorf("%s %s: %s", method, path, e.Error)
+	}
+	return res, nil
+}
@@ -0,0 +1,12 @@
+package control
+
+import "errors"
+
+var (
+	// ErrEmptyToken is returned when configuring token
+	// authentication with an empty token
+	ErrEmptyToken = errors.New("Control API token can't be empty")
+	// ErrNotLocal is returned when listening on a TCP address
+	// reachable from other hosts
+	ErrNotLocal = errors.New("Control API must listen on a loopback address or a Unix socket")
+)
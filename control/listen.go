@@ -0,0 +1,139 @@
+package control
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+const (
+	// unixPrefix marks the addresses of Unix sockets
+	unixPrefix = "unix:"
+	// socketPerm are the permissions of the Unix sockets
+	socketPerm = 0600
+	// unixHost is the host of the URLs of the requests sent
+	// through a Unix socket, accepted by localOnly
+	unixHost = "localhost"
+)
+
+// Listen listens on a loopback TCP address such as DefaultAddr, or
+// on a Unix socket given as "unix:<path>". A stale socket file left
+// in path is removed and the new one is only accessible by its owner.
+func Listen(addr string) (net.Listener, error) {
+	if path, ok := socketPath(addr); ok {
+		return listenUnix(path)
+	}
+	if !isLoopback(addr) {
+		return nil, ErrNotLocal
+	}
+	return net.Listen("tcp", addr)
+}
+
+// listenUnix listens on a Unix socket in path. The socket is created
+// in a directory only accessible by its owner and moved to path once
+// its permissions are restricted, so other users can't connect to it
+// in between.
+func listenUnix(path string) (net.Listener, error) {
+	if info, err := os.Stat(path); err == nil && info.Mode()&os.ModeSocket != 0 {
+		os.Remove(path)
+	}
+	dir, err := ioutil.TempDir(filepath.Dir(path), ".nahs")
+	if err != nil {
+		return nil, err
+	}
+	defer os.RemoveAll(dir)
+	tmp := filepath.Join(dir, "sock")
+	listener, err := net.ListenUnix("unix", &net.UnixAddr{Name: tmp, Net: "unix"})
+	if err != nil {
+		return nil, err
+	}
+	// the socket is removed from path instead
+	listener.SetUnlinkOnClose(false)
+	if err := os.Chmod(tmp, socketPerm); err != nil {
+		listener.Close()
+		return nil, err
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		listener.Close()
+		return nil, err
+	}
+	return &unixListener{UnixListener: listener, path: path}, nil
+}
+
+// unixListener is a Unix socket moved to path after it was created
+type unixListener struct {
+	*net.UnixListener
+	path string
+}
+
+// Addr returns the address of the socket in path
+func (l *unixListener) Addr() net.Addr {
+	return &net.UnixAddr{Name: l.path, Net: "unix"}
+}
+
+// Close stops listening and removes the socket
+func (l *unixListener) Close() error {
+	err := l.UnixListener.Close()
+	os.Remove(l.path)
+	return err
+}
+
+// socketPath returns the path of the Unix socket of an address
+func socketPath(addr string) (string, bool) {
+	if !strings.HasPrefix(addr, unixPrefix) {
+		return "", false
+	}
+	return strings.TrimPrefix(addr, unixPrefix), true
+}
+
+// isLoopback checks if a TCP address is only reachable from the
+// local host
+func isLoopback(addr string) bool {
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		return false
+	}
+	return isLoopbackHost(host)
+}
+
+// isLoopbackHost checks if a host name or IP is the local host
+func isLoopbackHost(host string) bool {
+	if host == "localhost" {
+		return true
+	}
+	ip := net.ParseIP(host)
+	return ip != nil && ip.IsLoopback()
+}
+
+// localOnly rejects the requests whose Host header is not the local
+// host, so pages loaded by a browser can't reach the API by
+// rebinding their domain to a loopback address
+func localOnly(h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		host := r.Host
+		if h, _, err := net.SplitHostPort(host); err == nil {
+			host = h
+		}
+		if !isLoopbackHost(strings.Trim(host, "[]")) {
+			writeError(w, http.StatusForbidden, fmt.Errorf("Invalid host '%s'", r.Host))
+			return
+		}
+		h.ServeHTTP(w, r)
+	})
+}
+
+// unixClient returns an HTTP client sending its requests through a
+// Unix socket
+func unixClient(path string) *http.Client {
+	dialer := new(net.Dialer)
+	return &http.Client{Transport: &http.Transport{
+		DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+			return dialer.DialContext(ctx, "unix", path)
+		},
+	}}
+}
@@ -0,0 +1,53 @@
+package control
+
+import (
+	"errors"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// Option configures a Server when it is created
+type Option func(*options) error
+
+// options used to create a Server
+type options struct {
+	// token required in the requests, none if empty
+	token string
+	// metrics served in pathMetrics, may be nil
+	metrics prometheus.Gatherer
+}
+
+// applyOptions builds the options of a Server
+func applyOptions(opts ...Option) (*options, error) {
+	o := new(options)
+	for _, opt := range opts {
+		if err := opt(o); err != nil {
+			return nil, err
+		}
+	}
+	return o, nil
+}
+
+// WithToken requires every request to carry the token in an
+// "Authorization: Bearer <token>" header
+func WithToken(token string) Option {
+	return func(o *options) error {
+		if token == "" {
+			return ErrEmptyToken
+		}
+		o.token = token
+		return nil
+	}
+}
+
+// WithMetrics serves the metrics of gatherer in the Prometheus text
+// format on /metrics, such as the registry passed to net.WithMetrics
+func WithMetrics(gatherer prometheus.Gatherer) Option {
+	return func(o *options) error {
+		if gatherer == nil {
+			return errors.New("Metrics gatherer can't be nil")
+		}
+		o.metrics = gatherer
+		return nil
+	}
+}
@@ -0,0 +1,476 @@
+package control
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"mime"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/libp2p/go-libp2p-core/peer"
+	"github.com/mikelsr/bspl"
+	imp "github.com/mikelsr/bspl/implementation"
+	"github.com/mikelsr/nahs/events"
+	"github.com/mikelsr/nahs/net"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+// maxRequestSize limits the size of the request bodies
+const maxRequestSize = 1 << 20
+
+// Server is an http.Handler serving the control API of a node
+type Server struct {
+	node    *net.Node
+	handler http.Handler
+	token   string
+	// done is closed to end the event streams
+	done      chan struct{}
+	closeOnce sync.Once
+}
+
+// NewServer is the default constructor for Server
+func NewServer(node *net.Node, opts ...Option) (*Server, error) {
+	o, err := applyOptions(opts...)
+	if err != nil {
+		return nil, err
+	}
+	s := &Server{node: node, token: o.token, done: make(chan struct{})}
+	mux := http.NewServeMux()
+	mux.HandleFunc(pathContacts, s.methods(map[string]http.HandlerFunc{http.MethodGet: s.contacts}))
+	mux.HandleFunc(pathInstances, s.methods(map[string]http.HandlerFunc{http.MethodGet: s.instances}))
+	mux.HandleFunc(pathPing, s.methods(map[string]http.HandlerFunc{http.MethodPost: s.ping}))
+	mux.HandleFunc(pathProtocols, s.methods(map[string]http.HandlerFunc{
+		http.MethodGet:  s.protocols,
+		http.MethodPost: s.addProtocol,
+	}))
+	mux.HandleFunc(pathEvents, s.methods(map[string]http.HandlerFunc{http.MethodPost: s.sendEvent}))
+	mux.HandleFunc(pathStream, s.methods(map[string]http.HandlerFunc{http.MethodGet: s.stream}))
+	if o.metrics != nil {
+		metrics := promhttp.HandlerFor(o.metrics, promhttp.HandlerOpts{})
+		mux.HandleFunc(pathMetrics, s.methods(map[string]http.HandlerFunc{http.MethodGet: metrics.ServeHTTP}))
+	}
+	s.handler = mux
+	if s.token != "" {
+		s.handler = s.authorize(mux)
+	}
+	s.handler = localOnly(s.handler)
+	return s, nil
+}
+
+// ServeHTTP implements http.Handler
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.handler.ServeHTTP(w, r)
+}
+
+// Close ends the open event streams, which would otherwise keep
+// http.Server.Shutdown waiting. It can be registered with
+// http.Server.RegisterOnShutdown.
+func (s *Server) Close() {
+	s.closeOnce.Do(func() { close(s.done) })
+}
+
+// methods dispatches the requests by their method
+func (s *Server) methods(handlers map[string]http.HandlerFunc) http.HandlerFunc {
+	allowed := make([]string, 0, len(handlers))
+	for method := range handlers {
+		allowed = append(allowed, method)
+	}
+	sort.Strings(allowed)
+	return func(w http.ResponseWriter, r *http.Request) {
+		h, found := handlers[r.Method]
+		if !found {
+			w.Header().Set("Allow", strings.Join(allowed, ", "))
+			writeError(w, http.StatusMethodNotAllowed, errors.New("Method not allowed"))
+			return
+		}
+		h(w, r)
+	}
+}
+
+// contacts lists the contacts of the node. If the request has any
+// of the protocol_key, protocol, role, connected or seen_within
+// query parameters only the matching contacts are listed.
+func (s *Server) contacts(w http.ResponseWriter, r *http.Request) {
+	query, filtered, err := contactQuery(r.URL.Query())
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	contacts := make([]Contact, 0)
+	add := func(id peer.ID, services net.Services) {
+		seen, _ := s.node.Contacts().LastSeen(id)
+		contacts = append(contacts, Contact{ID: id, LastSeen: seen, Services: makeServices(services)})
+	}
+	if filtered {
+		for _, id := range s.node.FindContacts(query) {
+			if services, found := s.node.Contacts().Get(id); found {
+				add(id, services)
+			}
+		}
+	} else {
+		s.node.Contacts().Range(func(id peer.ID, services net.Services) bool {
+			add(id, services)
+			return true
+		})
+	}
+	sort.Slice(contacts, func(i, j int) bool { return contacts[i].ID < contacts[j].ID })
+	writeJSON(w, http.StatusOK, contacts)
+}
+
+// instances lists the open instances of the node, or the instances
+// of a protocol kept by the reasoner with their values if the
+// request has a protocol_key query parameter
+func (s *Server) instances(w http.ResponseWriter, r *http.Request) {
+	instances := make([]Instance, 0)
+	if key := r.URL.Query().Get("protocol_key"); key != "" {
+		p, found := s.protocol(key)
+		if !found {
+			writeError(w, http.StatusNotFound, fmt.Errorf("Unknown protocol '%s'", key))
+			return
+		}
+		if s.node.Reasoner() == nil {
+			writeError(w, http.StatusServiceUnavailable, errors.New("Node has no reasoner"))
+			return
+		}
+		for _, i := range s.node.Reasoner().Instances(p) {
+			instances = append(instances, s.makeInstance(i))
+		}
+	} else {
+		s.node.OpenInstances().Range(func(key string, o net.Ownership) bool {
+			instances = append(instances, makeOwnership(key, o))
+			return true
+		})
+	}
+	sort.Slice(instances, func(i, j int) bool { return instances[i].Key < instances[j].Key })
+	writeJSON(w, http.StatusOK, instances)
+}
+
+// protocols lists the services of the node
+func (s *Server) protocols(w http.ResponseWriter, r *http.Request) {
+	services := make([]Service, 0)
+	for _, service := range s.node.Services() {
+		services = append(services, makeService(service))
+	}
+	sort.Slice(services, func(i, j int) bool { return services[i].ProtocolKey < services[j].ProtocolKey })
+	writeJSON(w, http.StatusOK, services)
+}
+
+func (s *Server) ping(w http.ResponseWriter, r *http.Request) {
+	var req PingRequest
+	if !readJSON(w, r, &req) {
+		return
+	}
+	rtt, err := s.node.Ping(r.Context(), req.Peer)
+	if err != nil {
+		writeError(w, http.StatusBadGateway, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, PingResponse{RTT: rtt})
+}
+
+func (s *Server) addProtocol(w http.ResponseWriter, r *http.Request) {
+	var req ProtocolRequest
+	if !readJSON(w, r, &req) {
+		return
+	}
+	p, err := bspl.Parse(strings.NewReader(req.Protocol))
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	roles := make([]bspl.Role, len(req.Roles))
+	for i, r := range req.Roles {
+		roles[i] = bspl.Role(r)
+		if !hasRole(p, roles[i]) {
+			writeError(w, http.StatusBadRequest, fmt.Errorf("Protocol '%s' has no role '%s'", p.Name, r))
+			return
+		}
+	}
+	s.node.AddProtocol(p, roles...)
+	writeJSON(w, http.StatusOK, makeService(net.Service{Protocol: p, Roles: roles}))
+}
+
+func (s *Server) sendEvent(w http.ResponseWriter, r *http.Request) {
+	var req EventRequest
+	if !readJSON(w, r, &req) {
+		return
+	}
+	reasoner := s.node.Reasoner()
+	if reasoner == nil {
+		writeError(w, http.StatusServiceUnavailable, errors.New("Node has no reasoner"))
+		return
+	}
+	var event events.Event
+	switch req.Type {
+	case events.TypeNewEvent:
+		instance, status, err := s.newInstance(req)
+		if err != nil {
+			writeError(w, status, err)
+			return
+		}
+		if err := reasoner.RegisterInstance(instance); err != nil {
+			writeError(w, http.StatusConflict, err)
+			return
+		}
+		event = events.MakeNewEvent(instance)
+	case events.TypeUpdateEvent:
+		instance, status, err := s.updatedInstance(req)
+		if err != nil {
+			writeError(w, status, err)
+			return
+		}
+		if err := reasoner.UpdateInstance(instance); err != nil {
+			writeError(w, http.StatusBadRequest, err)
+			return
+		}
+		event = events.MakeUpdateEvent(instance)
+	case events.TypeDropEvent:
+		if _, found := reasoner.GetInstance(req.InstanceKey); !found {
+			writeError(w, http.StatusNotFound, fmt.Errorf("Unknown instance '%s'", req.InstanceKey))
+			return
+		}
+		event = events.MakeDropEvent(req.InstanceKey, req.Motive)
+	default:
+		writeError(w, http.StatusBadRequest, fmt.Errorf("Unsupported event type '%s'", req.Type))
+		return
+	}
+
+	response := EventResponse{ID: event.ID(), InstanceKey: event.InstanceKey(), Peers: make(map[peer.ID]string)}
+	var results map[peer.ID]error
+	told := true
+	if req.Peer != "" {
+		// the instance is tracked only once the peer accepted it
+		err := s.node.SendEvent(r.Context(), req.Peer, event)
+		if err == nil {
+			s.node.TrackInstance(event)
+		}
+		told = err == nil
+		results = map[peer.ID]error{req.Peer: err}
+	} else {
+		var err error
+		results, err = s.node.Publish(r.Context(), event)
+		var errPeers net.ErrPeers
+		if err != nil && !errors.As(err, &errPeers) {
+			writeError(w, http.StatusBadRequest, err)
+			return
+		}
+	}
+	for id, err := range results {
+		response.Peers[id] = ""
+		if err != nil {
+			response.Peers[id] = err.Error()
+		}
+	}
+	// the instance is dropped once the participants were told
+	if req.Type == events.TypeDropEvent && told {
+		if err := reasoner.DropInstance(req.InstanceKey, req.Motive); err != nil {
+			writeError(w, http.StatusInternalServerError, err)
+			return
+		}
+	}
+	writeJSON(w, http.StatusOK, response)
+}
+
+// newInstance builds the instance of a new event request
+func (s *Server) newInstance(req EventRequest) (bspl.Instance, int, error) {
+	p, found := s.protocol(req.ProtocolKey)
+	if !found {
+		return nil, http.StatusNotFound, fmt.Errorf("Unknown protocol '%s'", req.ProtocolKey)
+	}
+	roles := make(bspl.Roles, len(req.Roles))
+	for r, id := range req.Roles {
+		role := bspl.Role(r)
+		if !hasRole(p, role) {
+			return nil, http.StatusBadRequest, fmt.Errorf("Protocol '%s' has no role '%s'", p.Name, r)
+		}
+		roles[role] = id
+	}
+	instance := imp.NewInstance(p, roles)
+	if err := setValues(instance, req.Values); err != nil {
+		return nil, http.StatusBadRequest, err
+	}
+	return instance, http.StatusOK, nil
+}
+
+// updatedInstance builds the instance of an update event request
+// from the version the reasoner has
+func (s *Server) updatedInstance(req EventRequest) (bspl.Instance, int, error) {
+	current, found := s.node.Reasoner().GetInstance(req.InstanceKey)
+	if !found {
+		return nil, http.StatusNotFound, fmt.Errorf("Unknown instance '%s'", req.InstanceKey)
+	}
+	b, err := current.Marshal()
+	if err != nil {
+		return nil, http.StatusInternalServerError, err
+	}
+	instance := new(imp.Instance)
+	if err := instance.Unmarshal(b); err != nil {
+		return nil, http.StatusInternalServerError, err
+	}
+	if err := setValues(instance, req.Values); err != nil {
+		return nil, http.StatusBadRequest, err
+	}
+	return instance, http.StatusOK, nil
+}
+
+// protocol searches for a protocol in the services of the node and
+// then in the ones of its contacts
+func (s *Server) protocol(key string) (bspl.Protocol, bool) {
+	for _, service := range s.node.Services() {
+		if service.Protocol.Key() == key {
+			return service.Protocol, true
+		}
+	}
+	var p bspl.Protocol
+	found := false
+	s.node.Contacts().Range(func(id peer.ID, services net.Services) bool {
+		if service, ok := services[key]; ok {
+			p, found = service.Protocol, true
+		}
+		return !found
+	})
+	return p, found
+}
+
+// setValues sets the values of the parameters of an instance,
+// failing on parameters the protocol doesn't have
+func setValues(instance bspl.Instance, values map[string]string) error {
+	for name, value := range values {
+		found := false
+		for _, param := range instance.Protocol().Parameters() {
+			if param.Name == name {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return fmt.Errorf("Protocol '%s' has no parameter '%s'", instance.Protocol().Name, name)
+		}
+		instance.SetValue(name, value)
+	}
+	return nil
+}
+
+// hasRole checks if a role is defined in a protocol
+func hasRole(p bspl.Protocol, role bspl.Role) bool {
+	for _, r := range p.Roles {
+		if r == role {
+			return true
+		}
+	}
+	return false
+}
+
+// makeOwnership converts an open instance
+func makeOwnership(key string, o net.Ownership) Instance {
+	instance := Instance{Key: key, Creator: o.Creator}
+	if len(o.Roles) > 0 {
+		instance.Roles = make(map[string]peer.ID, len(o.Roles))
+		for role, id := range o.Roles {
+			instance.Roles[string(role)] = id
+		}
+	}
+	return instance
+}
+
+// makeInstance converts an instance of the reasoner, adding its
+// creator if it is open
+func (s *Server) makeInstance(i bspl.Instance) Instance {
+	instance := Instance{Key: i.Key(), Values: make(map[string]string)}
+	if o, found := s.node.OpenInstances().Get(i.Key()); found {
+		instance.Creator = o.Creator
+	}
+	if roles := i.Roles(); len(roles) > 0 {
+		instance.Roles = make(map[string]peer.ID, len(roles))
+		for role, id := range roles {
+			if decoded, err := peer.Decode(id); err == nil {
+				instance.Roles[string(role)] = decoded
+			}
+		}
+	}
+	for _, param := range i.Protocol().Parameters() {
+		if value := i.GetValue(param.Name); value != "" {
+			instance.Values[param.Name] = value
+		}
+	}
+	return instance
+}
+
+// contactQuery builds a contact query from the query parameters of
+// a request. filtered reports whether any of them was set.
+func contactQuery(values url.Values) (query net.ContactQuery, filtered bool, err error) {
+	query.ProtocolKey = values.Get("protocol_key")
+	query.ProtocolName = values.Get("protocol")
+	for _, role := range values["role"] {
+		query.Roles = append(query.Roles, bspl.Role(role))
+	}
+	if v := values.Get("connected"); v != "" {
+		if query.Connected, err = strconv.ParseBool(v); err != nil {
+			return query, false, fmt.Errorf("Invalid connected '%s'", v)
+		}
+	}
+	if v := values.Get("seen_within"); v != "" {
+		if query.SeenWithin, err = time.ParseDuration(v); err != nil {
+			return query, false, fmt.Errorf("Invalid seen_within '%s'", v)
+		}
+	}
+	filtered = query.ProtocolKey != "" || query.ProtocolName != "" || len(query.Roles) > 0 ||
+		query.Connected || query.SeenWithin > 0
+	return query, filtered, nil
+}
+
+// makeServices converts the services of a contact sorted by
+// protocol key
+func makeServices(services net.Services) []Service {
+	converted := make([]Service, 0, len(services))
+	for _, s := range services {
+		converted = append(converted, makeService(s))
+	}
+	sort.Slice(converted, func(i, j int) bool { return converted[i].ProtocolKey < converted[j].ProtocolKey })
+	return converted
+}
+
+// makeService converts a service
+func makeService(s net.Service) Service {
+	roles := make([]string, len(s.Roles))
+	for i, r := range s.Roles {
+		roles[i] = string(r)
+	}
+	return Service{ProtocolKey: s.Protocol.Key(), Protocol: s.Protocol.Name, Roles: roles}
+}
+
+// readJSON decodes the body of a request, writing an error response
+// if it is not valid. Only JSON bodies are accepted, so browsers
+// can't send requests to the API from other sites without a CORS
+// preflight.
+func readJSON(w http.ResponseWriter, r *http.Request, v interface{}) bool {
+	if t, _, err := mime.ParseMediaType(r.Header.Get("Content-Type")); err != nil || t != "application/json" {
+		writeError(w, http.StatusUnsupportedMediaType, errors.New("Content-Type must be application/json"))
+		return false
+	}
+	decoder := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxRequestSize))
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(v); err != nil {
+		writeError(w, http.StatusBadRequest, fmt.Errorf("Invalid request: %s", err))
+		return false
+	}
+	return true
+}
+
+// writeJSON writes a JSON response
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
+
+// writeError writes an error response
+func writeError(w http.ResponseWriter, status int, err error) {
+	writeJSON(w, status, errorResponse{Error: err.Error()})
+}
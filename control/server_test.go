@@ -0,0 +1,505 @@
+package control
+
+import (
+	"context"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/libp2p/go-libp2p-core/peerstore"
+	"github.com/mikelsr/bspl"
+	imp "github.com/mikelsr/bspl/implementation"
+	"github.com/mikelsr/nahs/events"
+	"github.com/mikelsr/nahs/net"
+	"github.com/mikelsr/nahs/reasoner"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+const testProtocol = `Purchase {
+	role Buyer, Seller
+	parameter out ID key, out item, out price
+
+	Buyer -> Seller: Request[out ID, out item]
+	Seller -> Buyer: Offer[in ID, in item, out price]
+}`
+
+// testNode is a node with a persistent reasoner served by a
+// control server
+type testNode struct {
+	node     *net.Node
+	reasoner *reasoner.Reasoner
+	server   *httptest.Server
+	client   *Client
+}
+
+func (n testNode) close() {
+	n.server.Close()
+	n.node.Close(context.Background())
+	n.reasoner.Close()
+}
+
+// testNodes creates n nodes that know the addresses of each other
+func testNodes(t *testing.T, dir string, n int) []testNode {
+	nodes := make([]testNode, n)
+	for i := range nodes {
+		r, err := reasoner.Open(filepath.Join(dir, string(rune('a'+i))+".db"))
+		if err != nil {
+			t.Log(err)
+			t.FailNow()
+		}
+		node, err := net.LocalNode(r, net.WithListenAddrs("/ip4/127.0.0.1/tcp/0"))
+		if err != nil {
+			t.Log(err)
+			t.FailNow()
+		}
+		handler, err := NewServer(node)
+		if err != nil {
+			t.Log(err)
+			t.FailNow()
+		}
+		server := httptest.NewServer(handler)
+		nodes[i] = testNode{node: node, reasoner: r, server: server, client: NewClient(server.URL)}
+	}
+	for _, a := range nodes {
+		for _, b := range nodes {
+			if a.node != b.node {
+				a.node.Peerstore().AddAddrs(b.node.ID(), b.node.Addrs(), peerstore.PermanentAddrTTL)
+			}
+		}
+	}
+	return nodes
+}
+
+func TestServer(t *testing.T) {
+	dir, err := ioutil.TempDir("", "nahs-control")
+	if err != nil {
+		t.Log(err)
+		t.FailNow()
+	}
+	defer os.RemoveAll(dir)
+	n := testNodes(t, dir, 2)
+	buyer, seller := n[0], n[1]
+	defer buyer.close()
+	defer seller.close()
+	ctx := context.Background()
+
+	// protocols
+	service, err := buyer.client.AddProtocol(ctx, testProtocol, "Buyer")
+	if err != nil || service.Protocol != "Purchase" || len(service.Roles) != 1 {
+		t.Log(err)
+		t.FailNow()
+	}
+	if _, err := buyer.client.AddProtocol(ctx, testProtocol, "Other"); err == nil {
+		t.FailNow()
+	}
+	if _, err := buyer.client.AddProtocol(ctx, "invalid", "Buyer"); err == nil {
+		t.FailNow()
+	}
+	p, _ := bspl.Parse(strings.NewReader(testProtocol))
+	seller.node.AddProtocol(p, "Seller")
+
+	// contacts
+	buyer.node.Contacts().Put(seller.node.ID(), net.Services{p.Key(): {Protocol: p, Roles: []bspl.Role{"Seller"}}})
+	contacts, err := buyer.client.Contacts(ctx)
+	if err != nil || len(contacts) != 1 || contacts[0].ID != seller.node.ID() ||
+		contacts[0].Services[0].ProtocolKey != p.Key() || contacts[0].LastSeen.IsZero() {
+		t.Log(err)
+		t.FailNow()
+	}
+
+	found, err := buyer.client.FindContacts(ctx, net.ContactQuery{ProtocolKey: p.Key(), Roles: []bspl.Role{"Seller"}})
+	if err != nil || len(found) != 1 || found[0].ID != seller.node.ID() {
+		t.Log(err)
+		t.FailNow()
+	}
+	if found, err := buyer.client.FindContacts(ctx, net.ContactQuery{Roles: []bspl.Role{"Buyer"}}); err != nil || len(found) != 0 {
+		t.Log(err)
+		t.FailNow()
+	}
+	if services, err := buyer.client.Protocols(ctx); err != nil || len(services) != 1 || services[0].ProtocolKey != p.Key() {
+		t.Log(err)
+		t.FailNow()
+	}
+
+	// ping
+	if rtt, err := buyer.client.Ping(ctx, seller.node.ID()); err != nil || rtt <= 0 {
+		t.Log(err)
+		t.FailNow()
+	}
+
+	// the seller streams the events it runs
+	streamCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	stream, err := seller.client.Events(streamCtx, net.EventFilter{Types: []events.EventType{events.TypeNewEvent}})
+	if err != nil {
+		t.Log(err)
+		t.FailNow()
+	}
+
+	// the buyer creates an instance and sends it to the seller, which
+	// can then send it events for the instance
+	created, err := buyer.client.SendEvent(ctx, EventRequest{
+		Type:        events.TypeNewEvent,
+		Peer:        seller.node.ID(),
+		ProtocolKey: p.Key(),
+		Roles:       map[string]string{"Buyer": buyer.node.ID().Pretty(), "Seller": seller.node.ID().Pretty()},
+		Values:      map[string]string{"ID": "1", "item": "apple"},
+	})
+	if err != nil || len(created.Peers) != 1 || created.Peers[seller.node.ID()] != "" {
+		t.Log(err, created)
+		t.FailNow()
+	}
+	select {
+	case e := <-stream:
+		if e.ID != created.ID || e.Type != events.TypeNewEvent || e.InstanceKey != created.InstanceKey || len(e.Event) == 0 {
+			t.Log(e)
+			t.FailNow()
+		}
+	case <-time.After(5 * time.Second):
+		t.FailNow()
+	}
+	instances, err := seller.client.Instances(ctx)
+	if err != nil || len(instances) != 1 || instances[0].Key != created.InstanceKey ||
+		instances[0].Creator != buyer.node.ID() || instances[0].Roles["Seller"] != seller.node.ID() {
+		t.Log(err)
+		t.FailNow()
+	}
+
+	// the seller makes an offer
+	updated, err := seller.client.SendEvent(ctx, EventRequest{
+		Type:        events.TypeUpdateEvent,
+		InstanceKey: created.InstanceKey,
+		Values:      map[string]string{"price": "10"},
+	})
+	if err != nil || updated.Peers[buyer.node.ID()] != "" {
+		t.Log(err, updated)
+		t.FailNow()
+	}
+	if i, found := buyer.reasoner.GetInstance(created.InstanceKey); !found || i.GetValue("price") != "10" {
+		t.FailNow()
+	}
+	instances, err = buyer.client.ProtocolInstances(ctx, p.Key())
+	if err != nil || len(instances) != 1 || instances[0].Values["price"] != "10" ||
+		instances[0].Roles["Seller"] != seller.node.ID() {
+		t.Log(err, instances)
+		t.FailNow()
+	}
+
+	// the buyer drops it
+	if _, err := buyer.client.SendEvent(ctx, EventRequest{
+		Type:        events.TypeDropEvent,
+		Peer:        seller.node.ID(),
+		InstanceKey: created.InstanceKey,
+		Motive:      "too expensive",
+	}); err != nil {
+		t.Log(err)
+		t.FailNow()
+	}
+	if motive, found := seller.reasoner.Dropped(created.InstanceKey); !found || motive != "too expensive" {
+		t.FailNow()
+	}
+	if _, found := buyer.reasoner.GetInstance(created.InstanceKey); found {
+		t.FailNow()
+	}
+	if _, found := buyer.node.OpenInstances().Get(created.InstanceKey); found {
+		t.FailNow()
+	}
+
+	// invalid requests
+	for _, req := range []EventRequest{
+		{Type: "unknown"},
+		{Type: events.TypeNewEvent, ProtocolKey: "unknown"},
+		{Type: events.TypeNewEvent, ProtocolKey: p.Key(), Values: map[string]string{"unknown": "1"}},
+		{Type: events.TypeUpdateEvent, InstanceKey: created.InstanceKey},
+		{Type: events.TypeDropEvent, InstanceKey: created.InstanceKey},
+	} {
+		if _, err := buyer.client.SendEvent(ctx, req); err == nil {
+			t.Log(req)
+			t.FailNow()
+		}
+	}
+	res, err := http.Post(buyer.server.URL+pathContacts, "application/json", nil)
+	if err != nil || res.StatusCode != http.StatusMethodNotAllowed {
+		t.FailNow()
+	}
+	res.Body.Close()
+}
+
+func TestServer_rejectedEvents(t *testing.T) {
+	dir, err := ioutil.TempDir("", "nahs-control")
+	if err != nil {
+		t.Log(err)
+		t.FailNow()
+	}
+	defer os.RemoveAll(dir)
+	n := testNodes(t, dir, 2)
+	buyer, seller := n[0], n[1]
+	defer buyer.close()
+	defer seller.close()
+	ctx := context.Background()
+	p, _ := bspl.Parse(strings.NewReader(testProtocol))
+	buyer.node.AddProtocol(p, "Buyer")
+	roles := bspl.Roles{"Buyer": buyer.node.ID().Pretty(), "Seller": seller.node.ID().Pretty()}
+
+	// the seller rejects an instance it already holds, so the buyer
+	// doesn't open it
+	existing := imp.NewInstance(p, roles)
+	existing.SetValue("ID", "1")
+	existing.SetValue("item", "apple")
+	if err := seller.reasoner.RegisterInstance(existing); err != nil {
+		t.Log(err)
+		t.FailNow()
+	}
+	created, err := buyer.client.SendEvent(ctx, EventRequest{
+		Type:        events.TypeNewEvent,
+		Peer:        seller.node.ID(),
+		ProtocolKey: p.Key(),
+		Roles:       map[string]string{"Buyer": roles["Buyer"], "Seller": roles["Seller"]},
+		Values:      map[string]string{"ID": "1", "item": "apple"},
+	})
+	if err != nil || created.Peers[seller.node.ID()] == "" {
+		t.Log(err, created)
+		t.FailNow()
+	}
+	if _, found := buyer.node.OpenInstances().Get(created.InstanceKey); found {
+		t.FailNow()
+	}
+
+	// the seller rejects dropping an instance it doesn't know, so the
+	// buyer keeps it
+	unknown := imp.NewInstance(p, roles)
+	unknown.SetValue("ID", "2")
+	if err := buyer.reasoner.RegisterInstance(unknown); err != nil {
+		t.Log(err)
+		t.FailNow()
+	}
+	buyer.node.OpenInstances().Put(unknown.Key(), net.Ownership{Creator: buyer.node.ID()})
+	dropped, err := buyer.client.SendEvent(ctx, EventRequest{
+		Type:        events.TypeDropEvent,
+		Peer:        seller.node.ID(),
+		InstanceKey: unknown.Key(),
+		Motive:      "_",
+	})
+	if err != nil || dropped.Peers[seller.node.ID()] == "" {
+		t.Log(err, dropped)
+		t.FailNow()
+	}
+	if _, found := buyer.node.OpenInstances().Get(unknown.Key()); !found {
+		t.FailNow()
+	}
+	if _, found := buyer.reasoner.GetInstance(unknown.Key()); !found {
+		t.FailNow()
+	}
+}
+
+func TestServer_localOnly(t *testing.T) {
+	dir, err := ioutil.TempDir("", "nahs-control")
+	if err != nil {
+		t.Log(err)
+		t.FailNow()
+	}
+	defer os.RemoveAll(dir)
+	n := testNodes(t, dir, 1)[0]
+	defer n.close()
+
+	// requests for other hosts, such as rebound domains, are rejected
+	req, _ := http.NewRequest(http.MethodGet, n.server.URL+pathContacts, nil)
+	req.Host = "attacker.example:4101"
+	res, err := http.DefaultClient.Do(req)
+	if err != nil || res.StatusCode != http.StatusForbidden {
+		t.Log(err)
+		t.FailNow()
+	}
+	res.Body.Close()
+	for _, host := range []string{"localhost:4101", "[::1]:4101", "127.0.0.1"} {
+		req.Host = host
+		res, err := http.DefaultClient.Do(req)
+		if err != nil || res.StatusCode != http.StatusOK {
+			t.Log(host, err)
+			t.FailNow()
+		}
+		res.Body.Close()
+	}
+
+	// bodies that browsers send without a preflight are rejected
+	body := `{"protocol": "invalid\n", "roles": []}`
+	res, err = http.Post(n.server.URL+pathProtocols, "text/plain", strings.NewReader(body))
+	if err != nil || res.StatusCode != http.StatusUnsupportedMediaType {
+		t.Log(err)
+		t.FailNow()
+	}
+	res.Body.Close()
+	res, err = http.Post(n.server.URL+pathProtocols, "application/json; charset=utf-8", strings.NewReader(body))
+	if err != nil || res.StatusCode != http.StatusBadRequest {
+		t.Log(err)
+		t.FailNow()
+	}
+	res.Body.Close()
+}
+
+func TestToken(t *testing.T) {
+	dir, err := ioutil.TempDir("", "nahs-control")
+	if err != nil {
+		t.Log(err)
+		t.FailNow()
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "control", "token")
+	token, created, err := LoadOrCreateToken(path)
+	if err != nil || !created || token == "" {
+		t.Log(err)
+		t.FailNow()
+	}
+	if info, err := os.Stat(path); err != nil || info.Mode().Perm() != tokenPerm {
+		t.FailNow()
+	}
+	if loaded, created, err := LoadOrCreateToken(path); err != nil || created || loaded != token {
+		t.Log(err)
+		t.FailNow()
+	}
+	if _, err := NewServer(nil, WithToken("")); err != ErrEmptyToken {
+		t.FailNow()
+	}
+
+	node, err := net.LocalNode(nil, net.WithListenAddrs("/ip4/127.0.0.1/tcp/0"))
+	if err != nil {
+		t.Log(err)
+		t.FailNow()
+	}
+	defer node.Close(context.Background())
+	handler, err := NewServer(node, WithToken(token))
+	if err != nil {
+		t.Log(err)
+		t.FailNow()
+	}
+	server := httptest.NewServer(handler)
+	defer server.Close()
+	client := NewClient(server.URL)
+	for _, wrong := range []string{"", "wrong"} {
+		client.Token = wrong
+		if _, err := client.Contacts(context.Background()); err == nil {
+			t.FailNow()
+		}
+	}
+	client.Token = token
+	if _, err := client.Contacts(context.Background()); err != nil {
+		t.Log(err)
+		t.FailNow()
+	}
+}
+
+func TestListen(t *testing.T) {
+	for _, addr := range []string{"0.0.0.0:0", ":0", "invalid"} {
+		if _, err := Listen(addr); err == nil {
+			t.Log(addr)
+			t.FailNow()
+		}
+	}
+	dir, err := ioutil.TempDir("", "nahs-control")
+	if err != nil {
+		t.Log(err)
+		t.FailNow()
+	}
+	defer os.RemoveAll(dir)
+	node, err := net.LocalNode(nil, net.WithListenAddrs("/ip4/127.0.0.1/tcp/0"))
+	if err != nil {
+		t.Log(err)
+		t.FailNow()
+	}
+	defer node.Close(context.Background())
+	handler, err := NewServer(node)
+	if err != nil {
+		t.Log(err)
+		t.FailNow()
+	}
+
+	path := filepath.Join(dir, "control.sock")
+	addr := unixPrefix + path
+	listener, err := Listen(addr)
+	if err != nil {
+		t.Log(err)
+		t.FailNow()
+	}
+	// the socket is only accessible by its owner and is the only
+	// file left in the directory
+	if info, err := os.Stat(path); err != nil || info.Mode().Perm() != socketPerm ||
+		listener.Addr().String() != path {
+		t.Log(err)
+		t.FailNow()
+	}
+	if files, err := ioutil.ReadDir(dir); err != nil || len(files) != 1 {
+		t.Log(err)
+		t.FailNow()
+	}
+	server := &http.Server{Handler: handler}
+	server.RegisterOnShutdown(handler.Close)
+	go server.Serve(listener)
+	client := NewClient(addr)
+	if _, err := client.Contacts(context.Background()); err != nil {
+		t.Log(err)
+		t.FailNow()
+	}
+
+	// open event streams don't block the shutdown
+	stream, err := client.Events(context.Background(), net.EventFilter{})
+	if err != nil {
+		t.Log(err)
+		t.FailNow()
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := server.Shutdown(ctx); err != nil {
+		t.Log(err)
+		t.FailNow()
+	}
+	if _, ok := <-stream; ok {
+		t.FailNow()
+	}
+	// closing the listener removes the socket
+	if _, err := os.Stat(path); !os.IsNotExist(err) {
+		t.Log(err)
+		t.FailNow()
+	}
+}
+
+func TestWithMetrics(t *testing.T) {
+	registry := prometheus.NewRegistry()
+	node, err := net.LocalNode(nil, net.WithListenAddrs("/ip4/127.0.0.1/tcp/0"), net.WithMetrics(registry))
+	if err != nil {
+		t.Log(err)
+		t.FailNow()
+	}
+	defer node.Close(context.Background())
+	if _, err := NewServer(node, WithMetrics(nil)); err == nil {
+		t.FailNow()
+	}
+	for _, metrics := range []bool{false, true} {
+		var opts []Option
+		if metrics {
+			opts = append(opts, WithMetrics(registry))
+		}
+		handler, err := NewServer(node, opts...)
+		if err != nil {
+			t.Log(err)
+			t.FailNow()
+		}
+		server := httptest.NewServer(handler)
+		res, err := http.Get(server.URL + pathMetrics)
+		if err != nil {
+			t.Log(err)
+			t.FailNow()
+		}
+		body, _ := ioutil.ReadAll(res.Body)
+		res.Body.Close()
+		server.Close()
+		if metrics != (res.StatusCode == http.StatusOK) || metrics != strings.Contains(string(body), "nahs_contacts") {
+			t.Log(res.Status)
+			t.FailNow()
+		}
+	}
+}
@@ -0,0 +1,159 @@
+package control
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/mikelsr/nahs/events"
+	"github.com/mikelsr/nahs/net"
+)
+
+// keepAliveInterval is how often a comment is sent through idle
+// event streams so proxies and clients don't close them
+const keepAliveInterval = 15 * time.Second
+
+// stream sends the events run by the node as server-sent events,
+// filtered by the type, protocol_key, instance_key and rejected query
+// parameters. Each event is sent with its ID and type as an SSE and
+// a StreamEvent as its data.
+func (s *Server) stream(w http.ResponseWriter, r *http.Request) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		writeError(w, http.StatusInternalServerError, errors.New("Streaming is not supported"))
+		return
+	}
+	filter, err := eventFilter(r.URL.Query())
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	stream, cancel := s.node.Subscribe(filter)
+	defer cancel()
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.WriteHeader(http.StatusOK)
+	flusher.Flush()
+	ticker := time.NewTicker(keepAliveInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case <-s.done:
+			return
+		case <-ticker.C:
+			io.WriteString(w, ": keep-alive\n\n")
+		case event, ok := <-stream:
+			if !ok {
+				return
+			}
+			e, err := makeStreamEvent(event)
+			if err != nil {
+				continue
+			}
+			b, err := json.Marshal(e)
+			if err != nil {
+				continue
+			}
+			fmt.Fprintf(w, "id: %s\nevent: %s\ndata: %s\n\n", e.ID, e.Type, b)
+		}
+		flusher.Flush()
+	}
+}
+
+// eventFilter builds an event filter from the query parameters of
+// a request
+func eventFilter(values url.Values) (net.EventFilter, error) {
+	filter := net.EventFilter{
+		ProtocolKey: values.Get("protocol_key"),
+		InstanceKey: values.Get("instance_key"),
+	}
+	for _, t := range values["type"] {
+		filter.Types = append(filter.Types, events.EventType(t))
+	}
+	if v := values.Get("rejected"); v != "" {
+		rejected, err := strconv.ParseBool(v)
+		if err != nil {
+			return filter, fmt.Errorf("Invalid rejected '%s'", v)
+		}
+		filter.Rejected = rejected
+	}
+	return filter, nil
+}
+
+// makeStreamEvent converts an event delivered to a subscription
+func makeStreamEvent(event events.Event) (StreamEvent, error) {
+	b, err := event.Marshal()
+	if err != nil {
+		return StreamEvent{}, err
+	}
+	e := StreamEvent{ID: event.ID(), Type: event.Type(), InstanceKey: event.InstanceKey(), Event: b}
+	if rejected, ok := event.(net.RejectedEvent); ok {
+		e.Rejected, e.Sender, e.Error = true, rejected.Sender, rejected.Err.Error()
+	}
+	return e, nil
+}
+
+// Events streams the events run by the node that match filter, its
+// Buffer is ignored. The channel is closed when ctx is done or the
+// stream ends.
+func (c *Client) Events(ctx context.Context, filter net.EventFilter) (<-chan StreamEvent, error) {
+	values := make(url.Values)
+	for _, t := range filter.Types {
+		values.Add("type", string(t))
+	}
+	if filter.ProtocolKey != "" {
+		values.Set("protocol_key", filter.ProtocolKey)
+	}
+	if filter.InstanceKey != "" {
+		values.Set("instance_key", filter.InstanceKey)
+	}
+	if filter.Rejected {
+		values.Set("rejected", "true")
+	}
+	res, err := c.send(ctx, http.MethodGet, pathStream+"?"+values.Encode(), nil)
+	if err != nil {
+		return nil, err
+	}
+	stream := make(chan StreamEvent)
+	go func() {
+		defer close(stream)
+		defer res.Body.Close()
+		r := bufio.NewReader(res.Body)
+		var data strings.Builder
+		for {
+			line, err := r.ReadString('\n')
+			if err != nil {
+				return
+			}
+			line = strings.TrimRight(line, "\r\n")
+			switch {
+			case strings.HasPrefix(line, "data:"):
+				data.WriteString(strings.TrimPrefix(strings.TrimPrefix(line, "data:"), " "))
+			case line == "" && data.Len() > 0:
+				var e StreamEvent
+				err := json.Unmarshal([]byte(data.String()), &e)
+				data.Reset()
+				if err != nil {
+					continue
+				}
+				select {
+				case stream <- e:
+				case <-ctx.Done():
+					return
+				}
+			}
+		}
+	}()
+	return stream, nil
+}
@@ -0,0 +1,82 @@
+package control
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+const (
+	// tokenLength is the number of random bytes of a token
+	tokenLength = 32
+	// tokenPerm and tokenDirPerm are the permissions of the token
+	// files and the directories created for them
+	tokenPerm    = 0600
+	tokenDirPerm = 0700
+
+	bearerPrefix = "Bearer "
+)
+
+// GenerateToken creates a random token for the control API
+func GenerateToken() (string, error) {
+	b := make([]byte, tokenLength)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
+
+// LoadToken reads the token stored in a file, ignoring surrounding
+// whitespace
+func LoadToken(path string) (string, error) {
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return "", err
+	}
+	token := strings.TrimSpace(string(b))
+	if token == "" {
+		return "", fmt.Errorf("Invalid token file '%s': %s", path, ErrEmptyToken)
+	}
+	return token, nil
+}
+
+// LoadOrCreateToken reads the token stored in a file, generating it
+// and storing it in a file only readable by its owner if the file
+// does not exist. created reports whether the token was generated.
+func LoadOrCreateToken(path string) (token string, created bool, err error) {
+	token, err = LoadToken(path)
+	if err == nil || !errors.Is(err, os.ErrNotExist) {
+		return token, false, err
+	}
+	if token, err = GenerateToken(); err != nil {
+		return "", false, err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), tokenDirPerm); err != nil {
+		return "", false, err
+	}
+	if err := ioutil.WriteFile(path, []byte(token+"\n"), tokenPerm); err != nil {
+		return "", false, err
+	}
+	return token, true, nil
+}
+
+// authorize only lets requests with the token of the server through
+func (s *Server) authorize(h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		header := r.Header.Get("Authorization")
+		if !strings.HasPrefix(header, bearerPrefix) ||
+			subtle.ConstantTimeCompare([]byte(header[len(bearerPrefix):]), []byte(s.token)) != 1 {
+			w.Header().Set("WWW-Authenticate", `Bearer realm="nahs"`)
+			writeError(w, http.StatusUnauthorized, errors.New("Missing or invalid token"))
+			return
+		}
+		h.ServeHTTP(w, r)
+	})
+}
@@ -0,0 +1,111 @@
+// Package control exposes a running NaHS node to local tools through
+// an HTTP/JSON API, and implements a client for it.
+package control
+
+import (
+	"encoding/json"
+	"time"
+
+	"github.com/libp2p/go-libp2p-core/peer"
+	"github.com/mikelsr/nahs/events"
+)
+
+const (
+	// DefaultAddr is the address the control API listens on
+	// by default, only reachable from the local host
+	DefaultAddr = "127.0.0.1:4101"
+
+	pathContacts  = "/v1/contacts"
+	pathInstances = "/v1/instances"
+	pathPing      = "/v1/ping"
+	pathProtocols = "/v1/protocols"
+	pathEvents    = "/v1/events"
+	pathStream    = "/v1/events/stream"
+	pathMetrics   = "/metrics"
+)
+
+// Service is a protocol offered by a node and the roles it plays
+type Service struct {
+	ProtocolKey string   `json:"protocol_key"`
+	Protocol    string   `json:"protocol"`
+	Roles       []string `json:"roles"`
+}
+
+// Contact is a peer known by the node and the services it offers
+type Contact struct {
+	ID       peer.ID   `json:"id"`
+	LastSeen time.Time `json:"last_seen"`
+	Services []Service `json:"services"`
+}
+
+// Instance is an instance open in the node, the peer that created it
+// and the peers bound to its roles. Values are only set when listing
+// the instances of a protocol kept by the reasoner.
+type Instance struct {
+	Key     string             `json:"key"`
+	Creator peer.ID            `json:"creator,omitempty"`
+	Roles   map[string]peer.ID `json:"roles,omitempty"`
+	Values  map[string]string  `json:"values,omitempty"`
+}
+
+// PingRequest asks the node to ping a peer
+type PingRequest struct {
+	Peer peer.ID `json:"peer"`
+}
+
+// PingResponse has the round trip time of a ping
+type PingResponse struct {
+	RTT time.Duration `json:"rtt"`
+}
+
+// ProtocolRequest adds a BSPL protocol to the node
+type ProtocolRequest struct {
+	// Protocol is the BSPL source of the protocol
+	Protocol string `json:"protocol"`
+	// Roles the node plays in the protocol
+	Roles []string `json:"roles"`
+}
+
+// EventRequest asks the node to create an event and send it. New
+// events require ProtocolKey, the roles and the values of the instance,
+// update events InstanceKey and the new values, and drop events
+// InstanceKey and Motive.
+type EventRequest struct {
+	Type events.EventType `json:"type"`
+	// Peer the event is sent to, every participant of the instance
+	// if empty
+	Peer        peer.ID           `json:"peer,omitempty"`
+	ProtocolKey string            `json:"protocol_key,omitempty"`
+	InstanceKey string            `json:"instance_key,omitempty"`
+	Roles       map[string]string `json:"roles,omitempty"`
+	Values      map[string]string `json:"values,omitempty"`
+	Motive      string            `json:"motive,omitempty"`
+}
+
+// EventResponse identifies the sent event and has the peers it was
+// sent to, mapped to the error they returned or an empty string
+type EventResponse struct {
+	ID          string             `json:"id"`
+	InstanceKey string             `json:"instance_key"`
+	Peers       map[peer.ID]string `json:"peers"`
+}
+
+// StreamEvent is an event run or rejected by the node, as sent by the
+// event stream
+type StreamEvent struct {
+	ID          string           `json:"id"`
+	Type        events.EventType `json:"type"`
+	InstanceKey string           `json:"instance_key"`
+	// Rejected events were not run, Sender is the peer that sent
+	// them and Error why they were rejected
+	Rejected bool    `json:"rejected,omitempty"`
+	Sender   peer.ID `json:"sender,omitempty"`
+	Error    string  `json:"error,omitempty"`
+	// Event is the marshalled event
+	Event json.RawMessage `json:"event"`
+}
+
+// errorResponse is the body of the failed requests
+type errorResponse struct {
+	Error string `json:"error"`
+}
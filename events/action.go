@@ -0,0 +1,204 @@
+package events
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+
+	"github.com/google/uuid"
+	"github.com/mikelsr/bspl"
+	imp "github.com/mikelsr/bspl/implementation"
+)
+
+// ErrInvalidAction is returned when an ActionEvent doesn't
+// match the definition of its action or the state of the
+// instance it is run on
+type ErrInvalidAction struct {
+	Action string
+	Reason string
+}
+
+func (e ErrInvalidAction) Error() string {
+	return fmt.Sprintf("Invalid action '%s': %s", e.Action, e.Reason)
+}
+
+// ActionEvent happens when an action is run on an Instance.
+// Unlike UpdateEvent it only carries the values of the out
+// parameters of the action.
+type ActionEvent struct {
+	id          string
+	protocolKey string
+	instanceKey string
+	action      string
+	values      bspl.Values
+}
+
+// actionArgument is the argument of a marshalled ActionEvent
+type actionArgument struct {
+	ProtocolKey string      `json:"protocol_key"`
+	Action      string      `json:"action"`
+	Values      bspl.Values `json:"values"`
+}
+
+// MakeActionEvent is the default constructor for ActionEvent.
+// Values map the names of the out parameters of the action to
+// their new values.
+func MakeActionEvent(instance bspl.Instance, action string, values bspl.Values) ActionEvent {
+	return ActionEvent{
+		id:          uuid.New().String(),
+		protocolKey: instance.Protocol().Key(),
+		instanceKey: instance.Key(),
+		action:      action,
+		values:      values,
+	}
+}
+
+// Argument of ActionEvent: the values of the out parameters.
+func (ae ActionEvent) Argument() interface{} {
+	return ae.values
+}
+
+// Type returns the event type
+func (ae ActionEvent) Type() EventType {
+	return TypeActionEvent
+}
+
+// ID of the event
+func (ae ActionEvent) ID() string {
+	return ae.id
+}
+
+// Action returns the name of the action that was run
+func (ae ActionEvent) Action() string {
+	return ae.action
+}
+
+// InstanceKey returns the key of the instance of the Event
+func (ae ActionEvent) InstanceKey() string {
+	return ae.instanceKey
+}
+
+// ProtocolKey returns the key of the protocol of the instance
+func (ae ActionEvent) ProtocolKey() string {
+	return ae.protocolKey
+}
+
+// Values returns the values of the out parameters of the action
+func (ae ActionEvent) Values() bspl.Values {
+	return ae.values
+}
+
+// Validate checks the event against the definition of its action
+// in the protocol of instance: the action must exist, its in
+// parameters must be bound in instance and its out parameters
+// must be provided and not yet bound. The action is returned so
+// the caller can check its From role.
+func (ae ActionEvent) Validate(instance bspl.Instance) (bspl.Action, error) {
+	p := instance.Protocol()
+	if instance.Key() != ae.instanceKey || p.Key() != ae.protocolKey {
+		return bspl.Action{}, ErrInvalidAction{Action: ae.action,
+			Reason: "Instance does not match event"}
+	}
+	var action bspl.Action
+	found := false
+	for _, a := range p.Actions {
+		if a.Name == ae.action {
+			action, found = a, true
+			break
+		}
+	}
+	if !found {
+		return bspl.Action{}, ErrInvalidAction{Action: ae.action,
+			Reason: "Action not found in protocol"}
+	}
+	outs := 0
+	for _, param := range action.Params {
+		bound := instance.GetValue(param.Name) != ""
+		switch param.Io {
+		case bspl.In:
+			if !bound {
+				return action, ErrInvalidAction{Action: ae.action,
+					Reason: fmt.Sprintf("In parameter '%s' is not bound", param.Name)}
+			}
+		case bspl.Out:
+			if bound {
+				return action, ErrInvalidAction{Action: ae.action,
+					Reason: fmt.Sprintf("Out parameter '%s' is already bound", param.Name)}
+			}
+			if ae.values[param.Name] == "" {
+				return action, ErrInvalidAction{Action: ae.action,
+					Reason: fmt.Sprintf("Out parameter '%s' has no value", param.Name)}
+			}
+			outs++
+		}
+	}
+	if len(ae.values) != outs {
+		return action, ErrInvalidAction{Action: ae.action,
+			Reason: "Values for parameters other than the out parameters of the action"}
+	}
+	return action, nil
+}
+
+// Apply validates the event and returns the version of instance
+// after running the action. instance is not modified.
+func (ae ActionEvent) Apply(instance bspl.Instance) (bspl.Instance, error) {
+	if _, err := ae.Validate(instance); err != nil {
+		return nil, err
+	}
+	b, err := instance.Marshal()
+	if err != nil {
+		return nil, err
+	}
+	next := new(imp.Instance)
+	if err := next.Unmarshal(b); err != nil {
+		return nil, err
+	}
+	for name, value := range ae.values {
+		next.SetValue(name, value)
+	}
+	return next, nil
+}
+
+// Marshal an ActionEvent to bytes
+func (ae ActionEvent) Marshal() ([]byte, error) {
+	b, err := json.Marshal(actionArgument{
+		ProtocolKey: ae.protocolKey,
+		Action:      ae.action,
+		Values:      ae.values,
+	})
+	if err != nil {
+		return nil, err
+	}
+	wrapper := EventWrapper{
+		Argument:    base64.StdEncoding.EncodeToString(b),
+		ID:          ae.ID(),
+		InstanceKey: ae.instanceKey,
+		Type:        TypeActionEvent,
+	}
+	return wrapper.Marshal()
+}
+
+// Unmarshal an ActionEvent from bytes
+func (ae ActionEvent) Unmarshal(data []byte) (Event, error) {
+	NIL := ActionEvent{}
+	wrapper := new(EventWrapper)
+	if err := json.Unmarshal(data, wrapper); err != nil {
+		return NIL, err
+	}
+	b, err := base64.StdEncoding.DecodeString(wrapper.Argument)
+	if err != nil {
+		return NIL, err
+	}
+	argument := new(actionArgument)
+	if err := json.Unmarshal(b, argument); err != nil {
+		return NIL, err
+	}
+	n := ActionEvent{
+		id:          wrapper.ID,
+		protocolKey: argument.ProtocolKey,
+		instanceKey: wrapper.InstanceKey,
+		action:      argument.Action,
+		values:      argument.Values,
+	}
+	return n, nil
+}
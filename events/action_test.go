@@ -0,0 +1,119 @@
+package events
+
+import (
+	"testing"
+
+	"github.com/mikelsr/bspl"
+	imp "github.com/mikelsr/bspl/implementation"
+	"github.com/mikelsr/bspl/proto"
+)
+
+// testRequestedInstance returns testInstance after running
+// "Request" but before "Offer"
+func testRequestedInstance() *imp.Instance {
+	roles := imp.Roles{
+		proto.Role("Buyer"):  "B",
+		proto.Role("Seller"): "S",
+	}
+	i := imp.NewInstance(testProtocol(), roles)
+	i.SetValue("ID", "X")
+	i.SetValue("item", "X")
+	return i
+}
+
+func TestActionEvent(t *testing.T) {
+	testActionEventMarshal(t)
+	testActionEventValidate(t)
+	testActionEventApply(t)
+}
+
+func testActionEventMarshal(t *testing.T) {
+	expected := MakeActionEvent(testRequestedInstance(), "Offer", bspl.Values{"price": "X"})
+	b, err := expected.Marshal()
+	if err != nil {
+		t.Log(err)
+		t.FailNow()
+	}
+	if eventType, _ := Type(b); eventType != TypeActionEvent {
+		t.FailNow()
+	}
+	event, err := Unmarshal(b)
+	if err != nil {
+		t.Log(err)
+		t.FailNow()
+	}
+	ae := event.(ActionEvent)
+	if ae.ID() != expected.ID() ||
+		ae.Action() != expected.Action() ||
+		ae.InstanceKey() != expected.InstanceKey() ||
+		ae.ProtocolKey() != expected.ProtocolKey() ||
+		!ae.Values().Equals(expected.Values()) {
+		t.FailNow()
+	}
+}
+
+func testActionEventValidate(t *testing.T) {
+	requested := testRequestedInstance()
+	testCases := []struct {
+		name     string
+		instance bspl.Instance
+		action   string
+		values   bspl.Values
+		valid    bool
+	}{
+		{"Valid", requested, "Offer", bspl.Values{"price": "X"}, true},
+		{"UnknownAction", requested, "Accept", bspl.Values{"price": "X"}, false},
+		{"OutBound", testInstance(), "Offer", bspl.Values{"price": "X"}, false},
+		{"OutMissing", requested, "Offer", bspl.Values{}, false},
+		{"ExtraValues", requested, "Offer", bspl.Values{"price": "X", "item": "Y"}, false},
+		{"InNotBound", imp.NewInstance(testProtocol(), requested.Roles()), "Offer",
+			bspl.Values{"price": "X"}, false},
+	}
+	for _, tc := range testCases {
+		event := MakeActionEvent(tc.instance, tc.action, tc.values)
+		action, err := event.Validate(tc.instance)
+		if (err == nil) != tc.valid {
+			t.Logf("%s: %v", tc.name, err)
+			t.FailNow()
+		}
+		if tc.valid && action.From != proto.Role("Buyer") {
+			t.FailNow()
+		}
+		if _, ok := err.(ErrInvalidAction); !tc.valid && !ok {
+			t.Logf("%s: unexpected error %v", tc.name, err)
+			t.FailNow()
+		}
+	}
+
+	// events are only valid for the instance they were created for
+	other := imp.NewInstance(testProtocol(), requested.Roles())
+	other.SetValue("ID", "Y")
+	other.SetValue("item", "Y")
+	event := MakeActionEvent(requested, "Offer", bspl.Values{"price": "X"})
+	if _, err := event.Validate(other); err == nil {
+		t.FailNow()
+	}
+}
+
+func testActionEventApply(t *testing.T) {
+	requested := testRequestedInstance()
+	event := MakeActionEvent(requested, "Offer", bspl.Values{"price": "X"})
+	next, err := event.Apply(requested)
+	if err != nil {
+		t.Log(err)
+		t.FailNow()
+	}
+	if next.GetValue("price") != "X" || requested.GetValue("price") != "" {
+		t.FailNow()
+	}
+	if !next.Equals(testInstance()) {
+		t.FailNow()
+	}
+
+	// the mock reasoner holds the offered instance, so offering
+	// again is rejected
+	b, _ := event.Marshal()
+	if err := RunEvent(mockReasoner{}, b); err == nil {
+		t.FailNow()
+	}
+}
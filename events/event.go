@@ -3,6 +3,7 @@ package events
 import (
 	"encoding/json"
 	"errors"
+	"fmt"
 
 	"github.com/mikelsr/bspl"
 )
@@ -18,6 +19,9 @@ const (
 	TypeNewEvent EventType = "new"
 	// TypeUpdateEvent an action was run on an instance
 	TypeUpdateEvent EventType = "update"
+	// TypeActionEvent an action was run on an instance and
+	// only its out parameters are sent
+	TypeActionEvent EventType = "action"
 )
 
 // Event is used by one Node to notify another of a BSPL
@@ -25,7 +29,8 @@ const (
 type Event interface {
 	// Argument of the event. If it is New or Update it
 	// will be an Instance, if it is Drop it will be the
-	// motive.
+	// motive and if it is Action the values of the out
+	// parameters.
 	Argument() interface{}
 	// ID of the Event
 	ID() string
@@ -40,12 +45,16 @@ type Event interface {
 }
 
 // EventWrapper is used by different event types
-// to marshal themselves
+// to marshal themselves. Sequence is set by
+// SetSequence, Signer and Signature by Sign.
 type EventWrapper struct {
 	Argument    string    `json:"argument"`
 	ID          string    `json:"id"`
 	InstanceKey string    `json:"instance_key"`
 	Type        EventType `json:"event_type"`
+	Sequence    uint64    `json:"sequence,omitempty"`
+	Signer      string    `json:"signer,omitempty"`
+	Signature   []byte    `json:"signature,omitempty"`
 }
 
 // Marshal an EventWrapper
@@ -65,7 +74,7 @@ func ID(marshalledEvent []byte) (string, error) {
 		return "", err
 	}
 	switch ge.Type {
-	case TypeDropEvent, TypeNewEvent, TypeUpdateEvent:
+	case TypeDropEvent, TypeNewEvent, TypeUpdateEvent, TypeActionEvent:
 		break
 	default:
 		return "", errors.New("Unable to identify event type")
@@ -80,7 +89,7 @@ func Type(marshalledEvent []byte) (EventType, error) {
 		return "", err
 	}
 	switch ge.Type {
-	case TypeDropEvent, TypeNewEvent, TypeUpdateEvent:
+	case TypeDropEvent, TypeNewEvent, TypeUpdateEvent, TypeActionEvent:
 		break
 	default:
 		return "", errors.New("Unable to identify event type")
@@ -88,35 +97,54 @@ func Type(marshalledEvent []byte) (EventType, error) {
 	return ge.Type, nil
 }
 
-// GetInstanceKey extracts the instance key from a marshalled
-// event
-func GetInstanceKey(marshalledEvent []byte) (string, error) {
+// Sequence returns the position of a marshalled event among the
+// events sent by its sender on the same instance. 0 means the event
+// is not sequenced.
+func Sequence(marshalledEvent []byte) (uint64, error) {
+	wrapper := new(EventWrapper)
+	if err := json.Unmarshal(marshalledEvent, wrapper); err != nil {
+		return 0, err
+	}
+	return wrapper.Sequence, nil
+}
+
+// SetSequence sets the sequence number of a marshalled event. It must
+// be set before signing the event as the signature covers it.
+func SetSequence(marshalledEvent []byte, sequence uint64) ([]byte, error) {
+	wrapper := new(EventWrapper)
+	if err := json.Unmarshal(marshalledEvent, wrapper); err != nil {
+		return nil, err
+	}
+	wrapper.Sequence = sequence
+	return wrapper.Marshal()
+}
+
+// Unmarshal identifies the type of a marshalled event and
+// unmarshals it
+func Unmarshal(marshalledEvent []byte) (Event, error) {
 	t, err := Type(marshalledEvent)
 	if err != nil {
-		return "", err
+		return nil, err
 	}
-	var event Event
 	switch t {
 	case TypeDropEvent:
-		var a DropEvent
-		event, err = a.Unmarshal(marshalledEvent)
-		if err != nil {
-			return "", err
-		}
+		return DropEvent{}.Unmarshal(marshalledEvent)
 	case TypeNewEvent:
-		var ni NewEvent
-		event, err = ni.Unmarshal(marshalledEvent)
-		if err != nil {
-			return "", err
-		}
+		return NewEvent{}.Unmarshal(marshalledEvent)
 	case TypeUpdateEvent:
-		var nm UpdateEvent
-		event, err = nm.Unmarshal(marshalledEvent)
-		if err != nil {
-			return "", err
-		}
-	default:
-		return "", errors.New("Key not found")
+		return UpdateEvent{}.Unmarshal(marshalledEvent)
+	case TypeActionEvent:
+		return ActionEvent{}.Unmarshal(marshalledEvent)
+	}
+	return nil, errors.New("Unable to identify event type")
+}
+
+// GetInstanceKey extracts the instance key from a marshalled
+// event
+func GetInstanceKey(marshalledEvent []byte) (string, error) {
+	event, err := Unmarshal(marshalledEvent)
+	if err != nil {
+		return "", err
 	}
 	return event.InstanceKey(), nil
 }
@@ -153,6 +181,22 @@ func RunEvent(r bspl.Reasoner, marshalledEvent []byte) error {
 		}
 		nm = event.(UpdateEvent)
 		return r.UpdateInstance(nm.Instance())
+	case TypeActionEvent:
+		var ae ActionEvent
+		event, err := ae.Unmarshal(marshalledEvent)
+		if err != nil {
+			return err
+		}
+		ae = event.(ActionEvent)
+		current, found := r.GetInstance(ae.InstanceKey())
+		if !found {
+			return fmt.Errorf("Instance '%s' not found", ae.InstanceKey())
+		}
+		next, err := ae.Apply(current)
+		if err != nil {
+			return err
+		}
+		return r.UpdateInstance(next)
 	}
 	return nil
 }
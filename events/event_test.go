@@ -67,3 +67,25 @@ func TestGetInstanceKey(t *testing.T) {
 		t.FailNow()
 	}
 }
+
+func TestSequence(t *testing.T) {
+	event, _ := MakeNewEvent(testInstance()).Marshal()
+	if sequence, err := Sequence(event); err != nil || sequence != 0 {
+		t.FailNow()
+	}
+	sequenced, err := SetSequence(event, 3)
+	if err != nil {
+		t.Log(err)
+		t.FailNow()
+	}
+	if sequence, err := Sequence(sequenced); err != nil || sequence != 3 {
+		t.FailNow()
+	}
+	// sequenced events can still be run
+	if err := RunEvent(mockReasoner{}, sequenced); err != nil {
+		t.FailNow()
+	}
+	if _, err := Sequence([]byte{}); err == nil {
+		t.FailNow()
+	}
+}
@@ -0,0 +1,89 @@
+package events
+
+import (
+	"encoding/json"
+	"errors"
+
+	"github.com/libp2p/go-libp2p-core/crypto"
+	"github.com/libp2p/go-libp2p-core/peer"
+)
+
+var (
+	// ErrUnsigned is returned when verifying an event
+	// that has no signature
+	ErrUnsigned = errors.New("Event is not signed")
+	// ErrInvalidSignature is returned when the signature of
+	// an event doesn't match its content or signer
+	ErrInvalidSignature = errors.New("Invalid event signature")
+)
+
+// Sign signs a marshalled event with the private key of the node
+// sending it. The returned event carries the ID of the signer and
+// the signature. Any previous signature is replaced.
+func Sign(marshalledEvent []byte, sk crypto.PrivKey) ([]byte, error) {
+	signer, err := peer.IDFromPrivateKey(sk)
+	if err != nil {
+		return nil, err
+	}
+	wrapper := new(EventWrapper)
+	if err := json.Unmarshal(marshalledEvent, wrapper); err != nil {
+		return nil, err
+	}
+	wrapper.Signer = peer.IDB58Encode(signer)
+	wrapper.Signature = nil
+	data, err := wrapper.Marshal()
+	if err != nil {
+		return nil, err
+	}
+	wrapper.Signature, err = sk.Sign(data)
+	if err != nil {
+		return nil, err
+	}
+	return wrapper.Marshal()
+}
+
+// Signer returns the ID of the node that signed a marshalled
+// event. ErrUnsigned is returned if the event is not signed.
+func Signer(marshalledEvent []byte) (peer.ID, error) {
+	wrapper := new(EventWrapper)
+	if err := json.Unmarshal(marshalledEvent, wrapper); err != nil {
+		return "", err
+	}
+	if wrapper.Signer == "" || len(wrapper.Signature) == 0 {
+		return "", ErrUnsigned
+	}
+	return peer.IDB58Decode(wrapper.Signer)
+}
+
+// Verify checks the signature of a marshalled event against the
+// public key of its signer. The key must belong to the signer.
+func Verify(marshalledEvent []byte, pk crypto.PubKey) error {
+	wrapper := new(EventWrapper)
+	if err := json.Unmarshal(marshalledEvent, wrapper); err != nil {
+		return err
+	}
+	if wrapper.Signer == "" || len(wrapper.Signature) == 0 {
+		return ErrUnsigned
+	}
+	signer, err := peer.IDB58Decode(wrapper.Signer)
+	if err != nil {
+		return err
+	}
+	if !signer.MatchesPublicKey(pk) {
+		return ErrInvalidSignature
+	}
+	signature := wrapper.Signature
+	wrapper.Signature = nil
+	data, err := wrapper.Marshal()
+	if err != nil {
+		return err
+	}
+	ok, err := pk.Verify(data, signature)
+	if err != nil {
+		return err
+	}
+	if !ok {
+		return ErrInvalidSignature
+	}
+	return nil
+}
@@ -0,0 +1,73 @@
+package events
+
+import (
+	"crypto/rand"
+	"encoding/json"
+	"errors"
+	"testing"
+
+	"github.com/libp2p/go-libp2p-core/crypto"
+	"github.com/libp2p/go-libp2p-core/peer"
+)
+
+func TestSign(t *testing.T) {
+	sk, pk, _ := crypto.GenerateEd25519Key(rand.Reader)
+	_, otherPK, _ := crypto.GenerateEd25519Key(rand.Reader)
+	id, _ := peer.IDFromPublicKey(pk)
+
+	event, _ := MakeNewEvent(testInstance()).Marshal()
+	if err := Verify(event, pk); !errors.Is(err, ErrUnsigned) {
+		t.FailNow()
+	}
+	if _, err := Signer(event); !errors.Is(err, ErrUnsigned) {
+		t.FailNow()
+	}
+
+	signed, err := Sign(event, sk)
+	if err != nil {
+		t.Log(err)
+		t.FailNow()
+	}
+	if err := Verify(signed, pk); err != nil {
+		t.Log(err)
+		t.FailNow()
+	}
+	if signer, err := Signer(signed); err != nil || signer != id {
+		t.FailNow()
+	}
+	// signed events can still be run and identified
+	if eventID, err := ID(signed); err != nil || eventID == "" {
+		t.FailNow()
+	}
+	if err := RunEvent(mockReasoner{}, signed); err != nil {
+		t.FailNow()
+	}
+
+	// a key that doesn't belong to the signer
+	if err := Verify(signed, otherPK); !errors.Is(err, ErrInvalidSignature) {
+		t.FailNow()
+	}
+	// tampered event
+	var wrapper EventWrapper
+	json.Unmarshal(signed, &wrapper)
+	wrapper.InstanceKey = "tampered"
+	tampered, _ := wrapper.Marshal()
+	if err := Verify(tampered, pk); !errors.Is(err, ErrInvalidSignature) {
+		t.FailNow()
+	}
+}
+
+func TestSignSequence(t *testing.T) {
+	sk, pk, _ := crypto.GenerateEd25519Key(rand.Reader)
+	event, _ := MakeNewEvent(testInstance()).Marshal()
+	sequenced, _ := SetSequence(event, 1)
+	signed, _ := Sign(sequenced, sk)
+	if err := Verify(signed, pk); err != nil {
+		t.FailNow()
+	}
+	// the signature covers the sequence number
+	resequenced, _ := SetSequence(signed, 2)
+	if err := Verify(resequenced, pk); !errors.Is(err, ErrInvalidSignature) {
+		t.FailNow()
+	}
+}
@@ -0,0 +1,248 @@
+// Package keystore generates the private keys that identify NaHS nodes
+// and stores them in files only readable by their owner, optionally
+// encrypted with a passphrase.
+package keystore
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"runtime"
+
+	"github.com/libp2p/go-libp2p-core/crypto"
+	"golang.org/x/crypto/scrypt"
+)
+
+const (
+	// RSABits is the size of the generated RSA keys
+	RSABits = 2048
+
+	// keyPerm and dirPerm are the permissions of the key files
+	// and the directories created for them
+	keyPerm = 0600
+	dirPerm = 0700
+
+	// encryptedMagic prefixes the encrypted keys
+	encryptedMagic = "NAHSKEY1"
+	saltLength     = 16
+	// scrypt parameters to derive the encryption key
+	scryptN      = 1 << 15
+	scryptR      = 8
+	scryptP      = 1
+	scryptLength = 32
+)
+
+var (
+	// ErrKeyType is returned when generating a key of an
+	// unsupported type
+	ErrKeyType = errors.New("Unsupported key type")
+	// ErrPermissions is returned when loading a key file other
+	// users can access
+	ErrPermissions = errors.New("Key file is accessible by other users")
+	// ErrEncrypted is returned when loading an encrypted key
+	// without a passphrase
+	ErrEncrypted = errors.New("Key is encrypted, a passphrase is required")
+	// ErrPassphrase is returned when an encrypted key can't be
+	// decrypted with the passphrase
+	ErrPassphrase = errors.New("Wrong passphrase or corrupted key")
+)
+
+// Generate creates a private key of type crypto.Ed25519, crypto.RSA
+// (of RSABits) or crypto.Secp256k1
+func Generate(keyType int) (crypto.PrivKey, error) {
+	switch keyType {
+	case crypto.Ed25519, crypto.Secp256k1:
+		sk, _, err := crypto.GenerateKeyPair(keyType, 0)
+		return sk, err
+	case crypto.RSA:
+		sk, _, err := crypto.GenerateKeyPair(keyType, RSABits)
+		return sk, err
+	}
+	return nil, ErrKeyType
+}
+
+// Encode marshals a private key in base64, the format of the keys
+// exported by Node.ExportKey. If passphrase is not empty the key is
+// encrypted with AES-GCM and a key derived from the passphrase.
+func Encode(sk crypto.PrivKey, passphrase []byte) ([]byte, error) {
+	b, err := crypto.MarshalPrivateKey(sk)
+	if err != nil {
+		return nil, err
+	}
+	if len(passphrase) > 0 {
+		if b, err = encrypt(b, passphrase); err != nil {
+			return nil, err
+		}
+	}
+	encoded := make([]byte, base64.StdEncoding.EncodedLen(len(b)))
+	base64.StdEncoding.Encode(encoded, b)
+	return encoded, nil
+}
+
+// Decode unmarshals a private key encoded by Encode. The passphrase
+// is only used if the key is encrypted.
+func Decode(encoded []byte, passphrase []byte) (crypto.PrivKey, error) {
+	b, err := base64.StdEncoding.DecodeString(string(bytes.TrimSpace(encoded)))
+	if err != nil {
+		return nil, err
+	}
+	if bytes.HasPrefix(b, []byte(encryptedMagic)) {
+		if len(passphrase) == 0 {
+			return nil, ErrEncrypted
+		}
+		if b, err = decrypt(b, passphrase); err != nil {
+			return nil, err
+		}
+	}
+	return crypto.UnmarshalPrivateKey(b)
+}
+
+// Save writes a private key to a file only readable by its owner,
+// creating the directories in its path. See Encode. The key is
+// written to a temporary file in the same directory that replaces
+// the file in path once written, so it is never readable by others,
+// even if the file existed with other permissions.
+func Save(path string, sk crypto.PrivKey, passphrase []byte) error {
+	b, err := Encode(sk, passphrase)
+	if err != nil {
+		return err
+	}
+	dir := filepath.Dir(path)
+	if err := os.MkdirAll(dir, dirPerm); err != nil {
+		return err
+	}
+	f, err := ioutil.TempFile(dir, "."+filepath.Base(path)+".tmp")
+	if err != nil {
+		return err
+	}
+	tmp := f.Name()
+	err = writeKeyFile(f, b)
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil {
+		err = os.Rename(tmp, path)
+	}
+	if err != nil {
+		os.Remove(tmp)
+	}
+	return err
+}
+
+// writeKeyFile restricts the permissions of a new file and writes
+// an encoded key to it
+func writeKeyFile(f *os.File, b []byte) error {
+	// TempFile creates files with 0600 already, but the mode is
+	// set explicitly in case that changes
+	if err := f.Chmod(keyPerm); err != nil {
+		return err
+	}
+	if _, err := f.Write(b); err != nil {
+		return err
+	}
+	return f.Sync()
+}
+
+// Load reads a private key written by Save. Files other users can
+// access are refused with ErrPermissions.
+func Load(path string, passphrase []byte) (crypto.PrivKey, error) {
+	if err := checkPermissions(path); err != nil {
+		return nil, err
+	}
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	return Decode(b, passphrase)
+}
+
+// LoadOrCreate loads the private key in path or, if there is no file,
+// generates one of keyType and saves it. created reports if the key
+// was generated.
+func LoadOrCreate(path string, keyType int, passphrase []byte) (sk crypto.PrivKey, created bool, err error) {
+	sk, err = Load(path, passphrase)
+	if err == nil || !os.IsNotExist(err) {
+		return sk, false, err
+	}
+	if sk, err = Generate(keyType); err != nil {
+		return nil, false, err
+	}
+	if err = Save(path, sk, passphrase); err != nil {
+		return nil, false, err
+	}
+	return sk, true, nil
+}
+
+// checkPermissions verifies that only the owner of a file can access
+// it. Permissions are not checked on Windows.
+func checkPermissions(path string) error {
+	info, err := os.Stat(path)
+	if err != nil {
+		return err
+	}
+	if runtime.GOOS != "windows" && info.Mode().Perm()&0077 != 0 {
+		return fmt.Errorf("%w: '%s' has mode %s", ErrPermissions, path, info.Mode().Perm())
+	}
+	return nil
+}
+
+// encrypt seals a marshaled key as magic, salt, nonce and ciphertext
+func encrypt(b []byte, passphrase []byte) ([]byte, error) {
+	salt := make([]byte, saltLength)
+	if _, err := rand.Read(salt); err != nil {
+		return nil, err
+	}
+	aead, err := newAEAD(passphrase, salt)
+	if err != nil {
+		return nil, err
+	}
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+	out := append([]byte(encryptedMagic), salt...)
+	out = append(out, nonce...)
+	return aead.Seal(out, nonce, b, []byte(encryptedMagic)), nil
+}
+
+// decrypt opens a key sealed by encrypt
+func decrypt(b []byte, passphrase []byte) ([]byte, error) {
+	b = b[len(encryptedMagic):]
+	if len(b) < saltLength {
+		return nil, ErrPassphrase
+	}
+	salt, b := b[:saltLength], b[saltLength:]
+	aead, err := newAEAD(passphrase, salt)
+	if err != nil {
+		return nil, err
+	}
+	if len(b) < aead.NonceSize() {
+		return nil, ErrPassphrase
+	}
+	nonce, ciphertext := b[:aead.NonceSize()], b[aead.NonceSize():]
+	plain, err := aead.Open(nil, nonce, ciphertext, []byte(encryptedMagic))
+	if err != nil {
+		return nil, ErrPassphrase
+	}
+	return plain, nil
+}
+
+// newAEAD derives an AES-GCM cipher from a passphrase and a salt
+func newAEAD(passphrase, salt []byte) (cipher.AEAD, error) {
+	key, err := scrypt.Key(passphrase, salt, scryptN, scryptR, scryptP, scryptLength)
+	if err != nil {
+		return nil, err
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
@@ -0,0 +1,141 @@
+package keystore
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"runtime"
+	"testing"
+
+	"github.com/libp2p/go-libp2p-core/crypto"
+)
+
+// testDir creates a temporary directory for key files
+func testDir(t *testing.T) string {
+	dir, err := ioutil.TempDir("", "nahs-keystore")
+	if err != nil {
+		t.Log(err)
+		t.FailNow()
+	}
+	return dir
+}
+
+func TestGenerate(t *testing.T) {
+	for _, keyType := range []int{crypto.Ed25519, crypto.RSA, crypto.Secp256k1} {
+		sk, err := Generate(keyType)
+		if err != nil {
+			t.Log(err)
+			t.FailNow()
+		}
+		if int(sk.Type()) != keyType {
+			t.FailNow()
+		}
+	}
+	if _, err := Generate(crypto.ECDSA); err != ErrKeyType {
+		t.FailNow()
+	}
+}
+
+func TestEncode(t *testing.T) {
+	sk, _ := Generate(crypto.Ed25519)
+	passphrase := []byte("passphrase")
+
+	// plain keys are compatible with Node.ExportKey
+	encoded, err := Encode(sk, nil)
+	if err != nil {
+		t.Log(err)
+		t.FailNow()
+	}
+	if decoded, err := Decode(encoded, passphrase); err != nil || !decoded.Equals(sk) {
+		t.Log(err)
+		t.FailNow()
+	}
+
+	encrypted, err := Encode(sk, passphrase)
+	if err != nil {
+		t.Log(err)
+		t.FailNow()
+	}
+	if decoded, err := Decode(encrypted, passphrase); err != nil || !decoded.Equals(sk) {
+		t.Log(err)
+		t.FailNow()
+	}
+	if _, err := Decode(encrypted, nil); err != ErrEncrypted {
+		t.FailNow()
+	}
+	if _, err := Decode(encrypted, []byte("wrong")); err != ErrPassphrase {
+		t.FailNow()
+	}
+}
+
+func TestSaveLoad(t *testing.T) {
+	dir := testDir(t)
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "keys", "node.key")
+	sk, _ := Generate(crypto.Secp256k1)
+	passphrase := []byte("passphrase")
+
+	if err := Save(path, sk, passphrase); err != nil {
+		t.Log(err)
+		t.FailNow()
+	}
+	if info, err := os.Stat(path); err != nil || info.Mode().Perm() != keyPerm {
+		t.FailNow()
+	}
+	if loaded, err := Load(path, passphrase); err != nil || !loaded.Equals(sk) {
+		t.Log(err)
+		t.FailNow()
+	}
+
+	// files other users can access are refused
+	if runtime.GOOS == "windows" {
+		return
+	}
+	if err := os.Chmod(path, 0644); err != nil {
+		t.Log(err)
+		t.FailNow()
+	}
+	if _, err := Load(path, passphrase); !errors.Is(err, ErrPermissions) {
+		t.FailNow()
+	}
+	// saving again replaces them with a file only readable by
+	// its owner, leaving no temporary files behind
+	if err := Save(path, sk, nil); err != nil {
+		t.Log(err)
+		t.FailNow()
+	}
+	if info, err := os.Stat(path); err != nil || info.Mode().Perm() != keyPerm {
+		t.FailNow()
+	}
+	if loaded, err := Load(path, nil); err != nil || !loaded.Equals(sk) {
+		t.Log(err)
+		t.FailNow()
+	}
+	if files, err := ioutil.ReadDir(filepath.Dir(path)); err != nil || len(files) != 1 {
+		t.Log(err)
+		t.FailNow()
+	}
+}
+
+func TestLoadOrCreate(t *testing.T) {
+	dir := testDir(t)
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "node.key")
+
+	sk, created, err := LoadOrCreate(path, crypto.Ed25519, nil)
+	if err != nil || !created {
+		t.Log(err)
+		t.FailNow()
+	}
+	loaded, created, err := LoadOrCreate(path, crypto.Ed25519, nil)
+	if err != nil || created || !loaded.Equals(sk) {
+		t.Log(err)
+		t.FailNow()
+	}
+	// other errors are not hidden by creating a key
+	os.Chmod(path, 0640)
+	if _, _, err := LoadOrCreate(path, crypto.Ed25519, nil); !errors.Is(err, ErrPermissions) {
+		t.FailNow()
+	}
+}
@@ -1,25 +1,40 @@
 package nahs
 
 import (
-	"github.com/libp2p/go-libp2p"
 	crypto "github.com/libp2p/go-libp2p-crypto"
 	"github.com/mikelsr/bspl"
+	"github.com/mikelsr/nahs/keystore"
 	"github.com/mikelsr/nahs/net"
 )
 
 type (
 	// Node of the NaHS network.
 	Node = net.Node
+	// Option configures a Node when it is created.
+	Option = net.Option
 )
 
-// NewNode creates a new NaHS node. LibP2P options can be passed
-// to configure the node.
-func NewNode(reasoner bspl.Reasoner, options ...libp2p.Option) *Node {
+// NewNode creates a new NaHS node. Options can be passed
+// to configure the node, see net.WithLibp2pOptions to pass
+// LibP2P options.
+func NewNode(reasoner bspl.Reasoner, options ...Option) (*Node, error) {
 	return net.NewNode(reasoner, options...)
 }
 
 // MakeNode creates a node with the specified private key so the
 // node maintains the ID it previously had.
-func MakeNode(reasoner bspl.Reasoner, sk crypto.PrivKey, options ...libp2p.Option) *Node {
+func MakeNode(reasoner bspl.Reasoner, sk crypto.PrivKey, options ...Option) (*Node, error) {
 	return net.NodeFromPrivKey(reasoner, sk, options...)
 }
+
+// LoadOrCreateNode creates a node with the private key stored in path,
+// generating an Ed25519 key and saving it there if there is none, so
+// the node keeps its ID between runs. See the keystore package to use
+// other key types or encrypted keys.
+func LoadOrCreateNode(path string, reasoner bspl.Reasoner, options ...Option) (*Node, error) {
+	sk, _, err := keystore.LoadOrCreate(path, crypto.Ed25519, nil)
+	if err != nil {
+		return nil, err
+	}
+	return MakeNode(reasoner, sk, options...)
+}
@@ -0,0 +1,179 @@
+package net
+
+import (
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/libp2p/go-libp2p-core/peer"
+	bolt "go.etcd.io/bbolt"
+)
+
+var (
+	boltContactsBucket  = []byte("contacts")
+	boltInstancesBucket = []byte("instances")
+	boltProtocolsBucket = []byte("protocols")
+	boltOutboundBucket  = []byte("outbound")
+)
+
+// BoltStore is a Store and Outbox persisted to disk in a bolt
+// database
+type BoltStore struct {
+	db *bolt.DB
+}
+
+// OpenBoltStore opens the bolt database in path, creating it and
+// its parent directories if they don't exist
+func OpenBoltStore(path string) (*BoltStore, error) {
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return nil, err
+	}
+	db, err := bolt.Open(path, 0600, &bolt.Options{Timeout: time.Second})
+	if err != nil {
+		return nil, err
+	}
+	err = db.Update(func(tx *bolt.Tx) error {
+		for _, bucket := range [][]byte{boltContactsBucket, boltInstancesBucket, boltProtocolsBucket, boltOutboundBucket} {
+			if _, err := tx.CreateBucketIfNotExists(bucket); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		db.Close()
+		return nil, err
+	}
+	return &BoltStore{db: db}, nil
+}
+
+// Close the bolt database
+func (s *BoltStore) Close() error {
+	return s.db.Close()
+}
+
+// LoadContacts returns all the stored contacts
+func (s *BoltStore) LoadContacts() (Contacts, error) {
+	contacts := make(Contacts)
+	err := s.db.View(func(tx *bolt.Tx) error {
+		return tx.Bucket(boltContactsBucket).ForEach(func(k, v []byte) error {
+			services, err := unmarshalServices(v)
+			if err != nil {
+				return err
+			}
+			contacts[peer.ID(k)] = services
+			return nil
+		})
+	})
+	return contacts, err
+}
+
+// PutContact stores the services offered by a contact
+func (s *BoltStore) PutContact(id peer.ID, services Services) error {
+	data, err := marshalServices(services)
+	if err != nil {
+		return err
+	}
+	return s.put(boltContactsBucket, []byte(id), data)
+}
+
+// DeleteContact removes a contact
+func (s *BoltStore) DeleteContact(id peer.ID) error {
+	return s.delete(boltContactsBucket, []byte(id))
+}
+
+// LoadInstances returns all the stored open instances
+func (s *BoltStore) LoadInstances() (map[string]Ownership, error) {
+	instances := make(map[string]Ownership)
+	err := s.db.View(func(tx *bolt.Tx) error {
+		return tx.Bucket(boltInstancesBucket).ForEach(func(k, v []byte) error {
+			o, err := unmarshalOwnership(v)
+			if err != nil {
+				return err
+			}
+			instances[string(k)] = o
+			return nil
+		})
+	})
+	return instances, err
+}
+
+// PutInstance stores the Ownership of an instance
+func (s *BoltStore) PutInstance(instanceKey string, o Ownership) error {
+	data, err := marshalOwnership(o)
+	if err != nil {
+		return err
+	}
+	return s.put(boltInstancesBucket, []byte(instanceKey), data)
+}
+
+// DeleteInstance removes an instance
+func (s *BoltStore) DeleteInstance(instanceKey string) error {
+	return s.delete(boltInstancesBucket, []byte(instanceKey))
+}
+
+// LoadProtocols returns the stored protocols
+func (s *BoltStore) LoadProtocols() ([]Service, error) {
+	services := make([]Service, 0)
+	err := s.db.View(func(tx *bolt.Tx) error {
+		return tx.Bucket(boltProtocolsBucket).ForEach(func(k, v []byte) error {
+			p, roles, err := unwrapProtocol(v)
+			if err != nil {
+				return err
+			}
+			services = append(services, Service{Protocol: p, Roles: roles})
+			return nil
+		})
+	})
+	return services, err
+}
+
+// PutProtocol stores a protocol offered by the node and
+// the roles it plays in it
+func (s *BoltStore) PutProtocol(service Service) error {
+	key := []byte(service.Protocol.Key())
+	return s.put(boltProtocolsBucket, key, wrapProtocol(service.Protocol, service.Roles...))
+}
+
+// LoadOutbound returns all the queued events
+func (s *BoltStore) LoadOutbound() ([]OutboundEvent, error) {
+	queued := make([]OutboundEvent, 0)
+	err := s.db.View(func(tx *bolt.Tx) error {
+		return tx.Bucket(boltOutboundBucket).ForEach(func(k, v []byte) error {
+			e, err := unmarshalOutbound(v)
+			if err != nil {
+				return err
+			}
+			queued = append(queued, e)
+			return nil
+		})
+	})
+	sortOutbound(queued)
+	return queued, err
+}
+
+// PutOutbound queues an event
+func (s *BoltStore) PutOutbound(e OutboundEvent) error {
+	data, err := marshalOutbound(e)
+	if err != nil {
+		return err
+	}
+	return s.put(boltOutboundBucket, []byte(outboundKey(e.Target, e.ID)), data)
+}
+
+// DeleteOutbound removes an event from the queue
+func (s *BoltStore) DeleteOutbound(target peer.ID, id string) error {
+	return s.delete(boltOutboundBucket, []byte(outboundKey(target, id)))
+}
+
+func (s *BoltStore) put(bucket, key, value []byte) error {
+	return s.db.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket(bucket).Put(key, value)
+	})
+}
+
+func (s *BoltStore) delete(bucket, key []byte) error {
+	return s.db.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket(bucket).Delete(key)
+	})
+}
@@ -2,6 +2,7 @@ package net
 
 import (
 	"path/filepath"
+	"time"
 
 	log "github.com/ipfs/go-log"
 	"github.com/libp2p/go-libp2p-core/protocol"
@@ -10,18 +11,90 @@ import (
 const (
 	// LogName identifies the log of this module
 	LogName = "nahs/net"
+	// PSKEnvKey is the environment variable LoadPSKFromEnv reads
+	// the private network PSK from by default
+	PSKEnvKey = "NAHS_PSK"
 
 	listenAddrTCPIPv4 = "/ip4/0.0.0.0/tcp/0"
 	listenAddrTCPIPv6 = "/ip6/::/tcp/0"
 
+	// streamCloseTimeout is how long a handler waits for the remote
+	// peer to close a stream after responding
+	streamCloseTimeout = 5 * time.Second
+
+	// defaultDedupWindow is how long the IDs of run events are
+	// remembered to suppress duplicates
+	defaultDedupWindow = 10 * time.Minute
+	// defaultReorderTimeout is how long an event is held waiting
+	// for its predecessors
+	defaultReorderTimeout = 5 * time.Second
+	// defaultReorderBuffer is how many events may be held at once
+	defaultReorderBuffer = 64
+	// defaultSequenceTTL is how long the sequence numbers of an
+	// instance are kept without events
+	defaultSequenceTTL = 24 * time.Hour
+	// sweepInterval is how often expired sequence numbers are
+	// forgotten
+	sweepInterval = time.Minute
+
+	// defaultSendTimeout is how long a single try to send an
+	// event may take
+	defaultSendTimeout = 30 * time.Second
+	// defaultRetryAttempts is how many times an event is tried
+	// to be sent before failing
+	defaultRetryAttempts = 3
+	// defaultRetryBackoff is the wait before the first retry,
+	// doubled after each one
+	defaultRetryBackoff = 200 * time.Millisecond
+	// defaultRetryMaxBackoff is the longest wait between retries
+	defaultRetryMaxBackoff = 5 * time.Second
+	// defaultOutboxInterval is how often queued events are
+	// redelivered
+	defaultOutboxInterval = time.Minute
+
+	// defaultDiscoveryInterval is how often the discovery loop
+	// announces the node and searches for other peers
+	defaultDiscoveryInterval = 5 * time.Minute
+	// defaultContactTTL is how long a contact is kept without
+	// being seen
+	defaultContactTTL = time.Hour
+
+	// mdnsExchangeTimeout limits the protocol exchange with
+	// a peer found by mDNS
+	mdnsExchangeTimeout = 30 * time.Second
+
+	// defaultSubscriptionBuffer is the size of the channel of
+	// an event subscription
+	defaultSubscriptionBuffer = 64
+
+	// defaultBootstrapTimeout is how long the node tries to
+	// connect to the bootstrap peers
+	defaultBootstrapTimeout = 30 * time.Second
+
+	// pskLength is the length of a private network PSK in bytes
+	pskLength = 32
+	// pskHeader and pskEncodingBase16 are the header lines of
+	// a V1 PSK encoded in base16
+	pskHeader         = "/key/swarm/psk/1.0.0/"
+	pskEncodingBase16 = "/base16/"
+	// pskEncodingBase64 and pskEncodingBin are the other
+	// encodings of a V1 PSK
+	pskEncodingBase64 = "/base64/"
+	pskEncodingBin    = "/bin/"
+
 	// rendezvousString will identify the NaHS nodes at
-	// the rendezvous points
+	// the rendezvous points unless another namespace is set
 	rendezvousString = "nahs-rendezvous"
 
 	// ID of the BSPL discovery protocol
-	protocolEchoID      = protocol.ID("/nahs/echo/0.0.1")
-	protocolEventID     = protocol.ID("/nahs/bspl/event/0.0.1")
-	protocolDiscoveryID = protocol.ID("/nahs/bspl/discovery/0.0.1")
+	protocolEchoID      = protocol.ID("/nahs/echo/0.1.0")
+	protocolEventID     = protocol.ID("/nahs/bspl/event/0.1.0")
+	protocolDiscoveryID = protocol.ID("/nahs/bspl/discovery/0.1.0")
+
+	// IDs of the protocols using the delimited exchange
+	legacyProtocolEchoID      = protocol.ID("/nahs/echo/0.0.1")
+	legacyProtocolEventID     = protocol.ID("/nahs/bspl/event/0.0.1")
+	legacyProtocolDiscoveryID = protocol.ID("/nahs/bspl/discovery/0.0.1")
 )
 
 var (
@@ -1,6 +1,10 @@
 package net
 
 import (
+	"sort"
+	"time"
+
+	"github.com/libp2p/go-libp2p-core/network"
 	"github.com/libp2p/go-libp2p-core/peer"
 	"github.com/mikelsr/bspl"
 )
@@ -19,23 +23,20 @@ type Services map[string]Service
 // Contacts of peer: the nodes that have announced services
 type Contacts map[peer.ID]Services
 
-// AddContact adds a new contact to the Node
-func (n *Node) AddContact(id peer.ID, services ...Service) {
-	servs, found := n.Contacts[id]
-	if !found {
-		servs = make(Services)
-		for _, s := range services {
-			servs[s.Protocol.Key()] = s
-		}
-		n.Contacts[id] = servs
-	}
-	// it will react the same way and overwrite protocols with same id
-	// but I'm leaving this branch here in case I change my mind
-	if found {
-		for _, s := range services {
-			n.Contacts[id][s.Protocol.Key()] = s
-		}
+// copy returns a shallow copy of the Services
+func (s Services) copy() Services {
+	services := make(Services, len(s))
+	for key, service := range s {
+		services[key] = service
 	}
+	return services
+}
+
+// AddContact adds a new contact to the Node. If the contact
+// already existed the services are added to the known ones,
+// overwriting those with the same protocol key.
+func (n *Node) AddContact(id peer.ID, services ...Service) {
+	n.contacts.addServices(id, services...)
 }
 
 // AddServices is functionally the same as AddContact for now.
@@ -43,14 +44,58 @@ func (n *Node) AddServices(id peer.ID, services ...Service) {
 	n.AddContact(id, services...)
 }
 
-/*
-func (n *Node) AddServices(id peer.ID, services Services) {
-	servs := make([]Service, len(services))
-	i := 0
-	for _, v := range services {
-		servs[i] = v
-		i++
+// ContactQuery selects contacts in FindContacts. Empty fields
+// match every contact.
+type ContactQuery struct {
+	// ProtocolKey of a service the contact offers
+	ProtocolKey string
+	// ProtocolName of a service the contact offers
+	ProtocolName string
+	// Roles the contact plays, all of them in the same service
+	Roles []bspl.Role
+	// Connected only matches contacts the node is connected to
+	Connected bool
+	// SeenWithin only matches contacts seen within the duration
+	SeenWithin time.Duration
+}
+
+// matches checks if a service satisfies the protocol and role
+// filters of the query
+func (q ContactQuery) matches(s Service) bool {
+	if q.ProtocolKey != "" && s.Protocol.Key() != q.ProtocolKey {
+		return false
+	}
+	if q.ProtocolName != "" && s.Protocol.Name != q.ProtocolName {
+		return false
+	}
+	for _, role := range q.Roles {
+		if !playsRole(s.Roles, role) {
+			return false
+		}
+	}
+	return true
+}
+
+// FindContacts returns the contacts matching a query sorted by
+// their peer.ID. A contact matches if any of its services does.
+func (n *Node) FindContacts(query ContactQuery) []peer.ID {
+	ids := make([]peer.ID, 0)
+	for contact, services := range n.contacts.Snapshot() {
+		if query.Connected && n.host.Network().Connectedness(contact) != network.Connected {
+			continue
+		}
+		if query.SeenWithin > 0 {
+			if seen, _ := n.contacts.LastSeen(contact); time.Since(seen) > query.SeenWithin {
+				continue
+			}
+		}
+		for _, service := range services {
+			if query.matches(service) {
+				ids = append(ids, contact)
+				break
+			}
+		}
 	}
-	n.AddContact(id, servs...)
+	sort.Slice(ids, func(i, j int) bool { return ids[i] < ids[j] })
+	return ids
 }
-*/
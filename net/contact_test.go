@@ -0,0 +1,93 @@
+package net
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/libp2p/go-libp2p-core/peer"
+	"github.com/mikelsr/bspl"
+)
+
+func TestNode_FindContacts(t *testing.T) {
+	n := testNodes(4)
+	for _, node := range n {
+		defer node.Close(context.Background())
+	}
+	n1, a, b, c := n[0], n[1].ID(), n[2].ID(), n[3].ID()
+	ra, rb := tp1.Roles[0], tp1.Roles[1]
+	rx, ry := tp2.Roles[0], tp2.Roles[1]
+
+	n1.AddContact(a, Service{Protocol: tp1, Roles: []bspl.Role{ra}})
+	n1.AddContact(b, Service{Protocol: tp1, Roles: []bspl.Role{ra, rb}},
+		Service{Protocol: tp2, Roles: []bspl.Role{rx}})
+	n1.AddContact(c, Service{Protocol: tp2, Roles: []bspl.Role{rx, ry}})
+	// c was seen long ago
+	n1.contacts.mutex.Lock()
+	n1.contacts.seen[c] = time.Now().Add(-2 * time.Hour)
+	n1.contacts.mutex.Unlock()
+	// n1 is only connected to c
+	if err := n1.host.Connect(context.Background(), peer.AddrInfo{ID: c, Addrs: n[3].Addrs()}); err != nil {
+		t.Log(err)
+		t.FailNow()
+	}
+
+	testCases := []struct {
+		name     string
+		query    ContactQuery
+		expected []peer.ID
+	}{
+		{"All", ContactQuery{}, []peer.ID{a, b, c}},
+		{"Protocol", ContactQuery{ProtocolKey: tp1.Key()}, []peer.ID{a, b}},
+		{"ProtocolName", ContactQuery{ProtocolName: tp2.Name}, []peer.ID{b, c}},
+		{"UnknownProtocol", ContactQuery{ProtocolKey: "unknown"}, []peer.ID{}},
+		{"Role", ContactQuery{ProtocolKey: tp1.Key(), Roles: []bspl.Role{rb}}, []peer.ID{b}},
+		{"Roles", ContactQuery{Roles: []bspl.Role{rx, ry}}, []peer.ID{c}},
+		// roles must be played in the same service
+		{"RolesAcrossServices", ContactQuery{Roles: []bspl.Role{rb, rx}}, []peer.ID{}},
+		{"Connected", ContactQuery{Roles: []bspl.Role{rx}, Connected: true}, []peer.ID{c}},
+		{"SeenWithin", ContactQuery{SeenWithin: time.Hour}, []peer.ID{a, b}},
+	}
+	for _, tc := range testCases {
+		ids := n1.FindContacts(tc.query)
+		if !sortedIDs(ids) || !sameIDs(ids, tc.expected) {
+			t.Logf("%s: expected %v, got %v", tc.name, tc.expected, ids)
+			t.FailNow()
+		}
+	}
+
+	// FindContact matches the role and returns every contact
+	if ids := n1.FindContact(tp1.Key(), ra); len(ids) != 2 {
+		t.FailNow()
+	}
+	if ids := n1.FindContact(tp1.Key(), rx); len(ids) != 0 {
+		t.FailNow()
+	}
+}
+
+// sortedIDs checks if a slice of peer.IDs is sorted
+func sortedIDs(ids []peer.ID) bool {
+	for i := 1; i < len(ids); i++ {
+		if ids[i-1] > ids[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// sameIDs checks if two slices have the same peer.IDs in any order
+func sameIDs(a, b []peer.ID) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	set := make(map[peer.ID]bool, len(a))
+	for _, id := range a {
+		set[id] = true
+	}
+	for _, id := range b {
+		if !set[id] {
+			return false
+		}
+	}
+	return true
+}
@@ -1,40 +1,61 @@
 package net
 
 import (
-	"bufio"
+	"context"
+	"sort"
 	"sync"
+	"time"
 
 	"github.com/libp2p/go-libp2p-core/peer"
 	discovery "github.com/libp2p/go-libp2p-discovery"
 	dht "github.com/libp2p/go-libp2p-kad-dht"
 	peerstore "github.com/libp2p/go-libp2p-peerstore"
+	mdns "github.com/libp2p/go-libp2p/p2p/discovery"
+	"github.com/mikelsr/bspl"
 )
 
-// configureDiscovery configures the node, connects to bootstrap nodes
-// and announces self in the nodes
-func (n *Node) configDiscovery() {
+// configDiscovery configures the discovery mode of the node
+func (n *Node) configDiscovery() error {
+	if n.discoveryMode == DiscoveryMDNS {
+		return n.configMDNS()
+	}
+	return n.configDHT()
+}
+
+// configDHT configures the node, connects to the bootstrap peers
+// and announces self in the rendezvous namespace. Bootstrap peers
+// that can't be reached within the bootstrap timeout are logged and
+// ignored.
+func (n *Node) configDHT() error {
 	// A local DHT will store network information in case bootstrap nodes
 	// go down
-	kademliaDHT, err := dht.New(n.context, n.host)
+	kademliaDHT, err := dht.New(n.context, n.host, dht.Mode(n.dhtMode))
 	if err != nil {
-		panic(err)
+		return err
 	}
+	n.dht = kademliaDHT
+	n.routing = discovery.NewRoutingDiscovery(n.dht)
 
 	// With the default configuration this will spawn a background thread
 	// that will refresh the peer table ever 5 minutes
 	logger.Debug("Bootstrapping the DHT")
 	if err = kademliaDHT.Bootstrap(n.context); err != nil {
-		panic(err)
+		return err
 	}
 
+	ctx, cancel := context.WithTimeout(n.context, n.bootstrapTimeout)
+	defer cancel()
 	var wg sync.WaitGroup
-	// Use default IPFS bootstrap peers
-	for _, peerAddr := range dht.DefaultBootstrapPeers {
-		peerinfo, _ := peer.AddrInfoFromP2pAddr(peerAddr)
+	for _, peerAddr := range n.bootstrapPeers {
+		peerinfo, err := peer.AddrInfoFromP2pAddr(peerAddr)
+		if err != nil {
+			logger.Warningf("Invalid bootstrap address '%s': %s", peerAddr, err)
+			continue
+		}
 		wg.Add(1)
 		go func() {
 			defer wg.Done()
-			if err := n.host.Connect(n.context, *peerinfo); err != nil {
+			if err := n.host.Connect(ctx, *peerinfo); err != nil {
 				logger.Warning(err)
 			} else {
 				logger.Debug("Connection established with bootstrap node:", *peerinfo)
@@ -44,47 +65,260 @@ func (n *Node) configDiscovery() {
 	wg.Wait()
 
 	// Announce this node
-	n.Announce()
+	return n.Announce()
+}
+
+// configMDNS starts searching for other NaHS nodes in the local
+// network with mDNS every discovery interval, using the rendezvous
+// namespace as service tag
+func (n *Node) configMDNS() error {
+	logger.Debug("Starting mDNS discovery")
+	service, err := mdns.NewMdnsService(n.context, n.host, n.discoveryInterval, n.rendezvous)
+	if err != nil {
+		return err
+	}
+	service.RegisterNotifee(mdnsNotifee{node: n})
+	n.mdns = service
+	return nil
+}
+
+// mdnsNotifee exchanges protocols with the peers found by mDNS
+type mdnsNotifee struct {
+	node *Node
+}
+
+// HandlePeerFound is called by the mDNS service for every peer found
+// in the local network
+func (m mdnsNotifee) HandlePeerFound(info peer.AddrInfo) {
+	n := m.node
+	if info.ID == n.ID() || n.context.Err() != nil {
+		return
+	}
+	logger.Debugf("Found peer in local network: %s", info.ID)
+	n.host.Peerstore().AddAddrs(info.ID, info.Addrs, peerstore.PermanentAddrTTL)
+	if !n.exchangeDue(info.ID) {
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(n.context, mdnsExchangeTimeout)
+	defer cancel()
+	if err := n.discoverPeer(ctx, info.ID); err != nil {
+		logger.Warningf("Could not exchange protocols with peer '%s': %s", info.ID, err)
+	}
 }
 
-// Announce self in network
-func (n *Node) Announce() {
+// Announce self in network, in the rendezvous namespace and in the
+// namespace of each service the node offers. Previous announcements
+// are stopped.
+func (n *Node) Announce() error {
+	if n.routing == nil {
+		return ErrNoDiscovery
+	}
 	logger.Debug("Announce self")
-	routingDiscovery := discovery.NewRoutingDiscovery(n.dht)
-	discovery.Advertise(n.context, routingDiscovery, rendezvousString)
+	n.advertiseMutex.Lock()
+	if n.stopAdvertising != nil {
+		n.stopAdvertising()
+	}
+	n.advertising, n.stopAdvertising = context.WithCancel(n.context)
+	n.advertised = make(map[string]bool)
+	discovery.Advertise(n.advertising, n.routing, n.rendezvous)
+	n.advertiseMutex.Unlock()
+
+	n.protocolsMutex.RLock()
+	roles := make(map[string][]bspl.Role, len(n.roles))
+	for key, r := range n.roles {
+		roles[key] = r
+	}
+	n.protocolsMutex.RUnlock()
+	for key, r := range roles {
+		n.advertiseServices(key, r...)
+	}
+	return nil
+}
+
+// serviceNamespace is the namespace the nodes playing a role in a
+// protocol are announced in
+func (n *Node) serviceNamespace(protocolKey string, role bspl.Role) string {
+	return n.rendezvous + "/" + protocolKey + "/" + string(role)
+}
+
+// advertiseServices advertises the node in the namespaces of the
+// roles of a protocol that are not advertised yet. Nothing is
+// advertised until the node is announced.
+func (n *Node) advertiseServices(protocolKey string, roles ...bspl.Role) {
+	n.advertiseMutex.Lock()
+	defer n.advertiseMutex.Unlock()
+	if n.advertising == nil {
+		return
+	}
+	for _, role := range roles {
+		ns := n.serviceNamespace(protocolKey, role)
+		if n.advertised[ns] {
+			continue
+		}
+		logger.Debugf("Advertise service '%s'", ns)
+		n.advertised[ns] = true
+		discovery.Advertise(n.advertising, n.routing, ns)
+	}
+}
+
+// startDiscovery runs discoverOnce when the node starts and then
+// every discovery interval until the node is closed
+func (n *Node) startDiscovery() {
+	n.background.Add(1)
+	go func() {
+		defer n.background.Done()
+		n.discoverOnce()
+		ticker := time.NewTicker(n.discoveryInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-n.context.Done():
+				return
+			case <-ticker.C:
+				n.discoverOnce()
+			}
+		}
+	}()
+}
+
+// discoverOnce announces the node, searches for other peers to refresh
+// their services and evicts the contacts not seen within the contact
+// TTL. The search is limited to the discovery interval and skips the
+// peers protocols were recently exchanged with.
+func (n *Node) discoverOnce() {
+	start := time.Now()
+	defer func() { n.metrics.discoveryPass(time.Since(start)) }()
+	if n.routing != nil {
+		if err := n.Announce(); err != nil {
+			logger.Warningf("Could not announce node: %s", err)
+		}
+		ctx, cancel := context.WithTimeout(n.context, n.discoveryInterval)
+		if err := n.findNodes(ctx, true); err != nil {
+			logger.Warningf("Discovery failed: %s", err)
+		}
+		cancel()
+	}
+	if n.contactTTL > 0 {
+		for _, id := range n.contacts.expire(n.contactTTL) {
+			logger.Debugf("Contact '%s' expired", id)
+		}
+	}
+}
+
+// FindNodes searches for other NaHS nodes in the network and exchanges
+// protocols with them. Peers that fail the exchange are skipped and
+// returned in an ErrPeers.
+func (n *Node) FindNodes(ctx context.Context) error {
+	return n.findNodes(ctx, false)
 }
 
-// FindNodes searches for other NaHS nodes in the network
-func (n *Node) FindNodes() {
+// findNodes runs FindNodes. If skipRecent is set the peers protocols
+// were recently exchanged with are skipped, see exchangeDue.
+func (n *Node) findNodes(ctx context.Context, skipRecent bool) error {
+	if n.routing == nil {
+		return ErrNoDiscovery
+	}
 	// Look for other NaHS nodes that have announced themselves
 	logger.Debug("Search for other peers")
-	peerChan, err := n.routing.FindPeers(n.context, rendezvousString)
+	peerChan, err := n.routing.FindPeers(ctx, n.rendezvous)
 	if err != nil {
-		panic(err)
+		return err
 	}
+	errs := make(ErrPeers)
 	for peer := range peerChan {
 		if peer.ID == n.ID() {
 			continue
 		}
 		logger.Debugf("Found peer: %s", peer.ID)
 		n.host.Peerstore().AddAddrs(peer.ID, peer.Addrs, peerstore.PermanentAddrTTL)
+		if skipRecent && !n.exchangeDue(peer.ID) {
+			continue
+		}
 
 		// Exchange known services with the node
-		stream, err := n.host.NewStream(n.context, peer.ID, protocolDiscoveryID)
-		if err != nil {
-			n.cancel()
-			panic(err)
+		if err := n.discoverPeer(ctx, peer.ID); err != nil {
+			logger.Warningf("Could not exchange protocols with peer '%s': %s", peer.ID, err)
+			errs[peer.ID] = err
+		}
+	}
+	if len(errs) > 0 {
+		return errs
+	}
+	return nil
+}
+
+// FindProviders searches for the nodes announced in the namespace of
+// a role of a protocol and exchanges protocols only with them. The
+// IDs of the nodes that play the role after the exchange are returned,
+// sorted. Peers that fail the exchange are skipped and returned in an
+// ErrPeers along with the nodes found.
+func (n *Node) FindProviders(ctx context.Context, protocolKey string, role bspl.Role) ([]peer.ID, error) {
+	if n.routing == nil {
+		return nil, ErrNoDiscovery
+	}
+	ns := n.serviceNamespace(protocolKey, role)
+	logger.Debugf("Search for providers of '%s'", ns)
+	peerChan, err := n.routing.FindPeers(ctx, ns)
+	if err != nil {
+		return nil, err
+	}
+	query := ContactQuery{ProtocolKey: protocolKey, Roles: []bspl.Role{role}}
+	providers := make([]peer.ID, 0)
+	errs := make(ErrPeers)
+	for info := range peerChan {
+		if info.ID == n.ID() {
+			continue
+		}
+		logger.Debugf("Found provider: %s", info.ID)
+		n.host.Peerstore().AddAddrs(info.ID, info.Addrs, peerstore.PermanentAddrTTL)
+		if err := n.discoverPeer(ctx, info.ID); err != nil {
+			logger.Warningf("Could not exchange protocols with peer '%s': %s", info.ID, err)
+			errs[info.ID] = err
+			continue
 		}
-		rw := bufio.NewReadWriter(bufio.NewReader(stream), bufio.NewWriter(stream))
-		// Spawn routines to send the services offered by this node
-		// to the other node
-		// The wait group will be ignored
-		var wg sync.WaitGroup
-		wg.Add(2)
-		go n.discoveryReadData(rw, &wg, peer.ID)
-		go n.discoveryWriteData(rw, &wg)
-		wg.Wait()
-	}
-	// block execution of this routine permantently
-	// select {}
+		services, _ := n.contacts.Get(info.ID)
+		for _, s := range services {
+			if query.matches(s) {
+				providers = append(providers, info.ID)
+				break
+			}
+		}
+	}
+	sort.Slice(providers, func(i, j int) bool { return providers[i] < providers[j] })
+	if len(errs) > 0 {
+		return providers, errs
+	}
+	return providers, nil
+}
+
+// exchangeDue reports whether protocols must be exchanged with a
+// peer found by a discovery pass. Peers they were exchanged with
+// within half the contact TTL are skipped, so every contact is
+// exchanged with again before it expires. If contacts don't expire
+// defaultContactTTL is used.
+func (n *Node) exchangeDue(id peer.ID) bool {
+	ttl := n.contactTTL
+	if ttl <= 0 {
+		ttl = defaultContactTTL
+	}
+	if n.contacts.exchangedWithin(id, ttl/2) {
+		logger.Debugf("Skip peer '%s', protocols were exchanged recently", id)
+		return false
+	}
+	return true
+}
+
+// discoverPeer exchanges protocols with a single peer
+func (n *Node) discoverPeer(ctx context.Context, id peer.ID) error {
+	stream, err := n.host.NewStream(ctx, id, protocolDiscoveryID, legacyProtocolDiscoveryID)
+	if err != nil {
+		return err
+	}
+	defer stream.Close()
+	n.metrics.streamOpened(stream.Protocol(), directionOutbound)
+	if stream.Protocol() == legacyProtocolDiscoveryID {
+		return n.legacyExchangeProtocols(stream, id)
+	}
+	return n.exchangeProtocols(stream, id)
 }
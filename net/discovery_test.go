@@ -0,0 +1,313 @@
+package net
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/libp2p/go-libp2p-core/network"
+	"github.com/libp2p/go-libp2p-core/peer"
+	dht "github.com/libp2p/go-libp2p-kad-dht"
+	"github.com/mikelsr/bspl"
+	"github.com/multiformats/go-multiaddr"
+)
+
+func TestNode_FindNodes(t *testing.T) {
+	n := testNodes(1)[0]
+	defer n.Close(context.Background())
+	// LocalNode-like nodes have no rendezvous discovery
+	if err := n.FindNodes(context.Background()); !errors.Is(err, ErrNoDiscovery) {
+		t.FailNow()
+	}
+	if err := n.Announce(); !errors.Is(err, ErrNoDiscovery) {
+		t.FailNow()
+	}
+}
+
+func TestNode_discoverPeer(t *testing.T) {
+	n := testNodes(3)
+	n1, n2, n3 := n[0], n[1], n[2]
+	defer n1.Close(context.Background())
+	defer n2.Close(context.Background())
+
+	// several protocols are exchanged in a single pass
+	n2.AddProtocol(tp1, tp1.Roles...)
+	n2.AddProtocol(tp2, tp2.Roles...)
+	if err := n1.discoverPeer(context.Background(), n2.ID()); err != nil {
+		t.Log(err)
+		t.FailNow()
+	}
+	if services, _ := n1.Contacts().Get(n2.ID()); len(services) != 2 {
+		t.FailNow()
+	}
+	// the exchanged services replace the known ones
+	n1.AddContact(n2.ID(), Service{Protocol: testProtocol(), Roles: []bspl.Role{"Buyer"}})
+	if err := n1.discoverPeer(context.Background(), n2.ID()); err != nil {
+		t.Log(err)
+		t.FailNow()
+	}
+	if services, _ := n1.Contacts().Get(n2.ID()); len(services) != 2 {
+		t.FailNow()
+	}
+
+	// unreachable peers return an error instead of panicking
+	n3.Close(context.Background())
+	if err := n1.discoverPeer(context.Background(), n3.ID()); err == nil {
+		t.FailNow()
+	}
+}
+
+func TestNode_discoveryLoop(t *testing.T) {
+	n, err := nodeFromPrivKey(*testKeys[0], WithDiscoveryInterval(20*time.Millisecond),
+		WithContactTTL(50*time.Millisecond))
+	if err != nil {
+		t.Log(err)
+		t.FailNow()
+	}
+	contact := testID(1)
+	n.AddContact(contact, Service{Protocol: tp1, Roles: tp1.Roles})
+	n.startDiscovery()
+
+	// contacts are kept while they are seen
+	for i := 0; i < 5; i++ {
+		time.Sleep(20 * time.Millisecond)
+		n.contacts.touch(contact)
+	}
+	if n.Contacts().Len() != 1 {
+		t.FailNow()
+	}
+	// and evicted when they are not
+	deadline := time.Now().Add(2 * time.Second)
+	for n.Contacts().Len() != 0 {
+		if time.Now().After(deadline) {
+			t.FailNow()
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	// closing the node stops the loop
+	if err := n.Close(context.Background()); err != nil {
+		t.Log(err)
+		t.FailNow()
+	}
+}
+
+func TestNode_discoveryLoopStart(t *testing.T) {
+	n, err := nodeFromPrivKey(*testKeys[0], WithDiscoveryInterval(time.Hour),
+		WithContactTTL(time.Millisecond))
+	if err != nil {
+		t.Log(err)
+		t.FailNow()
+	}
+	defer n.Close(context.Background())
+	n.AddContact(testID(1), Service{Protocol: tp1, Roles: tp1.Roles})
+	time.Sleep(10 * time.Millisecond)
+	n.startDiscovery()
+
+	// the first pass runs without waiting for the interval
+	deadline := time.Now().Add(2 * time.Second)
+	for n.Contacts().Len() != 0 {
+		if time.Now().After(deadline) {
+			t.FailNow()
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestNode_mdnsNotifee(t *testing.T) {
+	n := testNodes(2)
+	n1, n2 := n[0], n[1]
+	defer n1.Close(context.Background())
+	defer n2.Close(context.Background())
+	n2.AddProtocol(tp1, tp1.Roles...)
+
+	// peers found in the local network exchange protocols
+	notifee := mdnsNotifee{node: n1}
+	notifee.HandlePeerFound(peer.AddrInfo{ID: n2.ID(), Addrs: n2.Addrs()})
+	if services, found := n1.Contacts().Get(n2.ID()); !found || len(services) != 1 {
+		t.FailNow()
+	}
+	// the node itself is ignored
+	notifee.HandlePeerFound(peer.AddrInfo{ID: n1.ID(), Addrs: n1.Addrs()})
+	if _, found := n1.Contacts().Get(n1.ID()); found {
+		t.FailNow()
+	}
+	// peers protocols were recently exchanged with are skipped
+	n2.AddProtocol(tp2, tp2.Roles...)
+	notifee.HandlePeerFound(peer.AddrInfo{ID: n2.ID(), Addrs: n2.Addrs()})
+	if services, _ := n1.Contacts().Get(n2.ID()); len(services) != 1 {
+		t.FailNow()
+	}
+	// until the node offers a new protocol
+	n1.AddProtocol(testProtocol(), "Buyer")
+	notifee.HandlePeerFound(peer.AddrInfo{ID: n2.ID(), Addrs: n2.Addrs()})
+	if services, _ := n1.Contacts().Get(n2.ID()); len(services) != 2 {
+		t.FailNow()
+	}
+}
+
+func TestNode_exchangeDue(t *testing.T) {
+	n, err := nodeFromPrivKey(*testKeys[0], WithContactTTL(time.Minute))
+	if err != nil {
+		t.Log(err)
+		t.FailNow()
+	}
+	defer n.Close(context.Background())
+	id := testID(1)
+	if !n.exchangeDue(id) {
+		t.FailNow()
+	}
+	n.contacts.markExchanged(id)
+	if n.exchangeDue(id) {
+		t.FailNow()
+	}
+	// peers are exchanged with again within the contact TTL
+	n.contacts.mutex.Lock()
+	n.contacts.exchanges[id] = time.Now().Add(-40 * time.Second)
+	n.contacts.mutex.Unlock()
+	if !n.exchangeDue(id) {
+		t.FailNow()
+	}
+}
+
+func TestNode_configMDNS(t *testing.T) {
+	if _, err := applyOptions(WithDiscoveryMode(DiscoveryMode(-1))); err == nil {
+		t.FailNow()
+	}
+	n := make([]*Node, 2)
+	for i := range n {
+		node, err := nodeFromPrivKey(*testKeys[i], WithDiscoveryMode(DiscoveryMDNS),
+			WithDiscoveryInterval(time.Second))
+		if err != nil {
+			t.Log(err)
+			t.FailNow()
+		}
+		if err := node.configDiscovery(); err != nil {
+			t.Log(err)
+			t.FailNow()
+		}
+		n[i] = node
+	}
+	// there is no rendezvous discovery in the local network
+	if err := n[0].Announce(); !errors.Is(err, ErrNoDiscovery) {
+		t.FailNow()
+	}
+	for _, node := range n {
+		if err := node.Close(context.Background()); err != nil {
+			t.Log(err)
+			t.FailNow()
+		}
+	}
+}
+
+func TestNode_bootstrapPeers(t *testing.T) {
+	invalid := []Option{
+		WithBootstrapPeers(multiaddr.StringCast("/ip4/127.0.0.1/tcp/4001")),
+		WithBootstrapTimeout(0),
+		WithDHTMode(dht.ModeOpt(-1)),
+		WithRendezvous(""),
+	}
+	for _, opt := range invalid {
+		if _, err := applyOptions(opt); err == nil {
+			t.FailNow()
+		}
+	}
+
+	// a private DHT with a single bootstrap peer
+	boot := testDHTNode(t, 0, "test")
+	defer boot.Close(context.Background())
+	n1 := testDHTNode(t, 1, "test", boot)
+	defer n1.Close(context.Background())
+	n2 := testDHTNode(t, 2, "other", boot)
+	defer n2.Close(context.Background())
+	if n1.host.Network().Connectedness(boot.ID()) != network.Connected {
+		t.FailNow()
+	}
+
+	// only nodes in the same namespace are found
+	n1.AddProtocol(tp1, tp1.Roles...)
+	n2.AddProtocol(tp2, tp2.Roles...)
+	deadline := time.Now().Add(10 * time.Second)
+	for {
+		ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+		boot.FindNodes(ctx)
+		cancel()
+		if _, found := boot.Contacts().Get(n1.ID()); found {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.FailNow()
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+	if _, found := boot.Contacts().Get(n2.ID()); found {
+		t.FailNow()
+	}
+}
+
+// testDHTNode creates a node in a private DHT with the nodes in
+// bootstrap as bootstrap peers
+func testDHTNode(t *testing.T, i int, rendezvous string, bootstrap ...*Node) *Node {
+	addrs := make([]multiaddr.Multiaddr, 0)
+	for _, b := range bootstrap {
+		a, err := peer.AddrInfoToP2pAddrs(&peer.AddrInfo{ID: b.ID(), Addrs: b.Addrs()})
+		if err != nil {
+			t.Log(err)
+			t.FailNow()
+		}
+		addrs = append(addrs, a...)
+	}
+	n, err := nodeFromPrivKey(*testKeys[i], WithBootstrapPeers(addrs...),
+		WithBootstrapTimeout(time.Second), WithDHTMode(dht.ModeServer),
+		WithRendezvous(rendezvous))
+	if err != nil {
+		t.Log(err)
+		t.FailNow()
+	}
+	if err := n.configDiscovery(); err != nil {
+		t.Log(err)
+		t.FailNow()
+	}
+	return n
+}
+
+func TestNode_FindProviders(t *testing.T) {
+	local := testNodes(1)[0]
+	defer local.Close(context.Background())
+	if _, err := local.FindProviders(context.Background(), tp1.Key(), tp1.Roles[0]); !errors.Is(err, ErrNoDiscovery) {
+		t.FailNow()
+	}
+	boot := testDHTNode(t, 0, "test")
+	defer boot.Close(context.Background())
+	n1 := testDHTNode(t, 1, "test", boot)
+	defer n1.Close(context.Background())
+	n2 := testDHTNode(t, 2, "test", boot)
+	defer n2.Close(context.Background())
+
+	// services added after announcing the node are advertised too
+	ra, rb := tp1.Roles[0], tp1.Roles[1]
+	n1.AddProtocol(tp1, ra)
+	n2.AddProtocol(tp2, tp2.Roles...)
+	deadline := time.Now().Add(10 * time.Second)
+	for {
+		ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+		providers, _ := boot.FindProviders(ctx, tp1.Key(), ra)
+		cancel()
+		if len(providers) == 1 && providers[0] == n1.ID() {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.FailNow()
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+	// only the providers are contacted
+	if _, found := boot.Contacts().Get(n2.ID()); found {
+		t.FailNow()
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	if providers, err := boot.FindProviders(ctx, tp1.Key(), rb); err != nil || len(providers) != 0 {
+		t.FailNow()
+	}
+}
@@ -1,14 +1,34 @@
 package net
 
 import (
+	"errors"
 	"strings"
+
+	"github.com/libp2p/go-libp2p-core/peer"
+)
+
+var (
+	// ErrInvalidPSK is returned when a private network PSK
+	// is not 32 bytes long
+	ErrInvalidPSK = errors.New("Private network PSK must be 32 bytes long")
+	// ErrNoDiscovery is returned when using rendezvous discovery
+	// on a node that was created without it
+	ErrNoDiscovery = errors.New("Discovery is not configured for this node")
+	// ErrInstanceUnknown is returned when publishing an event
+	// on an instance the reasoner of the node doesn't know
+	ErrInstanceUnknown = errors.New("Instance unknown to the reasoner")
+	// ErrNoParticipants is returned when publishing an event
+	// on an instance with no participants other than the node
+	ErrNoParticipants = errors.New("No participants found for instance")
 )
 
 // ErrHandleEvent is returned when handling
 // events and sent as a response
 type ErrHandleEvent struct {
-	ID     string
-	Reason string
+	ID            string
+	Status        EventStatus
+	Reason        string
+	ReasonerError string
 }
 
 func (e ErrHandleEvent) Error() string {
@@ -17,5 +37,141 @@ func (e ErrHandleEvent) Error() string {
 	if e.Reason != "" {
 		sb.WriteString(": " + e.Reason)
 	}
+	if e.ReasonerError != "" {
+		sb.WriteString(": " + e.ReasonerError)
+	}
+	return sb.String()
+}
+
+// ErrInvalidEvent is returned when an event can't be parsed
+type ErrInvalidEvent struct{ ErrHandleEvent }
+
+// Unwrap returns the underlying ErrHandleEvent
+func (e ErrInvalidEvent) Unwrap() error { return e.ErrHandleEvent }
+
+// ErrInstanceNotFound is returned when the instance of an
+// event is not open
+type ErrInstanceNotFound struct{ ErrHandleEvent }
+
+// Unwrap returns the underlying ErrHandleEvent
+func (e ErrInstanceNotFound) Unwrap() error { return e.ErrHandleEvent }
+
+// ErrUnauthorized is returned when the sender of an event
+// is not allowed to send it
+type ErrUnauthorized struct{ ErrHandleEvent }
+
+// Unwrap returns the underlying ErrHandleEvent
+func (e ErrUnauthorized) Unwrap() error { return e.ErrHandleEvent }
+
+// ErrInstanceExists is returned when the instance of a
+// new event was already open
+type ErrInstanceExists struct{ ErrHandleEvent }
+
+// Unwrap returns the underlying ErrHandleEvent
+func (e ErrInstanceExists) Unwrap() error { return e.ErrHandleEvent }
+
+// ErrEventRejected is returned when the reasoner rejects
+// an event
+type ErrEventRejected struct{ ErrHandleEvent }
+
+// Unwrap returns the underlying ErrHandleEvent
+func (e ErrEventRejected) Unwrap() error { return e.ErrHandleEvent }
+
+// ErrBadSignature is returned when an event isn't signed or
+// its signature can't be verified
+type ErrBadSignature struct{ ErrHandleEvent }
+
+// Unwrap returns the underlying ErrHandleEvent
+func (e ErrBadSignature) Unwrap() error { return e.ErrHandleEvent }
+
+// ErrOutOfOrder is returned when an event arrives before its
+// predecessors and the buffer of held events is full
+type ErrOutOfOrder struct{ ErrHandleEvent }
+
+// Unwrap returns the underlying ErrHandleEvent
+func (e ErrOutOfOrder) Unwrap() error { return e.ErrHandleEvent }
+
+// newErrHandleEvent wraps an ErrHandleEvent in the error
+// type matching its status
+func newErrHandleEvent(e ErrHandleEvent) error {
+	switch e.Status {
+	case StatusInvalidEvent:
+		return ErrInvalidEvent{e}
+	case StatusInstanceNotFound:
+		return ErrInstanceNotFound{e}
+	case StatusUnauthorized:
+		return ErrUnauthorized{e}
+	case StatusInstanceExists:
+		return ErrInstanceExists{e}
+	case StatusRejected:
+		return ErrEventRejected{e}
+	case StatusBadSignature:
+		return ErrBadSignature{e}
+	case StatusOutOfOrder:
+		return ErrOutOfOrder{e}
+	}
+	return e
+}
+
+// ErrEventQueued is returned by SendEvent when an event could not
+// be delivered and was queued in the Outbox to be redelivered
+type ErrEventQueued struct {
+	// Err is the error of the last try to send the event
+	Err error
+}
+
+func (e ErrEventQueued) Error() string {
+	return "Event queued for redelivery: " + e.Err.Error()
+}
+
+// Unwrap returns the error of the last try to send the event
+func (e ErrEventQueued) Unwrap() error { return e.Err }
+
+// ErrClose aggregates the errors found while closing a Node
+type ErrClose []error
+
+func (e ErrClose) Error() string {
+	var sb strings.Builder
+	sb.WriteString("Could not close node cleanly")
+	for i, err := range e {
+		if i == 0 {
+			sb.WriteString(": ")
+		} else {
+			sb.WriteString("; ")
+		}
+		sb.WriteString(err.Error())
+	}
+	return sb.String()
+}
+
+// Is reports whether any of the errors found while closing the
+// Node matches target, e.g. context.DeadlineExceeded if the stream
+// handlers didn't finish in time
+func (e ErrClose) Is(target error) bool {
+	for _, err := range e {
+		if errors.Is(err, target) {
+			return true
+		}
+	}
+	return false
+}
+
+// ErrPeers maps the peers that failed an operation to the
+// error they caused
+type ErrPeers map[peer.ID]error
+
+func (e ErrPeers) Error() string {
+	var sb strings.Builder
+	sb.WriteString("Operation failed for peers")
+	first := true
+	for id, err := range e {
+		if first {
+			sb.WriteString(": ")
+			first = false
+		} else {
+			sb.WriteString("; ")
+		}
+		sb.WriteString("'" + id.Pretty() + "': " + err.Error())
+	}
 	return sb.String()
 }
@@ -0,0 +1,105 @@
+package net
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+)
+
+// frameType identifies the content of a frame
+type frameType byte
+
+const (
+	// frameEcho carries an echo message
+	frameEcho frameType = iota + 1
+	// frameProtocol carries a wrapped BSPL protocol
+	frameProtocol
+	// frameProtocolsEnd marks the end of a protocol list
+	frameProtocolsEnd
+	// frameEvent carries a marshalled event
+	frameEvent
+	// frameEventResponse carries the response to an event
+	frameEventResponse
+)
+
+const (
+	// frameVersion is the version of the framing written
+	// by this node
+	frameVersion byte = 1
+	// maxFrameSize is the maximum size of a frame payload
+	maxFrameSize = 4 << 20
+)
+
+var (
+	// ErrFrameTooLarge is returned when a frame payload exceeds
+	// maxFrameSize
+	ErrFrameTooLarge = fmt.Errorf("Frame payload exceeds %d bytes", maxFrameSize)
+	// ErrFrameVersion is returned when a frame of an unsupported
+	// version is received
+	ErrFrameVersion = errors.New("Unsupported frame version")
+)
+
+// frame is the unit of exchange of the NaHS protocols. On the wire
+// a frame is its version byte, its type byte, the length of the
+// payload as an unsigned varint and the payload.
+type frame struct {
+	Type    frameType
+	Payload []byte
+}
+
+// writeFrame writes a frame to w. w is not flushed.
+func writeFrame(w *bufio.Writer, t frameType, payload []byte) error {
+	if len(payload) > maxFrameSize {
+		return ErrFrameTooLarge
+	}
+	header := make([]byte, 2+binary.MaxVarintLen64)
+	header[0] = frameVersion
+	header[1] = byte(t)
+	k := binary.PutUvarint(header[2:], uint64(len(payload)))
+	if _, err := w.Write(header[:2+k]); err != nil {
+		return err
+	}
+	_, err := w.Write(payload)
+	return err
+}
+
+// readFrame reads a single frame from r
+func readFrame(r *bufio.Reader) (frame, error) {
+	version, err := r.ReadByte()
+	if err != nil {
+		return frame{}, err
+	}
+	if version != frameVersion {
+		return frame{}, ErrFrameVersion
+	}
+	t, err := r.ReadByte()
+	if err != nil {
+		return frame{}, err
+	}
+	size, err := binary.ReadUvarint(r)
+	if err != nil {
+		return frame{}, err
+	}
+	if size > maxFrameSize {
+		return frame{}, ErrFrameTooLarge
+	}
+	payload := make([]byte, size)
+	if _, err := io.ReadFull(r, payload); err != nil {
+		return frame{}, err
+	}
+	return frame{Type: frameType(t), Payload: payload}, nil
+}
+
+// readFrameOfType reads a frame and checks it is of type t
+func readFrameOfType(r *bufio.Reader, t frameType) ([]byte, error) {
+	f, err := readFrame(r)
+	if err != nil {
+		return nil, err
+	}
+	if f.Type != t {
+		return nil, fmt.Errorf("Expected frame of type %d but got %d", t, f.Type)
+	}
+	return f.Payload, nil
+}
@@ -0,0 +1,66 @@
+package net
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"testing"
+)
+
+func TestFraming(t *testing.T) {
+	var buf bytes.Buffer
+	w := bufio.NewWriter(&buf)
+	// payloads containing the delimiters of the legacy exchange
+	payloads := [][]byte{
+		[]byte(`{"motive":"a|b%c"}`),
+		{},
+		bytes.Repeat([]byte{exchangeEnd, exchangeSeparator}, 200),
+	}
+	for _, p := range payloads {
+		if err := writeFrame(w, frameEvent, p); err != nil {
+			t.Log(err)
+			t.FailNow()
+		}
+	}
+	if err := writeFrame(w, frameProtocolsEnd, nil); err != nil {
+		t.FailNow()
+	}
+	w.Flush()
+
+	r := bufio.NewReader(&buf)
+	for _, p := range payloads {
+		payload, err := readFrameOfType(r, frameEvent)
+		if err != nil {
+			t.Log(err)
+			t.FailNow()
+		}
+		if !bytes.Equal(payload, p) {
+			t.FailNow()
+		}
+	}
+	if _, err := readFrameOfType(r, frameEvent); err == nil {
+		t.FailNow()
+	}
+}
+
+func TestFramingErrors(t *testing.T) {
+	w := bufio.NewWriter(new(bytes.Buffer))
+	if err := writeFrame(w, frameEvent, make([]byte, maxFrameSize+1)); !errors.Is(err, ErrFrameTooLarge) {
+		t.FailNow()
+	}
+	// unknown version
+	r := bufio.NewReader(bytes.NewReader([]byte{frameVersion + 1, byte(frameEvent), 0}))
+	if _, err := readFrame(r); !errors.Is(err, ErrFrameVersion) {
+		t.FailNow()
+	}
+	// announced payload too large
+	r = bufio.NewReader(bytes.NewReader([]byte{frameVersion, byte(frameEvent), 0xff, 0xff, 0xff, 0xff, 0x0f}))
+	if _, err := readFrame(r); !errors.Is(err, ErrFrameTooLarge) {
+		t.FailNow()
+	}
+	// truncated payload
+	r = bufio.NewReader(bytes.NewReader([]byte{frameVersion, byte(frameEvent), 4, 'a'}))
+	if _, err := readFrame(r); err == nil {
+		t.FailNow()
+	}
+}
@@ -2,24 +2,80 @@ package net
 
 import (
 	"bufio"
-	"bytes"
-	"errors"
 	"fmt"
 	"strings"
-	"sync"
+	"time"
 
 	"github.com/libp2p/go-libp2p-core/network"
 	"github.com/libp2p/go-libp2p-core/peer"
+	"github.com/libp2p/go-libp2p-core/protocol"
 	peerstore "github.com/libp2p/go-libp2p-peerstore"
+	"github.com/mikelsr/bspl"
 	"github.com/mikelsr/nahs/events"
 	"github.com/multiformats/go-multiaddr"
 )
 
 // setStreamHandler sets the stream handlers of the node peer
 func (n *Node) setStreamHandlers() {
-	n.host.SetStreamHandler(protocolDiscoveryID, n.discoveryHandler)
-	n.host.SetStreamHandler(protocolEchoID, n.echoHandler)
-	n.host.SetStreamHandler(protocolEventID, n.eventHandler)
+	n.host.SetStreamHandler(protocolDiscoveryID, n.streamHandler("discovery", n.discoveryHandler))
+	n.host.SetStreamHandler(protocolEchoID, n.streamHandler("echo", n.echoHandler))
+	n.host.SetStreamHandler(protocolEventID, n.streamHandler("event", n.eventHandler))
+	// keep handling streams from nodes using the delimited exchange
+	n.host.SetStreamHandler(legacyProtocolDiscoveryID, n.streamHandler("legacy discovery", n.legacyDiscoveryHandler))
+	n.host.SetStreamHandler(legacyProtocolEchoID, n.streamHandler("legacy echo", n.legacyEchoHandler))
+	n.host.SetStreamHandler(legacyProtocolEventID, n.streamHandler("legacy event", n.legacyEventHandler))
+}
+
+// removeStreamHandlers removes the stream handlers set by
+// setStreamHandlers so no new streams are accepted
+func (n *Node) removeStreamHandlers() {
+	for _, id := range []protocol.ID{
+		protocolDiscoveryID, protocolEchoID, protocolEventID,
+		legacyProtocolDiscoveryID, legacyProtocolEchoID, legacyProtocolEventID,
+	} {
+		n.host.RemoveStreamHandler(id)
+	}
+}
+
+// streamHandler wraps a handler so it is tracked until it finishes,
+// recovers from errors if the stream is closed unexpectedly and
+// closes the stream once it is handled
+func (n *Node) streamHandler(name string, handler network.StreamHandler) network.StreamHandler {
+	return func(stream network.Stream) {
+		if !n.acquireHandler() {
+			stream.Reset()
+			return
+		}
+		defer n.releaseHandler()
+		defer func() {
+			if r := recover(); r != nil {
+				logger.Errorf("Recovered from error in protocol %s: %s", name, r)
+			}
+			stream.Close()
+		}()
+		logger.Debugf("Opened new %s stream", name)
+		n.metrics.streamOpened(stream.Protocol(), directionInbound)
+		n.addRemotePeer(stream)
+		handler(stream)
+	}
+}
+
+// acquireHandler registers an in-flight stream handler. It returns
+// false if the node is closed, in which case the stream must not be
+// handled. Every successful call must be followed by releaseHandler.
+func (n *Node) acquireHandler() bool {
+	n.closeMutex.Lock()
+	defer n.closeMutex.Unlock()
+	if n.closed {
+		return false
+	}
+	n.handlers.Add(1)
+	return true
+}
+
+// releaseHandler marks an in-flight stream handler as finished
+func (n *Node) releaseHandler() {
+	n.handlers.Done()
 }
 
 func (n *Node) addRemotePeer(stream network.Stream) {
@@ -28,62 +84,103 @@ func (n *Node) addRemotePeer(stream network.Stream) {
 	remoteAddrs := []multiaddr.Multiaddr{stream.Conn().RemoteMultiaddr()}
 	logger.Debugf("Added address '%s' for peer '%s'", remoteAddrs[0], remotePeer.Pretty())
 	n.host.Peerstore().AddAddrs(remotePeer, remoteAddrs, peerstore.PermanentAddrTTL)
+	n.contacts.touch(remotePeer)
 }
 
 // discoveryHandler exchanges the BSPL protocols of the
 // services offered by each node
 func (n *Node) discoveryHandler(stream network.Stream) {
-	// defer recovery function in case the stream is closed
-	// unexpectedly
-	defer func() {
-		if r := recover(); r != nil {
-			logger.Errorf("Recovered from error in protocol exchange: %s", r)
-		}
-		stream.Close()
-	}()
-
-	logger.Debug("Opened new BSPL protocol discovery stream")
-	n.addRemotePeer(stream)
+	if err := n.exchangeProtocols(stream, stream.Conn().RemotePeer()); err != nil {
+		logger.Errorf("Error in protocol exchange: %s", err)
+	}
+}
 
-	var wg sync.WaitGroup
+// exchangeProtocols runs discoveryReadData and discoveryWriteData
+// concurrently over a stream. If either fails the stream is reset
+// so the other one doesn't block, and the first error is returned.
+func (n *Node) exchangeProtocols(stream network.Stream, sender peer.ID) error {
 	rw := bufio.NewReadWriter(bufio.NewReader(stream), bufio.NewWriter(stream))
+	errs := make(chan error, 2)
+	go func() {
+		errs <- n.discoveryReadData(rw.Reader, sender)
+	}()
+	go func() {
+		errs <- n.discoveryWriteData(rw.Writer)
+	}()
+	var err error
+	for i := 0; i < 2; i++ {
+		if e := <-errs; e != nil && err == nil {
+			err = e
+			stream.Reset()
+		}
+	}
+	return err
+}
 
-	wg.Add(2)
-	go n.discoveryReadData(rw, &wg, stream.Conn().RemotePeer())
-	go n.discoveryWriteData(rw, &wg)
-	wg.Wait()
+// discoveryReadData parses the BSPL protocols transmitted by the other
+// peer, one per frame until the end of the list
+func (n *Node) discoveryReadData(r *bufio.Reader, sender peer.ID) error {
+	services := make([]Service, 0)
+	for {
+		f, err := readFrame(r)
+		if err != nil {
+			return fmt.Errorf("Error while reading protocol exchange: %s", err)
+		}
+		if f.Type == frameProtocolsEnd {
+			break
+		}
+		if f.Type != frameProtocol {
+			return fmt.Errorf("Unexpected frame of type %d in protocol exchange", f.Type)
+		}
+		protocol, roles, err := unwrapProtocol(f.Payload)
+		if err != nil {
+			return fmt.Errorf("Error while parsing exchanged protocol: %s", err)
+		}
+		services = append(services, Service{
+			Protocol: protocol,
+			Roles:    roles,
+		})
+	}
+	n.addDiscoveredServices(sender, services)
+	return nil
 }
 
-// discoveryReadData parses the BSPL protocols transmitted by the other peer
-func (n *Node) discoveryReadData(rw *bufio.ReadWriter, wg *sync.WaitGroup, sender peer.ID) {
-	// defer recovery function in case the stream is closed
-	// unexpectedly
-	defer wg.Done()
-	b, err := rw.ReadBytes(exchangeEnd)
+// discoveryWriteData transmits the BSPL protocols of this node to the
+// other, one per frame followed by a frame marking the end of the list
+func (n *Node) discoveryWriteData(w *bufio.Writer) error {
+	payloads, err := n.wrappedProtocols()
 	if err != nil {
-		logger.Errorf("Error while reading protocol exchange: %s", err)
-		panic(err)
+		return err
+	}
+	for _, payload := range payloads {
+		if err := writeFrame(w, frameProtocol, payload); err != nil {
+			return fmt.Errorf("Error while writing protocol exchange: %s", err)
+		}
+	}
+	if err := writeFrame(w, frameProtocolsEnd, nil); err != nil {
+		return fmt.Errorf("Error while writing protocol exchange: %s", err)
 	}
-	bProtos := bytes.Split(b, []byte{exchangeSeparator})
-	services := make([]Service, len(bProtos))
+	if err := w.Flush(); err != nil {
+		return fmt.Errorf("Error while writing protocol exchange: %s", err)
+	}
+	return nil
+}
 
-	// if  the protocol list was empty, return
-	if len(bProtos) == 1 && len(bProtos[0]) == 1 && bytes.Equal(bProtos[0], []byte{exchangeEnd}) {
+// addDiscoveredServices sets the services discovered in a protocol
+// exchange as the services of the contact, replacing the known ones
+func (n *Node) addDiscoveredServices(sender peer.ID, services []Service) {
+	n.contacts.markExchanged(sender)
+	if len(services) == 0 {
 		logger.Debug("No new protocols discovered")
+		n.contacts.touch(sender)
 		return
 	}
-	// parse protocols
-	for i, bp := range bProtos {
-		protocol, roles, err := unwrapProtocol(bp[:len(bp)-1])
-		if err != nil {
-			panic(err)
-		}
-		services[i] = Service{
-			Protocol: protocol,
-			Roles:    roles,
-		}
+	// the exchange carries every service of the sender
+	servs := make(Services, len(services))
+	for _, s := range services {
+		servs[s.Protocol.Key()] = s
 	}
-	n.AddServices(sender, services...)
+	n.contacts.Put(sender, servs)
 	var sb strings.Builder
 	sb.WriteString("Discovered protocols: \n")
 	for _, s := range services {
@@ -92,111 +189,150 @@ func (n *Node) discoveryReadData(rw *bufio.ReadWriter, wg *sync.WaitGroup, sende
 	logger.Debug(sb.String())
 }
 
-// discoveryWriteData transmits the BSPL protocols of this node to the other
-func (n *Node) discoveryWriteData(rw *bufio.ReadWriter, wg *sync.WaitGroup) {
-	defer wg.Done()
-	k := len(n.protocols)
+// wrappedProtocols wraps the protocols offered by this node
+// to be transmitted
+func (n *Node) wrappedProtocols() ([][]byte, error) {
+	n.protocolsMutex.RLock()
+	defer n.protocolsMutex.RUnlock()
+	payloads := make([][]byte, len(n.protocols))
 	for i, p := range n.protocols {
 		roles := n.roles[p.Key()]
 		if len(roles) == 0 {
-			panic(fmt.Errorf("No defined roles for protocol '%s'", p.Key()))
-		}
-		payload := wrapProtocol(p, roles...)
-		rw.Write(payload)
-		if i != k-1 {
-			rw.WriteByte(exchangeSeparator)
+			return nil, fmt.Errorf("No defined roles for protocol '%s'", p.Key())
 		}
+		payloads[i] = wrapProtocol(p, roles...)
 	}
-	rw.WriteByte(exchangeEnd)
-
-	if err := rw.Flush(); err != nil {
-		logger.Debugf("Error while writing protocol exchange: %s", err)
-		panic(err)
-	}
+	return payloads, nil
 }
 
 // echoHandler reads a message and writes the same as
 // a response
 func (n *Node) echoHandler(stream network.Stream) {
-	// defer recovery function in case the stream is closed
-	// unexpectedly
-	defer func() {
-		if r := recover(); r != nil {
-			logger.Debugf("Recovered from error in protocol echo: %s", r)
-		}
-		stream.Close()
-
-	}()
-	logger.Debug("Opened new Echo stream")
-	n.addRemotePeer(stream)
-
 	rw := bufio.NewReadWriter(bufio.NewReader(stream), bufio.NewWriter(stream))
-	response := echoHandlerRead(rw)
-	echoHandlerWrite(rw, response)
-
-	stream.Close()
-}
-
-// echoHandlerRead and echoHandlerWrite are very short but useful for testing
-func echoHandlerRead(rw *bufio.ReadWriter) []byte {
-	b, err := rw.ReadBytes(exchangeEnd)
+	msg, err := readFrameOfType(rw.Reader, frameEcho)
 	if err != nil {
-		logger.Debug("Error while reading echo message: %s", err)
-		panic(err)
+		logger.Debugf("Error while reading echo message: %s", err)
+		return
+	}
+	logger.Debugf("Received echo message: %s", string(msg))
+	if err := writeFrame(rw.Writer, frameEcho, msg); err != nil {
+		logger.Debugf("Error while writing echo message: %s", err)
+		return
 	}
-	logger.Debugf("Received echo message: %s", string(b))
-	return b
-}
-
-// echoHandlerWrite and echoHandlerRead are very short but useful for testing
-func echoHandlerWrite(rw *bufio.ReadWriter, response []byte) {
-	logger.Debugf("Send echo message: %s", string(response))
-	rw.Write(response)
 	if err := rw.Flush(); err != nil {
 		logger.Debugf("Error while writing echo message: %s", err)
-		panic(err)
 	}
 }
 
+// eventHandler reads an event, runs it and writes the result
+// as a response
 func (n *Node) eventHandler(stream network.Stream) {
-	// defer recovery function in case the stream is closed
-	// unexpectedly
-	defer func() {
-		if r := recover(); r != nil {
-			logger.Errorf("Recovered from error in protocol event: %s", r)
-			stream.Close()
-		}
-	}()
-	logger.Debug("Opened new Echo stream")
-	n.addRemotePeer(stream)
 	rw := bufio.NewReadWriter(bufio.NewReader(stream), bufio.NewWriter(stream))
-	err := n.runEvent(rw, stream.Conn().RemotePeer())
+	b, err := readFrameOfType(rw.Reader, frameEvent)
+	if err != nil {
+		logger.Errorf("Error while reading event message: %s", err)
+		return
+	}
+	// the ID is only used if runEvent fails to extract it
+	id, _ := events.ID(b)
+	err = n.handleEvent(b)
 	if err != nil {
 		logger.Error(err)
-		rw.Write(exchangeErr)
-	} else {
-		rw.Write(exchangeOk)
 	}
-	rw.WriteByte(exchangeEnd)
+	response, err := marshalEventResponse(makeEventResponse(id, err))
+	if err != nil {
+		logger.Errorf("Error while marshalling event response: %s", err)
+		return
+	}
+	if err := writeFrame(rw.Writer, frameEventResponse, response); err != nil {
+		logger.Errorf("Error while writing event response: %s", err)
+		return
+	}
 	if err := rw.Flush(); err != nil {
-		logger.Debugf("Error while writing echo message: %s", err)
-		panic(err)
+		logger.Errorf("Error while writing event response: %s", err)
+		return
 	}
+	// keep the handler in-flight until the response is read
+	// so it is delivered even if the node is being closed
+	awaitClose(stream)
+}
+
+// awaitClose closes a stream for writing and waits until the remote
+// peer closes it too or streamCloseTimeout passes
+func awaitClose(stream network.Stream) {
+	stream.Close()
+	stream.SetReadDeadline(time.Now().Add(streamCloseTimeout))
+	stream.Read(make([]byte, 1))
 }
 
-func (n *Node) runEvent(rw *bufio.ReadWriter, sender peer.ID) error {
-	// read marshalled event
-	b, err := rw.ReadBytes(exchangeEnd)
-	b = b[:len(b)-1]
+// handleEvent verifies the signature of a marshalled event and runs it
+// on behalf of its signer. Unsigned events are rejected whatever the
+// protocol they were received with.
+func (n *Node) handleEvent(b []byte) (err error) {
+	t, _ := events.Type(b)
+	n.metrics.eventReceived(t)
+	defer func() {
+		if err != nil {
+			n.metrics.eventRejected(t, err)
+		}
+	}()
+	sender, err := n.eventSender(b)
 	if err != nil {
-		logger.Error("Error while reading event message: %s", err)
 		return err
 	}
+	// events that can't be identified are rejected by runEvent
+	id, err := events.ID(b)
+	if err != nil {
+		return n.runEvent(b, sender)
+	}
+	instanceKey, _ := events.GetInstanceKey(b)
+	sequence, _ := events.Sequence(b)
+	err = n.order.deliver(sender, id, instanceKey, sequence, func() error {
+		protocolKey := n.eventProtocolKey(b)
+		err := n.runEvent(b, sender)
+		if err == nil {
+			n.metrics.eventApplied(t)
+		}
+		n.notify(b, sender, protocolKey, err)
+		return err
+	})
+	if err == nil && t == events.TypeDropEvent {
+		n.order.dropped(instanceKey)
+	}
+	return err
+}
+
+// eventSender returns the signer of a marshalled event once its
+// signature is verified against the public key of the signer
+func (n *Node) eventSender(b []byte) (peer.ID, error) {
+	id, _ := events.ID(b)
+	badSignature := func(reason string) error {
+		return ErrBadSignature{ErrHandleEvent{ID: id, Status: StatusBadSignature, Reason: reason}}
+	}
+	signer, err := events.Signer(b)
+	if err != nil {
+		return "", badSignature(err.Error())
+	}
+	pk := n.host.Peerstore().PubKey(signer)
+	if pk == nil {
+		return "", badSignature("Unknown public key of signer")
+	}
+	if err := events.Verify(b, pk); err != nil {
+		return "", badSignature(err.Error())
+	}
+	return signer, nil
+}
+
+// runEvent checks that the sender of a marshalled event is allowed
+// to send it and runs it with the reasoner of the node. The errors
+// returned wrap an ErrHandleEvent describing why the event failed.
+func (n *Node) runEvent(b []byte, sender peer.ID) error {
 	// extract event ID
 	id, err := events.ID(b)
 	if err != nil {
 		logger.Error(err)
-		return ErrHandleEvent{ID: "-", Reason: "failed to extract ID"}
+		return ErrInvalidEvent{ErrHandleEvent{ID: "-", Status: StatusInvalidEvent,
+			Reason: "Failed to extract ID"}}
 	}
 	// err was already check with events.ID
 	t, _ := events.Type(b)
@@ -204,49 +340,113 @@ func (n *Node) runEvent(rw *bufio.ReadWriter, sender peer.ID) error {
 	instanceKey, err := events.GetInstanceKey(b)
 	if err != nil {
 		logger.Error(err)
-		return ErrHandleEvent{ID: id, Reason: "Could not extract instance key"}
+		return ErrInvalidEvent{ErrHandleEvent{ID: id, Status: StatusInvalidEvent,
+			Reason: "Could not extract instance key"}}
 	}
-	// check if the instance has a peer assigned
-	s, found := n.OpenInstances[instanceKey]
+	// check if the instance is open
+	ownership, found := n.openInstances.Get(instanceKey)
 	logger.Debugf("Run event '%s' for node '%s'", t, sender)
+	unauthorized := ErrUnauthorized{ErrHandleEvent{ID: id, Status: StatusUnauthorized,
+		Reason: "Unauthorized"}}
 	switch t {
-	case events.TypeDropEvent, events.TypeUpdateEvent:
-		// drop and update require an existing instance
+	case events.TypeDropEvent, events.TypeUpdateEvent, events.TypeActionEvent:
+		// drop, update and action require an existing instance
 		if !found {
-			return ErrHandleEvent{ID: id, Reason: "Instance not found"}
+			return ErrInstanceNotFound{ErrHandleEvent{ID: id, Status: StatusInstanceNotFound,
+				Reason: "Instance not found"}}
 		}
-		// senders must coincide
-		if s.String() != sender.String() {
-			return ErrHandleEvent{ID: id, Reason: "Unauthorized"}
+		// senders must participate in the instance
+		if !ownership.IsParticipant(sender) {
+			return unauthorized
+		}
+		if t == events.TypeUpdateEvent {
+			event, err := events.Unmarshal(b)
+			if err != nil {
+				return ErrInvalidEvent{ErrHandleEvent{ID: id, Status: StatusInvalidEvent,
+					Reason: err.Error()}}
+			}
+			if !n.playsUpdate(ownership, sender, event.(events.UpdateEvent).Instance()) {
+				return unauthorized
+			}
+		}
+		if t == events.TypeActionEvent {
+			if err := n.validateAction(id, b, ownership, sender); err != nil {
+				return err
+			}
 		}
 		// remove event from OpenInstances
 		if t == events.TypeDropEvent {
-			delete(n.OpenInstances, instanceKey)
+			n.openInstances.Delete(instanceKey)
 		}
 	case events.TypeNewEvent:
-		// new requires the instance to no exist
-		if found {
-			return ErrHandleEvent{ID: id, Reason: "Instance already existed"}
+		event, err := events.Unmarshal(b)
+		if err != nil {
+			return ErrInvalidEvent{ErrHandleEvent{ID: id, Status: StatusInvalidEvent,
+				Reason: err.Error()}}
+		}
+		// if the roles of the instance are bound to peers
+		// the creator must be one of them
+		ownership := makeOwnership(sender, event.(events.NewEvent).Instance().Roles())
+		if !ownership.IsParticipant(sender) {
+			return unauthorized
+		}
+		// new requires the instance to no exist, asign
+		// ownership to instance otherwise
+		if !n.openInstances.PutIfAbsent(instanceKey, ownership) {
+			return ErrInstanceExists{ErrHandleEvent{ID: id, Status: StatusInstanceExists,
+				Reason: "Instance already existed"}}
 		}
-
-		// asign sender to instance
-		n.OpenInstances[instanceKey] = sender
 	}
 	// run event
-	return events.RunEvent(n.reasoner, b)
+	if err := events.RunEvent(n.reasoner, b); err != nil {
+		return ErrEventRejected{ErrHandleEvent{ID: id, Status: StatusRejected,
+			Reason: "Rejected by reasoner", ReasonerError: err.Error()}}
+	}
+	return nil
 }
 
-func readEventResponse(rw *bufio.ReadWriter) (bool, error) {
-	b, err := rw.ReadBytes(exchangeEnd)
+// playsUpdate checks that the sender of an update plays a role that
+// may have performed the action that led to the new version of the
+// instance. Updates whose action can't be identified, because the
+// reasoner doesn't hold the instance, they bind the parameters of
+// several actions or they change bound values, are not allowed.
+func (n *Node) playsUpdate(ownership Ownership, sender peer.ID, next bspl.Instance) bool {
+	current, found := n.reasoner.GetInstance(next.Key())
+	if !found {
+		return false
+	}
+	roles := performingRoles(current, next)
+	for _, role := range roles {
+		if ownership.Plays(sender, role) {
+			return true
+		}
+	}
+	return false
+}
+
+// validateAction checks an ActionEvent against the definition of
+// its action and the current version of the instance, and that
+// the sender plays the role the action is sent from.
+func (n *Node) validateAction(id string, b []byte, ownership Ownership, sender peer.ID) error {
+	event, err := events.Unmarshal(b)
 	if err != nil {
-		return false, err
+		return ErrInvalidEvent{ErrHandleEvent{ID: id, Status: StatusInvalidEvent,
+			Reason: err.Error()}}
+	}
+	ae := event.(events.ActionEvent)
+	current, found := n.reasoner.GetInstance(ae.InstanceKey())
+	if !found {
+		return ErrInstanceNotFound{ErrHandleEvent{ID: id, Status: StatusInstanceNotFound,
+			Reason: "Instance not found by reasoner"}}
 	}
-	if len(b) < len(exchangeOk) || len(b) < len(exchangeErr) {
-		return false, errors.New("Response is too short")
+	action, err := ae.Validate(current)
+	if err != nil {
+		return ErrInvalidEvent{ErrHandleEvent{ID: id, Status: StatusInvalidEvent,
+			Reason: err.Error()}}
 	}
-	// response is "ok"
-	if bytes.Equal(b[:len(b)-1], exchangeOk) {
-		return true, nil
+	if !ownership.Plays(sender, action.From) {
+		return ErrUnauthorized{ErrHandleEvent{ID: id, Status: StatusUnauthorized,
+			Reason: fmt.Sprintf("Sender does not play role '%s'", action.From)}}
 	}
-	return false, nil
+	return nil
 }
@@ -3,10 +3,12 @@ package net
 import (
 	"bufio"
 	"bytes"
+	"context"
+	"errors"
 	"fmt"
-	"sync"
 	"testing"
 
+	"github.com/libp2p/go-libp2p-core/peer"
 	"github.com/mikelsr/bspl"
 	imp "github.com/mikelsr/bspl/implementation"
 	"github.com/mikelsr/bspl/proto"
@@ -29,17 +31,15 @@ func TestDiscoveryHandler(t *testing.T) {
 		t.Log(err)
 		t.FailNow()
 	}
-	rw := bufio.NewReadWriter(bufio.NewReader(stream), bufio.NewWriter(stream))
-	// Spawn and wait for RW routines
-	var wg sync.WaitGroup
-	wg.Add(2)
-	go n1.discoveryReadData(rw, &wg, n2.ID())
-	go n1.discoveryWriteData(rw, &wg)
-	wg.Wait()
-	if len(n1.Contacts) != 1 {
+	// Run and wait for RW routines
+	if err := n1.exchangeProtocols(stream, n2.ID()); err != nil {
+		t.Log(err)
+		t.FailNow()
+	}
+	if n1.Contacts().Len() != 1 {
 		t.FailNow()
 	}
-	for id, services := range n1.Contacts {
+	for id, services := range n1.Contacts().Snapshot() {
 		if id != n2.ID() {
 			t.FailNow()
 		}
@@ -61,8 +61,9 @@ func TestEchoHandler(t *testing.T) {
 	n := testNodes(2)
 	n1, n2 := n[0], n[1]
 
-	// call testEcho and fail tests if it errs
-	msg := []byte("Howdily doodily")
+	// call testEcho and fail tests if it errs, delimiters of
+	// the legacy exchange must be echoed too
+	msg := []byte("Howdily|doodily%")
 	if err := testEcho(n1, n2, msg); err != nil {
 		n1.cancel()
 		n2.cancel()
@@ -79,18 +80,20 @@ func testEcho(n1, n2 *Node, msg []byte) error {
 		return nil
 	}
 	rw := bufio.NewReadWriter(bufio.NewReader(stream), bufio.NewWriter(stream))
-	message := append(msg, exchangeEnd)
-	rw.Write(message)
+	if err := writeFrame(rw.Writer, frameEcho, msg); err != nil {
+		return err
+	}
 	if err := rw.Flush(); err != nil {
 		return err
 	}
-	// Launch RW functions on order
 	// Test will fail if it times out
-	response := echoHandlerRead(rw)
-	if !bytes.Equal(response, message) {
-		return fmt.Errorf("Echo expected '%s' but got '%s'", message, response)
+	response, err := readFrameOfType(rw.Reader, frameEcho)
+	if err != nil {
+		return err
+	}
+	if !bytes.Equal(response, msg) {
+		return fmt.Errorf("Echo expected '%s' but got '%s'", msg, response)
 	}
-	echoHandlerWrite(rw, response)
 	return nil
 }
 
@@ -98,6 +101,8 @@ func TestEventHandler(t *testing.T) {
 	testEventHandlerDropEvent(t)
 	testEventHandlerNewEvent(t)
 	testEventHandlerUpdateEvent(t)
+	testEventHandlerActionEvent(t)
+	testEventHandlerSignature(t)
 }
 
 func testEventHandlerDropEvent(t *testing.T) {
@@ -111,25 +116,31 @@ func testEventHandlerDropEvent(t *testing.T) {
 	// create event
 	instance := testInstance()
 
-	n2.OpenInstances[instance.Key()] = n1.ID()
+	n2.OpenInstances().Put(instance.Key(), Ownership{Creator: n1.ID()})
 
 	a := events.MakeDropEvent(instance.Key(), "_")
 	// send data from unauthorized node
-	ok, err := n3.SendEvent(n2.ID(), a)
-	if err != nil {
+	err := n3.SendEvent(context.Background(), n2.ID(), a)
+	var errUnauthorized ErrUnauthorized
+	if !errors.As(err, &errUnauthorized) || errUnauthorized.ID != a.ID() {
 		t.Log(err)
 		t.FailNow()
 	}
-	if ok {
+	// send data from authorized node
+	if err := n1.SendEvent(context.Background(), n2.ID(), a); err != nil {
+		t.Log(err)
 		t.FailNow()
 	}
-	// send data from authorized node
-	ok, err = n1.SendEvent(n2.ID(), a)
-	if err != nil {
+	// retrying the event doesn't run it again
+	if err := n1.SendEvent(context.Background(), n2.ID(), a); err != nil {
 		t.Log(err)
 		t.FailNow()
 	}
-	if !ok {
+	// the instance was dropped
+	err = n1.SendEvent(context.Background(), n2.ID(), events.MakeDropEvent(instance.Key(), "_"))
+	var errNotFound ErrInstanceNotFound
+	if !errors.As(err, &errNotFound) {
+		t.Log(err)
 		t.FailNow()
 	}
 }
@@ -147,56 +158,227 @@ func testEventHandlerNewEvent(t *testing.T) {
 	ni := events.MakeNewEvent(instance)
 
 	// create new instance
-	ok, err := n1.SendEvent(n2.ID(), ni)
-	if err != nil {
+	if err := n1.SendEvent(context.Background(), n2.ID(), ni); err != nil {
 		t.Log(err)
 		t.FailNow()
 	}
-	if !ok {
+	// retrying the event doesn't run it again
+	if err := n1.SendEvent(context.Background(), n2.ID(), ni); err != nil {
+		t.Log(err)
 		t.FailNow()
 	}
 	// create the same instance again
-	_, err = n1.SendEvent(n2.ID(), ni)
-	if err != nil {
+	err := n1.SendEvent(context.Background(), n2.ID(), events.MakeNewEvent(instance))
+	var errExists ErrInstanceExists
+	if !errors.As(err, &errExists) {
+		t.Log(err)
+		t.FailNow()
+	}
+	// the underlying ErrHandleEvent carries the status
+	var errHandle ErrHandleEvent
+	if !errors.As(err, &errHandle) || errHandle.Status != StatusInstanceExists {
+		t.FailNow()
+	}
+
+	// the reasoner only accepts testInstance
+	other := imp.NewInstance(testProtocol(), instance.Roles())
+	other.SetValue("ID", "other")
+	err = n1.SendEvent(context.Background(), n2.ID(), events.MakeNewEvent(other))
+	var errRejected ErrEventRejected
+	if !errors.As(err, &errRejected) || errRejected.ReasonerError != errMock.Error() {
 		t.Log(err)
 		t.FailNow()
 	}
 }
 
 func testEventHandlerUpdateEvent(t *testing.T) {
-	m := mockReasoner{}
+	r := requestedReasoner{}
 	n := testNodes(2)
 	for _, node := range n {
-		node.reasoner = m
+		node.reasoner = r
+		defer node.Close(context.Background())
 	}
 	n1, n2 := n[0], n[1]
 
-	// create event
 	p := testProtocol()
-	roles := bspl.Roles{
-		proto.Role("Buyer"):  "B",
-		proto.Role("Seller"): "S",
-	}
-	// i1 is the empty instance
-	i1 := imp.NewInstance(p, roles)
-	i1.SetValue("ID", "testID")
-	i1.SetValue("item", "testItem")
-	// i2 is the same as i1 but after running "Request"
-	i2 := imp.NewInstance(p, roles)
-	i2.SetValue("ID", "testID")
-	i2.SetValue("item", "testItem")
-	i2.SetValue("pritce", "testPrice")
-
-	n2.OpenInstances[i1.Key()] = n1.ID()
-
-	updateEvent := events.MakeUpdateEvent(i2)
-
-	// send message to correct instance
-	ok, err := n1.SendEvent(n2.ID(), updateEvent)
-	if err != nil {
+	roles := testInstance().Roles()
+	requested, _ := r.GetInstance(testInstance().Key())
+	n2.OpenInstances().Put(requested.Key(), Ownership{Creator: n1.ID()})
+	var errUnauthorized ErrUnauthorized
+
+	// updates that change bound values are not performed by any action
+	rewritten := imp.NewInstance(p, roles)
+	rewritten.SetValue("ID", "X")
+	rewritten.SetValue("item", "Y")
+	rewritten.SetValue("price", "X")
+	if err := n1.SendEvent(context.Background(), n2.ID(), events.MakeUpdateEvent(rewritten)); !errors.As(err, &errUnauthorized) {
+		t.Log(err)
+		t.FailNow()
+	}
+	// neither are updates of instances unknown by the reasoner
+	unknown := imp.NewInstance(p, roles)
+	unknown.SetValue("ID", "Y")
+	unknown.SetValue("item", "X")
+	unknown.SetValue("price", "X")
+	n2.OpenInstances().Put(unknown.Key(), Ownership{Creator: n1.ID()})
+	if err := n1.SendEvent(context.Background(), n2.ID(), events.MakeUpdateEvent(unknown)); !errors.As(err, &errUnauthorized) {
+		t.Log(err)
+		t.FailNow()
+	}
+	// updates performing "Offer" are allowed
+	if err := n1.SendEvent(context.Background(), n2.ID(), events.MakeUpdateEvent(testInstance())); err != nil {
+		t.Log(err)
 		t.FailNow()
 	}
-	if !ok {
+}
+
+// identifiedReasoner holds the test instance with only its ID bound,
+// before running either "Request" or "Offer"
+type identifiedReasoner struct {
+	mockReasoner
+}
+
+func (r identifiedReasoner) GetInstance(instanceKey string) (bspl.Instance, bool) {
+	if instanceKey != testInstance().Key() {
+		return nil, false
+	}
+	i := imp.NewInstance(testProtocol(), testInstance().Roles())
+	i.SetValue("ID", "X")
+	return i, true
+}
+
+func TestEventHandler_multiActionUpdate(t *testing.T) {
+	n := testNodes(2)
+	for _, node := range n {
+		node.reasoner = identifiedReasoner{}
+		defer node.Close(context.Background())
+	}
+	n1, n2 := n[0], n[1]
+	n2.OpenInstances().Put(testInstance().Key(), Ownership{Creator: n1.ID()})
+
+	// the update binds the parameters of both "Request" and "Offer",
+	// so the action and the role performing it can't be identified
+	var errUnauthorized ErrUnauthorized
+	err := n1.SendEvent(context.Background(), n2.ID(), events.MakeUpdateEvent(testInstance()))
+	if !errors.As(err, &errUnauthorized) {
+		t.Log(err)
+		t.FailNow()
+	}
+}
+
+// requestedReasoner holds the test instance before running "Offer"
+type requestedReasoner struct {
+	mockReasoner
+}
+
+func (r requestedReasoner) GetInstance(instanceKey string) (bspl.Instance, bool) {
+	if instanceKey != testInstance().Key() {
+		return nil, false
+	}
+	i := imp.NewInstance(testProtocol(), testInstance().Roles())
+	i.SetValue("ID", "X")
+	i.SetValue("item", "X")
+	return i, true
+}
+
+func testEventHandlerActionEvent(t *testing.T) {
+	r := requestedReasoner{}
+	n := testNodes(3)
+	for _, node := range n {
+		node.reasoner = r
+		defer node.Close(context.Background())
+	}
+	n1, n2, n3 := n[0], n[1], n[2]
+	requested, _ := r.GetInstance(testInstance().Key())
+	offer := events.MakeActionEvent(requested, "Offer", bspl.Values{"price": "X"})
+
+	// the action is only run on open instances
+	var errNotFound ErrInstanceNotFound
+	if err := n1.SendEvent(context.Background(), n2.ID(), offer); !errors.As(err, &errNotFound) {
+		t.Log(err)
+		t.FailNow()
+	}
+	n2.OpenInstances().Put(requested.Key(), Ownership{Creator: n1.ID()})
+
+	// by participants
+	var errUnauthorized ErrUnauthorized
+	if err := n3.SendEvent(context.Background(), n2.ID(), offer); !errors.As(err, &errUnauthorized) {
+		t.Log(err)
+		t.FailNow()
+	}
+	// that send valid actions
+	var errInvalid ErrInvalidEvent
+	invalid := events.MakeActionEvent(requested, "Offer", bspl.Values{"item": "Y"})
+	if err := n1.SendEvent(context.Background(), n2.ID(), invalid); !errors.As(err, &errInvalid) {
+		t.Log(err)
+		t.FailNow()
+	}
+	if err := n1.SendEvent(context.Background(), n2.ID(), offer); err != nil {
+		t.Log(err)
+		t.FailNow()
+	}
+
+	// if roles are bound, only the peer bound to the From
+	// role of the action may run it
+	n2.OpenInstances().Put(requested.Key(), Ownership{Creator: n1.ID(),
+		Roles: map[bspl.Role]peer.ID{
+			proto.Role("Buyer"):  n1.ID(),
+			proto.Role("Seller"): n3.ID(),
+		}})
+	offer = events.MakeActionEvent(requested, "Offer", bspl.Values{"price": "X"})
+	if err := n3.SendEvent(context.Background(), n2.ID(), offer); !errors.As(err, &errUnauthorized) {
+		t.Log(err)
+		t.FailNow()
+	}
+	if err := n1.SendEvent(context.Background(), n2.ID(), offer); err != nil {
+		t.Log(err)
+		t.FailNow()
+	}
+}
+
+func testEventHandlerSignature(t *testing.T) {
+	m := mockReasoner{}
+	n := testNodes(3)
+	for _, node := range n {
+		node.reasoner = m
+	}
+	n1, n2, n3 := n[0], n[1], n[2]
+
+	event := events.MakeNewEvent(testInstance())
+	data, _ := event.Marshal()
+
+	// unsigned events are rejected
+	err := n1.sendEventData(context.Background(), n2.ID(), event.ID(), data)
+	var errSignature ErrBadSignature
+	if !errors.As(err, &errSignature) {
+		t.Log(err)
+		t.FailNow()
+	}
+
+	// events signed by n3 and forwarded by n1 are run on behalf of n3
+	// once n2 knows the key of n3
+	signed, _ := events.Sign(data, *testKeys[2])
+	err = n1.sendEventData(context.Background(), n2.ID(), event.ID(), signed)
+	if !errors.As(err, &errSignature) {
+		t.Log(err)
+		t.FailNow()
+	}
+	n2.Peerstore().AddPubKey(n3.ID(), (*testKeys[2]).GetPublic())
+	if err := n1.sendEventData(context.Background(), n2.ID(), event.ID(), signed); err != nil {
+		t.Log(err)
+		t.FailNow()
+	}
+	if o, _ := n2.OpenInstances().Get(event.InstanceKey()); o.Creator != n3.ID() {
+		t.FailNow()
+	}
+
+	// tampered events are rejected
+	drop, _ := events.MakeDropEvent(event.InstanceKey(), "_").Marshal()
+	drop, _ = events.Sign(drop, *testKeys[2])
+	tampered := bytes.Replace(drop, []byte(`"instance_key":"`), []byte(`"instance_key":"X`), 1)
+	err = n1.sendEventData(context.Background(), n2.ID(), event.ID(), tampered)
+	if !errors.As(err, &errSignature) {
+		t.Log(err)
 		t.FailNow()
 	}
 }
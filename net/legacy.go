@@ -0,0 +1,176 @@
+package net
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+
+	"github.com/libp2p/go-libp2p-core/network"
+	"github.com/libp2p/go-libp2p-core/peer"
+)
+
+// The legacy exchange delimits messages with exchangeEnd and protocol
+// lists with exchangeSeparator. It is kept so nodes using the 0.0.1
+// protocol IDs can still communicate with this one, but messages
+// containing the delimiters can't be exchanged reliably.
+
+// legacyDiscoveryHandler exchanges the BSPL protocols of the
+// services offered by each node
+func (n *Node) legacyDiscoveryHandler(stream network.Stream) {
+	if err := n.legacyExchangeProtocols(stream, stream.Conn().RemotePeer()); err != nil {
+		logger.Errorf("Error in protocol exchange: %s", err)
+	}
+}
+
+// legacyExchangeProtocols runs legacyDiscoveryReadData and
+// legacyDiscoveryWriteData concurrently over a stream. If either
+// fails the stream is reset so the other one doesn't block, and
+// the first error is returned.
+func (n *Node) legacyExchangeProtocols(stream network.Stream, sender peer.ID) error {
+	rw := bufio.NewReadWriter(bufio.NewReader(stream), bufio.NewWriter(stream))
+	errs := make(chan error, 2)
+	go func() {
+		errs <- n.legacyDiscoveryReadData(rw, sender)
+	}()
+	go func() {
+		errs <- n.legacyDiscoveryWriteData(rw)
+	}()
+	var err error
+	for i := 0; i < 2; i++ {
+		if e := <-errs; e != nil && err == nil {
+			err = e
+			stream.Reset()
+		}
+	}
+	return err
+}
+
+// legacyDiscoveryReadData parses the BSPL protocols transmitted by the other peer
+func (n *Node) legacyDiscoveryReadData(rw *bufio.ReadWriter, sender peer.ID) error {
+	b, err := rw.ReadBytes(exchangeEnd)
+	if err != nil {
+		return fmt.Errorf("Error while reading protocol exchange: %s", err)
+	}
+	b = b[:len(b)-1]
+	// if  the protocol list was empty, return
+	if len(b) == 0 {
+		logger.Debug("No new protocols discovered")
+		return nil
+	}
+	bProtos := bytes.Split(b, []byte{exchangeSeparator})
+	services := make([]Service, len(bProtos))
+	// parse protocols
+	for i, bp := range bProtos {
+		protocol, roles, err := unwrapProtocol(bp)
+		if err != nil {
+			return fmt.Errorf("Error while parsing exchanged protocol: %s", err)
+		}
+		services[i] = Service{
+			Protocol: protocol,
+			Roles:    roles,
+		}
+	}
+	n.addDiscoveredServices(sender, services)
+	return nil
+}
+
+// legacyDiscoveryWriteData transmits the BSPL protocols of this node to the other
+func (n *Node) legacyDiscoveryWriteData(rw *bufio.ReadWriter) error {
+	payloads, err := n.wrappedProtocols()
+	if err != nil {
+		return err
+	}
+	rw.Write(bytes.Join(payloads, []byte{exchangeSeparator}))
+	rw.WriteByte(exchangeEnd)
+
+	if err := rw.Flush(); err != nil {
+		return fmt.Errorf("Error while writing protocol exchange: %s", err)
+	}
+	return nil
+}
+
+// legacyEchoHandler reads a message and writes the same as
+// a response
+func (n *Node) legacyEchoHandler(stream network.Stream) {
+	rw := bufio.NewReadWriter(bufio.NewReader(stream), bufio.NewWriter(stream))
+	response, err := legacyEchoRead(rw)
+	if err != nil {
+		logger.Debug(err)
+		return
+	}
+	if err := legacyEchoWrite(rw, response); err != nil {
+		logger.Debug(err)
+	}
+}
+
+// legacyEchoRead and legacyEchoWrite are very short but useful for testing
+func legacyEchoRead(rw *bufio.ReadWriter) ([]byte, error) {
+	b, err := rw.ReadBytes(exchangeEnd)
+	if err != nil {
+		return nil, fmt.Errorf("Error while reading echo message: %s", err)
+	}
+	logger.Debugf("Received echo message: %s", string(b))
+	return b, nil
+}
+
+// legacyEchoWrite and legacyEchoRead are very short but useful for testing
+func legacyEchoWrite(rw *bufio.ReadWriter, response []byte) error {
+	logger.Debugf("Send echo message: %s", string(response))
+	rw.Write(response)
+	if err := rw.Flush(); err != nil {
+		return fmt.Errorf("Error while writing echo message: %s", err)
+	}
+	return nil
+}
+
+// legacyEventHandler reads an event, runs it and writes the result
+// as a response
+func (n *Node) legacyEventHandler(stream network.Stream) {
+	rw := bufio.NewReadWriter(bufio.NewReader(stream), bufio.NewWriter(stream))
+	b, err := rw.ReadBytes(exchangeEnd)
+	if err != nil {
+		logger.Errorf("Error while reading event message: %s", err)
+		return
+	}
+	b = b[:len(b)-1]
+	// events must be signed even in the legacy exchange, otherwise
+	// any peer could skip the verification by downgrading
+	if err := n.handleEvent(b); err != nil {
+		logger.Error(err)
+		rw.Write(exchangeErr)
+	} else {
+		rw.Write(exchangeOk)
+	}
+	rw.WriteByte(exchangeEnd)
+	if err := rw.Flush(); err != nil {
+		logger.Errorf("Error while writing event response: %s", err)
+	}
+}
+
+// legacySendEvent writes a marshalled event to a legacy event
+// stream and reads the response
+func legacySendEvent(stream network.Stream, data []byte) (bool, error) {
+	rw := bufio.NewReadWriter(bufio.NewReader(stream), bufio.NewWriter(stream))
+	rw.Write(data)
+	rw.WriteByte(exchangeEnd)
+	if err := rw.Flush(); err != nil {
+		return false, err
+	}
+	return legacyReadEventResponse(rw)
+}
+
+func legacyReadEventResponse(rw *bufio.ReadWriter) (bool, error) {
+	b, err := rw.ReadBytes(exchangeEnd)
+	if err != nil {
+		return false, err
+	}
+	if len(b) < len(exchangeOk) || len(b) < len(exchangeErr) {
+		return false, errors.New("Response is too short")
+	}
+	// response is "ok"
+	if bytes.Equal(b[:len(b)-1], exchangeOk) {
+		return true, nil
+	}
+	return false, nil
+}
@@ -0,0 +1,100 @@
+package net
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"testing"
+
+	"github.com/mikelsr/nahs/events"
+)
+
+func TestLegacyDiscoveryHandler(t *testing.T) {
+	n := testNodes(2)
+	n1, n2 := n[0], n[1]
+
+	n1.AddProtocol(tp1, tp1.Roles...)
+	n2.AddProtocol(tp1, tp1.Roles...)
+	n2.AddProtocol(tp2, tp2.Roles...)
+
+	stream, err := n1.host.NewStream(n1.context, n2.ID(), legacyProtocolDiscoveryID)
+	if err != nil {
+		t.Log(err)
+		t.FailNow()
+	}
+	if err := n1.legacyExchangeProtocols(stream, n2.ID()); err != nil {
+		t.Log(err)
+		t.FailNow()
+	}
+	services, found := n1.Contacts().Get(n2.ID())
+	if !found || len(services) != 2 {
+		t.FailNow()
+	}
+	if _, found := services[tp2.Key()]; !found {
+		t.FailNow()
+	}
+}
+
+func TestLegacyEchoHandler(t *testing.T) {
+	n := testNodes(2)
+	n1, n2 := n[0], n[1]
+
+	stream, err := n1.host.NewStream(n1.context, n2.ID(), legacyProtocolEchoID)
+	if err != nil {
+		t.Log(err)
+		t.FailNow()
+	}
+	rw := bufio.NewReadWriter(bufio.NewReader(stream), bufio.NewWriter(stream))
+	message := append([]byte("Howdily doodily"), exchangeEnd)
+	rw.Write(message)
+	if err := rw.Flush(); err != nil {
+		t.Log(err)
+		t.FailNow()
+	}
+	response, err := legacyEchoRead(rw)
+	if err != nil {
+		t.Log(err)
+		t.FailNow()
+	}
+	if !bytes.Equal(response, message) {
+		t.Log(fmt.Errorf("Echo expected '%s' but got '%s'", message, response))
+		t.FailNow()
+	}
+}
+
+func TestLegacyEventHandler(t *testing.T) {
+	n := testNodes(2)
+	n1, n2 := n[0], n[1]
+	n2.reasoner = mockReasoner{}
+
+	// unsigned events are rejected in the legacy exchange too
+	data, _ := events.MakeNewEvent(testInstance()).Marshal()
+	stream, err := n1.host.NewStream(n1.context, n2.ID(), legacyProtocolEventID)
+	if err != nil {
+		t.Log(err)
+		t.FailNow()
+	}
+	ok, err := legacySendEvent(stream, data)
+	if err != nil || ok {
+		t.Log(err)
+		t.FailNow()
+	}
+	if _, found := n2.OpenInstances().Get(testInstance().Key()); found {
+		t.FailNow()
+	}
+
+	signed, _ := events.Sign(data, n1.host.Peerstore().PrivKey(n1.ID()))
+	stream, err = n1.host.NewStream(n1.context, n2.ID(), legacyProtocolEventID)
+	if err != nil {
+		t.Log(err)
+		t.FailNow()
+	}
+	ok, err = legacySendEvent(stream, signed)
+	if err != nil || !ok {
+		t.Log(err)
+		t.FailNow()
+	}
+	if _, found := n2.OpenInstances().Get(testInstance().Key()); !found {
+		t.FailNow()
+	}
+}
@@ -0,0 +1,183 @@
+package net
+
+import (
+	"errors"
+	"time"
+
+	"github.com/libp2p/go-libp2p-core/protocol"
+	"github.com/mikelsr/nahs/events"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+const (
+	// metricsNamespace prefixes the names of the metrics
+	metricsNamespace = "nahs"
+	// metricsPeerLabel identifies the node in the metrics, so
+	// several nodes can share a registry
+	metricsPeerLabel = "peer"
+
+	directionInbound  = "inbound"
+	directionOutbound = "outbound"
+
+	resultOK    = "ok"
+	resultError = "error"
+)
+
+// metrics of a Node, registered with WithMetrics. A nil *metrics
+// records nothing.
+type metrics struct {
+	registerer prometheus.Registerer
+	collectors []prometheus.Collector
+
+	streams   *prometheus.CounterVec
+	received  *prometheus.CounterVec
+	applied   *prometheus.CounterVec
+	rejected  *prometheus.CounterVec
+	sent      *prometheus.HistogramVec
+	discovery prometheus.Histogram
+}
+
+// newMetrics creates the metrics of a Node and registers them
+func newMetrics(n *Node, registerer prometheus.Registerer) (*metrics, error) {
+	labels := prometheus.Labels{metricsPeerLabel: n.ID().Pretty()}
+	m := &metrics{
+		registerer: registerer,
+		streams: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace:   metricsNamespace,
+			Name:        "streams_opened_total",
+			Help:        "Streams opened by protocol ID and direction.",
+			ConstLabels: labels,
+		}, []string{"protocol", "direction"}),
+		received: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace:   metricsNamespace,
+			Name:        "events_received_total",
+			Help:        "Events received by type, including retried ones.",
+			ConstLabels: labels,
+		}, []string{"type"}),
+		applied: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace:   metricsNamespace,
+			Name:        "events_applied_total",
+			Help:        "Events run by the reasoner by type.",
+			ConstLabels: labels,
+		}, []string{"type"}),
+		rejected: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace:   metricsNamespace,
+			Name:        "events_rejected_total",
+			Help:        "Events rejected by type and reason.",
+			ConstLabels: labels,
+		}, []string{"type", "reason"}),
+		sent: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace:   metricsNamespace,
+			Name:        "send_event_duration_seconds",
+			Help:        "Time taken to deliver an event, retries included, by type and result.",
+			ConstLabels: labels,
+			Buckets:     prometheus.DefBuckets,
+		}, []string{"type", "result"}),
+		discovery: prometheus.NewHistogram(prometheus.HistogramOpts{
+			Namespace:   metricsNamespace,
+			Name:        "discovery_duration_seconds",
+			Help:        "Time taken by each discovery pass.",
+			ConstLabels: labels,
+			Buckets:     prometheus.ExponentialBuckets(0.1, 2, 12),
+		}),
+	}
+	contacts := prometheus.NewGaugeFunc(prometheus.GaugeOpts{
+		Namespace:   metricsNamespace,
+		Name:        "contacts",
+		Help:        "Contacts known by the node.",
+		ConstLabels: labels,
+	}, func() float64 { return float64(n.contacts.Len()) })
+	instances := prometheus.NewGaugeFunc(prometheus.GaugeOpts{
+		Namespace:   metricsNamespace,
+		Name:        "open_instances",
+		Help:        "Instances open in the node.",
+		ConstLabels: labels,
+	}, func() float64 { return float64(n.openInstances.Len()) })
+
+	for _, c := range []prometheus.Collector{m.streams, m.received, m.applied, m.rejected, m.sent, m.discovery, contacts, instances} {
+		if err := registerer.Register(c); err != nil {
+			m.unregister()
+			return nil, err
+		}
+		m.collectors = append(m.collectors, c)
+	}
+	return m, nil
+}
+
+// unregister removes the metrics from the registry
+func (m *metrics) unregister() {
+	if m == nil {
+		return
+	}
+	for _, c := range m.collectors {
+		m.registerer.Unregister(c)
+	}
+	m.collectors = nil
+}
+
+// streamOpened counts a stream of a protocol
+func (m *metrics) streamOpened(id protocol.ID, direction string) {
+	if m == nil {
+		return
+	}
+	m.streams.WithLabelValues(string(id), direction).Inc()
+}
+
+// eventReceived counts an event received from another node
+func (m *metrics) eventReceived(t events.EventType) {
+	if m == nil {
+		return
+	}
+	m.received.WithLabelValues(eventTypeLabel(t)).Inc()
+}
+
+// eventApplied counts an event run by the reasoner
+func (m *metrics) eventApplied(t events.EventType) {
+	if m == nil {
+		return
+	}
+	m.applied.WithLabelValues(eventTypeLabel(t)).Inc()
+}
+
+// eventRejected counts an event that was not run, labelled with the
+// status of its ErrHandleEvent
+func (m *metrics) eventRejected(t events.EventType, err error) {
+	if m == nil {
+		return
+	}
+	reason := StatusUnknown
+	var e ErrHandleEvent
+	if errors.As(err, &e) {
+		reason = e.Status
+	}
+	m.rejected.WithLabelValues(eventTypeLabel(t), reason.String()).Inc()
+}
+
+// eventSent observes the time taken to deliver an event
+func (m *metrics) eventSent(t events.EventType, d time.Duration, err error) {
+	if m == nil {
+		return
+	}
+	result := resultOK
+	if err != nil {
+		result = resultError
+	}
+	m.sent.WithLabelValues(eventTypeLabel(t), result).Observe(d.Seconds())
+}
+
+// discoveryPass observes the time taken by a discovery pass
+func (m *metrics) discoveryPass(d time.Duration) {
+	if m == nil {
+		return
+	}
+	m.discovery.Observe(d.Seconds())
+}
+
+// eventTypeLabel is the label of an event type, "unknown" for
+// events whose type could not be read
+func eventTypeLabel(t events.EventType) string {
+	if t == "" {
+		return "unknown"
+	}
+	return string(t)
+}
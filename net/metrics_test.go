@@ -0,0 +1,99 @@
+package net
+
+import (
+	"context"
+	"testing"
+
+	"github.com/libp2p/go-libp2p-core/peerstore"
+	"github.com/mikelsr/nahs/events"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+// gauge returns the value of a gauge of a node in a registry
+func gauge(t *testing.T, registry *prometheus.Registry, name string, n *Node) float64 {
+	families, err := registry.Gather()
+	if err != nil {
+		t.Log(err)
+		t.FailNow()
+	}
+	for _, family := range families {
+		if family.GetName() != name {
+			continue
+		}
+		for _, m := range family.GetMetric() {
+			for _, label := range m.GetLabel() {
+				if label.GetName() == metricsPeerLabel && label.GetValue() == n.ID().Pretty() {
+					return m.GetGauge().GetValue()
+				}
+			}
+		}
+	}
+	t.Log("Metric not found: ", name)
+	t.FailNow()
+	return 0
+}
+
+func TestWithMetrics(t *testing.T) {
+	if _, err := applyOptions(WithMetrics(nil)); err == nil {
+		t.FailNow()
+	}
+	// both nodes share a registry
+	registry := prometheus.NewRegistry()
+	n := make([]*Node, 2)
+	for i := range n {
+		node, err := nodeFromPrivKey(*testKeys[i], WithMetrics(registry))
+		if err != nil {
+			t.Log(err)
+			t.FailNow()
+		}
+		node.reasoner = mockReasoner{}
+		defer node.Close(context.Background())
+		n[i] = node
+	}
+	n1, n2 := n[0], n[1]
+	n1.host.Peerstore().AddAddrs(n2.ID(), n2.Addrs(), peerstore.PermanentAddrTTL)
+	// a node can't be registered twice
+	if _, err := newMetrics(n1, registry); err == nil {
+		t.FailNow()
+	}
+
+	instance := testInstance()
+	if err := n1.SendEvent(context.Background(), n2.ID(), events.MakeNewEvent(instance)); err != nil {
+		t.Log(err)
+		t.FailNow()
+	}
+	// creating the instance again is rejected
+	n1.SendEvent(context.Background(), n2.ID(), events.MakeNewEvent(instance))
+
+	newType := string(events.TypeNewEvent)
+	if testutil.ToFloat64(n2.metrics.received.WithLabelValues(newType)) != 2 ||
+		testutil.ToFloat64(n2.metrics.applied.WithLabelValues(newType)) != 1 ||
+		testutil.ToFloat64(n2.metrics.rejected.WithLabelValues(newType, StatusInstanceExists.String())) != 1 {
+		t.FailNow()
+	}
+	if testutil.ToFloat64(n2.metrics.streams.WithLabelValues(string(protocolEventID), directionInbound)) != 2 ||
+		testutil.ToFloat64(n1.metrics.streams.WithLabelValues(string(protocolEventID), directionOutbound)) != 2 {
+		t.FailNow()
+	}
+	// one delivery succeeded and the other failed
+	if testutil.CollectAndCount(n1.metrics.sent) != 2 {
+		t.FailNow()
+	}
+	n2.Contacts().Put(n1.ID(), Services{})
+	if gauge(t, registry, "nahs_open_instances", n2) != 1 || gauge(t, registry, "nahs_contacts", n2) != 1 ||
+		gauge(t, registry, "nahs_open_instances", n1) != 0 {
+		t.FailNow()
+	}
+	n1.discoverOnce()
+	if testutil.CollectAndCount(n1.metrics.discovery) != 1 {
+		t.FailNow()
+	}
+
+	// the metrics are unregistered when the node is closed
+	n1.Close(context.Background())
+	if _, err := newMetrics(n1, registry); err != nil {
+		t.Log(err)
+		t.FailNow()
+	}
+}
@@ -3,6 +3,9 @@ package net
 import (
 	"bufio"
 	"context"
+	"errors"
+	"sync"
+	"time"
 
 	"github.com/mikelsr/bspl"
 	"github.com/mikelsr/nahs/events"
@@ -11,71 +14,157 @@ import (
 	"github.com/libp2p/go-libp2p"
 	"github.com/libp2p/go-libp2p-core/crypto"
 	"github.com/libp2p/go-libp2p-core/host"
+	"github.com/libp2p/go-libp2p-core/network"
 	"github.com/libp2p/go-libp2p-core/peer"
 	"github.com/libp2p/go-libp2p-core/peerstore"
 	discovery "github.com/libp2p/go-libp2p-discovery"
 	dht "github.com/libp2p/go-libp2p-kad-dht"
+	mdns "github.com/libp2p/go-libp2p/p2p/discovery"
 )
 
 // Node represents a single NaHS peer.
 type Node struct {
 	// libp2p Host
 	host host.Host
-	// Contacts of the Node
-	Contacts Contacts
+	// contacts of the Node
+	contacts *ContactRegistry
 	// context of the node and the host
 	context context.Context
 	// host cancelation function
 	cancel context.CancelFunc
+	// closed is set when Close is called so no more
+	// streams are handled
+	closed bool
+	// closeMutex protects closed and the handler WaitGroup
+	closeMutex sync.Mutex
+	// handlers keeps track of in-flight stream handlers
+	handlers sync.WaitGroup
+	// background keeps track of the goroutines of the node
+	background sync.WaitGroup
+	// stopAdvertising cancels the rendezvous advertising
+	// started by Announce
+	stopAdvertising context.CancelFunc
+	// advertising is the context of the current advertising
+	advertising context.Context
+	// advertised are the namespaces of the current advertising
+	advertised map[string]bool
+	// advertiseMutex protects stopAdvertising, advertising
+	// and advertised
+	advertiseMutex sync.Mutex
+	// discoveryInterval is how often the discovery loop runs
+	discoveryInterval time.Duration
+	// contactTTL is how long contacts are kept without being seen
+	contactTTL time.Duration
+	// discoveryMode establishes how other nodes are found
+	discoveryMode DiscoveryMode
+	// bootstrapPeers are connected to to join the DHT
+	bootstrapPeers []multiaddr.Multiaddr
+	// bootstrapTimeout limits the connection to the bootstrap peers
+	bootstrapTimeout time.Duration
+	// dhtMode establishes if the DHT answers queries
+	dhtMode dht.ModeOpt
+	// rendezvous is the namespace the node is announced in
+	rendezvous string
+	// mdns searches for nodes in the local network, may be nil
+	mdns mdns.Service
 	// dht table with information about network peers
 	dht *dht.IpfsDHT
-	// OpenInstances maps instance keys to peer.IDs to
-	// verify that the node sending the event is the one
-	// who created it
-	OpenInstances map[string]peer.ID
+	// openInstances maps instance keys to their Ownership
+	// to verify that the node sending an event is allowed
+	// to run it
+	openInstances *InstanceRegistry
+	// order numbers sent events and orders and deduplicates
+	// received ones
+	order *eventOrder
+	// outbox queues undelivered events, may be nil
+	outbox Outbox
+	// subscriptions to the events run by the node
+	subscriptions *subscriptions
+	// outboxKick wakes up the outbox when a peer connects
+	outboxKick chan peer.ID
+	// retry establishes how sending events is retried
+	retry retryPolicy
+	// sendTimeout limits each try to send an event
+	sendTimeout time.Duration
 	// routing for rendezvous
 	routing *discovery.RoutingDiscovery
+	// protocolsMutex protects protocols and roles
+	protocolsMutex sync.RWMutex
 	// protocols this node offers
 	protocols []bspl.Protocol
 	// resoner to handle BSPL logic
 	reasoner bspl.Reasoner
+	// store the state of the node is persisted to, may be nil
+	store Store
 	// roles this node plays for each protocol mapped to
 	// protocol keys
 	roles map[string][]bspl.Role
+	// metrics of the node, may be nil
+	metrics *metrics
 }
 
-// NewNode is the default constructor for Node.
-func NewNode(reasoner bspl.Reasoner, options ...libp2p.Option) *Node {
-	n := newNode(options...)
+// NewNode is the default constructor for Node. The node
+// keeps discovering peers in the background until closed.
+func NewNode(reasoner bspl.Reasoner, options ...Option) (*Node, error) {
+	n, err := newNode(options...)
+	if err != nil {
+		return nil, err
+	}
 	n.reasoner = reasoner
 	// Connect the node to the bootstrap nodes to discover other peers
-	n.configDiscovery()
-	return n
+	if err := n.configDiscovery(); err != nil {
+		n.Close(context.Background())
+		return nil, err
+	}
+	n.startDiscovery()
+	return n, nil
 }
 
 // LocalNode returns a new node without settings up the discovery protocols.
 // This is useful for testing without connection or wasting time.
-func LocalNode(reasoner bspl.Reasoner, options ...libp2p.Option) *Node {
-	n := newNode(options...)
+func LocalNode(reasoner bspl.Reasoner, options ...Option) (*Node, error) {
+	n, err := newNode(options...)
+	if err != nil {
+		return nil, err
+	}
 	n.reasoner = reasoner
-	return n
+	return n, nil
 }
 
 // newNode is a constructor that requires no bspl.Reasoner
 // and doesn't connect to the  bootstrap nodes used only inside
 // this package.
-func newNode(options ...libp2p.Option) *Node {
+func newNode(options ...Option) (*Node, error) {
+	o, err := applyOptions(options...)
+	if err != nil {
+		return nil, err
+	}
 	n := new(Node)
 
-	n.Contacts = make(Contacts)
-	n.OpenInstances = make(map[string]peer.ID)
+	n.store = o.store
+	if n.contacts, err = newContactRegistry(n.store); err != nil {
+		return nil, err
+	}
+	if n.openInstances, err = newInstanceRegistry(n.store); err != nil {
+		return nil, err
+	}
+	n.order = newEventOrder(o.dedupWindow, o.reorderTimeout, o.reorderBuffer, o.sequenceTTL)
+	n.retry, n.sendTimeout, n.outbox = o.retry, o.sendTimeout, o.outbox
+	n.subscriptions = newSubscriptions()
+	n.discoveryInterval, n.contactTTL = o.discoveryInterval, o.contactTTL
+	n.discoveryMode = o.discoveryMode
+	n.bootstrapPeers, n.bootstrapTimeout = o.bootstrapPeers, o.bootstrapTimeout
+	n.dhtMode, n.rendezvous = o.dhtMode, o.rendezvous
 	n.protocols = make([]bspl.Protocol, 0)
 	n.roles = make(map[string][]bspl.Role)
+	if err := n.loadProtocols(); err != nil {
+		return nil, err
+	}
 
 	n.context, n.cancel = context.WithCancel(context.Background())
 	// Contatenate options parameter to default options
-	opt := append(options, []libp2p.Option{
-		libp2p.ListenAddrStrings(listenAddrs...),
+	opt := append(o.libp2p, []libp2p.Option{
+		libp2p.ListenAddrStrings(o.listenAddrs...),
 		// support any other default transports (TCP)
 		libp2p.DefaultTransports,
 		// Let this host use relays and advertise itself on relays
@@ -84,51 +173,90 @@ func newNode(options ...libp2p.Option) *Node {
 		libp2p.NATPortMap(),
 	}...)
 
-	// opt = append(opt, libp2p.PrivateNetwork(loadPrivNetPSK()))
-
 	h, err := libp2p.New(n.context, opt...)
 	if err != nil {
-		panic(err)
+		n.cancel()
+		return nil, err
 	}
 	n.host = h
+	if o.registerer != nil {
+		if n.metrics, err = newMetrics(n, o.registerer); err != nil {
+			n.cancel()
+			h.Close()
+			return nil, err
+		}
+	}
 
 	// set stream handlers
 	n.setStreamHandlers()
+	if n.outbox != nil {
+		n.startOutbox(o.outboxInterval)
+	}
 
 	logger.Debugf("Created node with ID '%s'.", h.ID())
-	return n
+	return n, nil
 }
 
 // NodeFromPrivKey is a newNode wrapper to create a new Node with the specified
 // private key. Additional options may be provided.
-func NodeFromPrivKey(reasoner bspl.Reasoner, sk crypto.PrivKey, options ...libp2p.Option) *Node {
-	n := nodeFromPrivKey(sk, options...)
+func NodeFromPrivKey(reasoner bspl.Reasoner, sk crypto.PrivKey, options ...Option) (*Node, error) {
+	n, err := nodeFromPrivKey(sk, options...)
+	if err != nil {
+		return nil, err
+	}
 	n.reasoner = reasoner
-	n.configDiscovery()
-	return n
+	if err := n.configDiscovery(); err != nil {
+		n.Close(context.Background())
+		return nil, err
+	}
+	n.startDiscovery()
+	return n, nil
 }
 
 // nodeFromPrivKey is the same as NodeFromPrivKey but requires
 // no bspl.Reasoner and doesn't connect to the  bootstrap nodes
 // used only inside this package.
-func nodeFromPrivKey(sk crypto.PrivKey, options ...libp2p.Option) *Node {
-	return newNode(append(options, libp2p.Identity(sk))...)
+func nodeFromPrivKey(sk crypto.PrivKey, options ...Option) (*Node, error) {
+	return newNode(append(options, WithLibp2pOptions(libp2p.Identity(sk)))...)
+}
+
+// loadProtocols loads the protocols offered by the node from its store
+func (n *Node) loadProtocols() error {
+	if n.store == nil {
+		return nil
+	}
+	services, err := n.store.LoadProtocols()
+	if err != nil {
+		return err
+	}
+	for _, s := range services {
+		n.protocols = append(n.protocols, s.Protocol)
+		n.roles[s.Protocol.Key()] = s.Roles
+	}
+	return nil
 }
 
 // ID of the libp2p host of the Node
-func (n Node) ID() peer.ID {
+func (n *Node) ID() peer.ID {
 	return n.host.ID()
 }
 
 // Addrs returns the multiaddr of the libp2p host of the Node
-func (n Node) Addrs() []multiaddr.Multiaddr {
+func (n *Node) Addrs() []multiaddr.Multiaddr {
 	return n.host.Addrs()
 }
 
 // AddProtocol adds a protocol to the node and establishes what roles
 // the node plays in that protocol. If the protocol was already added,
-// the roles that weren't already established are added.
+// the roles that weren't already established are added. Once the node
+// is announced, each role is advertised in its service namespace.
 func (n *Node) AddProtocol(p bspl.Protocol, roles ...bspl.Role) {
+	defer n.advertiseServices(p.Key(), roles...)
+	// the next discovery pass tells every peer found about it
+	defer n.contacts.forgetExchanges()
+	n.protocolsMutex.Lock()
+	defer n.protocolsMutex.Unlock()
+	defer n.persistProtocol(p)
 	playedRoles, found := n.roles[p.Key()]
 	if !found {
 		n.protocols = append(n.protocols, p)
@@ -149,6 +277,98 @@ func (n *Node) AddProtocol(p bspl.Protocol, roles ...bspl.Role) {
 	}
 }
 
+// Services returns the protocols the node offers and the roles it
+// plays in each of them
+func (n *Node) Services() []Service {
+	n.protocolsMutex.RLock()
+	defer n.protocolsMutex.RUnlock()
+	services := make([]Service, len(n.protocols))
+	for i, p := range n.protocols {
+		roles := make([]bspl.Role, len(n.roles[p.Key()]))
+		copy(roles, n.roles[p.Key()])
+		services[i] = Service{Protocol: p, Roles: roles}
+	}
+	return services
+}
+
+// persistProtocol writes a protocol and the roles the node plays in
+// it to the store. protocolsMutex must be locked.
+func (n *Node) persistProtocol(p bspl.Protocol) {
+	if n.store == nil {
+		return
+	}
+	if err := n.store.PutProtocol(Service{Protocol: p, Roles: n.roles[p.Key()]}); err != nil {
+		logger.Errorf("Could not write protocol '%s' to store: %s", p.Key(), err)
+	}
+}
+
+// Close stops the Node. New streams are refused, in-flight stream
+// handlers are given until ctx is done to finish, the rendezvous
+// advertising, the mDNS service and the outbox are stopped, the DHT
+// and the host are closed and the event subscriptions are cancelled.
+// The errors found in the process are returned as an ErrClose.
+func (n *Node) Close(ctx context.Context) error {
+	n.closeMutex.Lock()
+	if n.closed {
+		n.closeMutex.Unlock()
+		return nil
+	}
+	n.closed = true
+	n.closeMutex.Unlock()
+
+	errs := make(ErrClose, 0)
+	// stop accepting new streams
+	n.removeStreamHandlers()
+
+	// wait for in-flight handlers
+	done := make(chan struct{})
+	go func() {
+		n.handlers.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+		logger.Debug("All stream handlers finished")
+	case <-ctx.Done():
+		logger.Warning("Closing node before stream handlers finished")
+		errs = append(errs, ctx.Err())
+	}
+
+	n.advertiseMutex.Lock()
+	if n.stopAdvertising != nil {
+		n.stopAdvertising()
+	}
+	n.advertiseMutex.Unlock()
+	if n.mdns != nil {
+		if err := n.mdns.Close(); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	if n.dht != nil {
+		if err := n.dht.Close(); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	if err := n.host.Close(); err != nil {
+		errs = append(errs, err)
+	}
+	n.cancel()
+	n.background.Wait()
+	n.subscriptions.removeAll()
+	n.metrics.unregister()
+
+	logger.Debugf("Closed node with ID '%s'.", n.ID())
+	if len(errs) > 0 {
+		return errs
+	}
+	return nil
+}
+
+// Contacts returns the registry of the contacts of the Node
+func (n *Node) Contacts() *ContactRegistry {
+	return n.contacts
+}
+
 // ExportKey returns the marshaled private key of the Node
 func (n *Node) ExportKey() []byte {
 	prv := n.host.Network().Peerstore().PrivKey(n.host.ID())
@@ -156,28 +376,16 @@ func (n *Node) ExportKey() []byte {
 	return b
 }
 
-// FindContact finds a contact that offers a service and plays a role
+// FindContact finds the contacts that offer a service and play a role
 // in that service. A slice of the peer.ID of those contacts is returned.
 func (n *Node) FindContact(protocolKey string, role bspl.Role) []peer.ID {
-	ids := make([]peer.ID, 0)
-	for contact, services := range n.Contacts {
-		service, found := services[protocolKey]
-		if !found {
-			continue
-		}
-		matchesRole := false
-		for _, role := range service.Roles {
-			if role == role {
-				matchesRole = true
-				break
-			}
-		}
-		if matchesRole {
-			ids = append(ids, contact)
-			break
-		}
-	}
-	return ids
+	return n.FindContacts(ContactQuery{ProtocolKey: protocolKey, Roles: []bspl.Role{role}})
+}
+
+// OpenInstances returns the registry of the instances opened
+// by other peers and their Ownership
+func (n *Node) OpenInstances() *InstanceRegistry {
+	return n.openInstances
 }
 
 // Peerstore returns the Peerstore of the Host of the Node
@@ -187,28 +395,151 @@ func (n *Node) Peerstore() peerstore.Peerstore {
 
 // Reasoner returns the reasoner of the Node
 func (n *Node) Reasoner() bspl.Reasoner {
-	return n.Reasoner()
+	return n.reasoner
 }
 
 // SendEvent sends an events.Event to the target node.
-// If the node is unreachable, the address is not known
-// or some error occurs the error is returned. If the
-// event was invalid, false is returned. If it was registered
-// correctly, true is returned.
-func (n *Node) SendEvent(target peer.ID, event events.Event) (bool, error) {
+// The event is numbered after the previous events sent to
+// the target on the same instance so it is run in order,
+// and signed with the private key of the node.
+// Transient failures, such as an unreachable target, are
+// retried until ctx is done. If they persist and the node
+// has an Outbox the event is queued to be redelivered and
+// ErrEventQueued is returned. If the event was not run by
+// the target, the returned error wraps an ErrHandleEvent
+// and can be matched with the error type of its status,
+// e.g. ErrUnauthorized.
+func (n *Node) SendEvent(ctx context.Context, target peer.ID, event events.Event) error {
 	data, err := event.Marshal()
 	if err != nil {
-		return false, err
+		return err
+	}
+	sequence := n.order.nextSequence(target, event.InstanceKey())
+	if data, err = n.prepareEvent(data, sequence); err != nil {
+		n.order.releaseSequence(target, event.InstanceKey(), sequence)
+		return err
 	}
-	stream, err := n.host.NewStream(n.context, target, protocolEventID)
+	start := time.Now()
+	err = n.deliverEvent(ctx, target, event.ID(), data)
+	n.metrics.eventSent(event.Type(), time.Since(start), err)
+	if err != nil && n.outbox != nil && isTransient(err) {
+		if qerr := n.queueEvent(target, event.ID(), data); qerr != nil {
+			logger.Errorf("Could not queue event '%s': %s", event.ID(), qerr)
+		} else {
+			err = ErrEventQueued{Err: err}
+		}
+	}
+	n.settleSequence(target, event, sequence, err)
+	return err
+}
+
+// prepareEvent numbers a marshalled event and signs it
+func (n *Node) prepareEvent(data []byte, sequence uint64) ([]byte, error) {
+	data, err := events.SetSequence(data, sequence)
 	if err != nil {
-		return false, err
+		return nil, err
+	}
+	return events.Sign(data, n.host.Peerstore().PrivKey(n.ID()))
+}
+
+// settleSequence releases the sequence number of an event the target
+// didn't receive, and forgets the sequence numbers of an instance
+// once its DropEvent was received or queued
+func (n *Node) settleSequence(target peer.ID, event events.Event, sequence uint64, err error) {
+	if !receivedSequence(err) {
+		n.order.releaseSequence(target, event.InstanceKey(), sequence)
+		return
+	}
+	if event.Type() == events.TypeDropEvent {
+		n.order.forgetSent(target, event.InstanceKey())
+	}
+}
+
+// receivedSequence checks if the target consumed the sequence number
+// of an event given the result of sending it: the event was run,
+// queued or ordered and then refused
+func receivedSequence(err error) bool {
+	if err == nil || errors.As(err, new(ErrEventQueued)) {
+		return true
+	}
+	var e ErrHandleEvent
+	if !errors.As(err, &e) {
+		return false
+	}
+	// these are refused before the event is ordered
+	return e.Status != StatusBadSignature && e.Status != StatusOutOfOrder
+}
+
+// deliverEvent sends a marshalled event to the target node, retrying
+// transient failures. Each try is limited by the send timeout.
+func (n *Node) deliverEvent(ctx context.Context, target peer.ID, id string, data []byte) error {
+	return n.retry.do(ctx, func() error {
+		ctx, cancel := context.WithTimeout(ctx, n.sendTimeout)
+		defer cancel()
+		return n.sendEventData(ctx, target, id, data)
+	})
+}
+
+// sendEventData sends a marshalled event to the target node. The
+// stream is reset if ctx is done before the response arrives.
+func (n *Node) sendEventData(ctx context.Context, target peer.ID, id string, data []byte) error {
+	stream, err := n.host.NewStream(ctx, target, protocolEventID, legacyProtocolEventID)
+	if err != nil {
+		return err
+	}
+	n.metrics.streamOpened(stream.Protocol(), directionOutbound)
+	defer stream.Close()
+	deadline, hasDeadline := ctx.Deadline()
+	if hasDeadline {
+		stream.SetDeadline(deadline)
+	}
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		select {
+		case <-ctx.Done():
+			stream.Reset()
+		case <-done:
+		}
+	}()
+	err = n.exchangeEvent(stream, id, data)
+	if err != nil && ctx.Err() != nil {
+		return ctx.Err()
+	}
+	// the stream deadline may be reached before ctx is done
+	if err != nil && hasDeadline && !time.Now().Before(deadline) {
+		return context.DeadlineExceeded
+	}
+	return err
+}
+
+// exchangeEvent writes a marshalled event to a stream and reads
+// the response
+func (n *Node) exchangeEvent(stream network.Stream, id string, data []byte) error {
+	if stream.Protocol() == legacyProtocolEventID {
+		ok, err := legacySendEvent(stream, data)
+		if err != nil {
+			return err
+		}
+		if !ok {
+			return ErrHandleEvent{ID: id, Status: StatusUnknown}
+		}
+		return nil
 	}
 	rw := bufio.NewReadWriter(bufio.NewReader(stream), bufio.NewWriter(stream))
-	rw.Write(data)
-	rw.WriteByte(exchangeEnd)
+	if err := writeFrame(rw.Writer, frameEvent, data); err != nil {
+		return err
+	}
 	if err := rw.Flush(); err != nil {
-		return false, err
+		return err
+	}
+	b, err := readFrameOfType(rw.Reader, frameEventResponse)
+	if err != nil {
+		return err
+	}
+	response, err := unmarshalEventResponse(b)
+	if err != nil {
+		return err
 	}
-	return readEventResponse(rw)
+	return response.Err()
 }
@@ -1,11 +1,16 @@
 package net
 
 import (
+	"context"
+	"errors"
+	"strings"
 	"testing"
+	"time"
 
 	log "github.com/ipfs/go-log"
 	"github.com/libp2p/go-libp2p-core/crypto"
 	"github.com/mikelsr/bspl"
+	"github.com/mikelsr/nahs/events"
 )
 
 func TestMain(m *testing.M) {
@@ -37,3 +42,127 @@ func TestNode_FindContact(t *testing.T) {
 		t.FailNow()
 	}
 }
+
+// blockingReasoner blocks RegisterInstance until release is closed
+type blockingReasoner struct {
+	mockReasoner
+	entered chan struct{}
+	release chan struct{}
+}
+
+func (b blockingReasoner) RegisterInstance(i bspl.Instance) error {
+	b.entered <- struct{}{}
+	<-b.release
+	return b.mockReasoner.RegisterInstance(i)
+}
+
+func TestNode_Close(t *testing.T) {
+	n := testNodes(2)
+	n1, n2 := n[0], n[1]
+	defer n1.Close(context.Background())
+
+	r := blockingReasoner{
+		entered: make(chan struct{}),
+		release: make(chan struct{}),
+	}
+	n2.reasoner = r
+
+	sent := make(chan error)
+	go func() {
+		sent <- n1.SendEvent(context.Background(), n2.ID(), events.MakeNewEvent(testInstance()))
+	}()
+	// wait until the event is being handled
+	<-r.entered
+
+	closed := make(chan error)
+	go func() {
+		closed <- n2.Close(context.Background())
+	}()
+	select {
+	case <-closed:
+		t.Log("Node closed before the in-flight handler finished")
+		t.FailNow()
+	case <-time.After(100 * time.Millisecond):
+	}
+
+	close(r.release)
+	if err := <-sent; err != nil {
+		t.Log(err)
+		t.FailNow()
+	}
+	if err := <-closed; err != nil {
+		t.Log(err)
+		t.FailNow()
+	}
+	// a closed node must not accept new events
+	drop := events.MakeDropEvent(testInstance().Key(), "_")
+	if err := n1.SendEvent(context.Background(), n2.ID(), drop); err == nil {
+		t.FailNow()
+	}
+	// closing twice is a no-op
+	if err := n2.Close(context.Background()); err != nil {
+		t.FailNow()
+	}
+}
+
+func TestNode_CloseTimeout(t *testing.T) {
+	n := testNodes(2)
+	n1, n2 := n[0], n[1]
+	defer n1.Close(context.Background())
+
+	r := blockingReasoner{
+		entered: make(chan struct{}),
+		release: make(chan struct{}),
+	}
+	n2.reasoner = r
+	defer close(r.release)
+
+	go n1.SendEvent(context.Background(), n2.ID(), events.MakeNewEvent(testInstance()))
+	<-r.entered
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	err := n2.Close(ctx)
+	var errClose ErrClose
+	if !errors.As(err, &errClose) || !errors.Is(err, context.DeadlineExceeded) {
+		t.Log(err)
+		t.FailNow()
+	}
+}
+
+func TestWithListenAddrs(t *testing.T) {
+	if _, err := applyOptions(WithListenAddrs()); err == nil {
+		t.FailNow()
+	}
+	if _, err := applyOptions(WithListenAddrs("invalid")); err == nil {
+		t.FailNow()
+	}
+	n, err := nodeFromPrivKey(*testKeys[0], WithListenAddrs("/ip4/127.0.0.1/tcp/0"))
+	if err != nil {
+		t.Log(err)
+		t.FailNow()
+	}
+	defer n.Close(context.Background())
+	addrs := n.Addrs()
+	if len(addrs) != 1 || !strings.HasPrefix(addrs[0].String(), "/ip4/127.0.0.1/tcp/") {
+		t.Log(addrs)
+		t.FailNow()
+	}
+}
+
+func TestNode_Services(t *testing.T) {
+	n := testNodes(1)[0]
+	defer n.Close(context.Background())
+	n.AddProtocol(tp1, tp1.Roles[0])
+	n.AddProtocol(tp2, tp2.Roles...)
+	services := n.Services()
+	if len(services) != 2 || services[0].Protocol.Key() != tp1.Key() || len(services[0].Roles) != 1 ||
+		len(services[1].Roles) != 2 {
+		t.FailNow()
+	}
+	// a copy of the roles is returned
+	services[1].Roles[0] = "Other"
+	if n.Services()[1].Roles[0] != tp2.Roles[0] {
+		t.FailNow()
+	}
+}
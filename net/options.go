@@ -0,0 +1,343 @@
+package net
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/libp2p/go-libp2p"
+	"github.com/libp2p/go-libp2p-core/peer"
+	"github.com/libp2p/go-libp2p-core/pnet"
+	dht "github.com/libp2p/go-libp2p-kad-dht"
+	"github.com/multiformats/go-multiaddr"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// DiscoveryMode establishes how a Node finds other NaHS nodes
+type DiscoveryMode int
+
+const (
+	// DiscoveryDHT announces the Node and searches for other nodes
+	// in the DHT reached through the bootstrap peers
+	DiscoveryDHT DiscoveryMode = iota
+	// DiscoveryMDNS searches for other nodes in the local network
+	// with mDNS, without connecting to any bootstrap peer
+	DiscoveryMDNS
+)
+
+// Option configures a Node when it is created
+type Option func(*options) error
+
+// options used to create a Node
+type options struct {
+	// libp2p options of the host
+	libp2p []libp2p.Option
+	// listenAddrs of the host
+	listenAddrs []string
+	// store to load the state of the node from and
+	// persist it to
+	store Store
+	// dedupWindow is how long run events are remembered
+	dedupWindow time.Duration
+	// reorderTimeout is how long events are held waiting
+	// for their predecessors
+	reorderTimeout time.Duration
+	// reorderBuffer is how many events may be held at once
+	reorderBuffer int
+	// sequenceTTL is how long the sequence numbers of an
+	// instance are kept without events
+	sequenceTTL time.Duration
+	// sendTimeout is how long a single try to send an event
+	// may take
+	sendTimeout time.Duration
+	// retry establishes how sending events is retried
+	retry retryPolicy
+	// outbox queues undelivered events, may be nil
+	outbox Outbox
+	// outboxInterval is how often queued events are redelivered
+	outboxInterval time.Duration
+	// discoveryInterval is how often the discovery loop runs
+	discoveryInterval time.Duration
+	// contactTTL is how long contacts are kept without being
+	// seen, 0 keeps them forever
+	contactTTL time.Duration
+	// discoveryMode establishes how other nodes are found
+	discoveryMode DiscoveryMode
+	// bootstrapPeers are connected to to join the DHT
+	bootstrapPeers []multiaddr.Multiaddr
+	// bootstrapTimeout limits the connection to the bootstrap peers
+	bootstrapTimeout time.Duration
+	// dhtMode establishes if the DHT answers queries
+	dhtMode dht.ModeOpt
+	// rendezvous is the namespace nodes are announced and
+	// searched for in
+	rendezvous string
+	// metrics are registered in registerer, may be nil
+	registerer prometheus.Registerer
+}
+
+// applyOptions builds the options of a Node
+func applyOptions(opts ...Option) (*options, error) {
+	o := &options{
+		listenAddrs:    listenAddrs,
+		dedupWindow:    defaultDedupWindow,
+		reorderTimeout: defaultReorderTimeout,
+		reorderBuffer:  defaultReorderBuffer,
+		sequenceTTL:    defaultSequenceTTL,
+		sendTimeout:    defaultSendTimeout,
+		retry: retryPolicy{
+			attempts:   defaultRetryAttempts,
+			backoff:    defaultRetryBackoff,
+			maxBackoff: defaultRetryMaxBackoff,
+		},
+		outboxInterval:    defaultOutboxInterval,
+		discoveryInterval: defaultDiscoveryInterval,
+		contactTTL:        defaultContactTTL,
+		bootstrapPeers:    dht.DefaultBootstrapPeers,
+		bootstrapTimeout:  defaultBootstrapTimeout,
+		dhtMode:           dht.ModeAuto,
+		rendezvous:        rendezvousString,
+	}
+	for _, opt := range opts {
+		if err := opt(o); err != nil {
+			return nil, err
+		}
+	}
+	return o, nil
+}
+
+// WithLibp2pOptions passes options to the libp2p host of the Node
+func WithLibp2pOptions(opts ...libp2p.Option) Option {
+	return func(o *options) error {
+		o.libp2p = append(o.libp2p, opts...)
+		return nil
+	}
+}
+
+// WithListenAddrs sets the multiaddrs the Node listens on instead of
+// any TCP port in every IPv4 and IPv6 interface
+func WithListenAddrs(addrs ...string) Option {
+	return func(o *options) error {
+		if len(addrs) == 0 {
+			return errors.New("No listen addresses")
+		}
+		for _, addr := range addrs {
+			if _, err := multiaddr.NewMultiaddr(addr); err != nil {
+				return fmt.Errorf("Invalid listen address '%s': %s", addr, err)
+			}
+		}
+		o.listenAddrs = addrs
+		return nil
+	}
+}
+
+// WithStore sets the Store the state of the Node is loaded from
+// on creation and written to on every change
+func WithStore(s Store) Option {
+	return func(o *options) error {
+		o.store = s
+		return nil
+	}
+}
+
+// WithDedupWindow sets how long the IDs of the events run by the
+// Node are remembered so duplicates are not run again
+func WithDedupWindow(d time.Duration) Option {
+	return func(o *options) error {
+		if d < 0 {
+			return errors.New("Dedup window must not be negative")
+		}
+		o.dedupWindow = d
+		return nil
+	}
+}
+
+// WithReorder sets how long events that arrive before their
+// predecessors are held and how many may be held at once
+func WithReorder(timeout time.Duration, buffer int) Option {
+	return func(o *options) error {
+		if timeout < 0 || buffer < 0 {
+			return errors.New("Reorder timeout and buffer must not be negative")
+		}
+		o.reorderTimeout, o.reorderBuffer = timeout, buffer
+		return nil
+	}
+}
+
+// WithSequenceTTL sets how long the sequence numbers events are
+// ordered by are kept for an instance that is neither dropped nor
+// sending events. Events received after they were forgotten may be
+// held for the reorder timeout once.
+func WithSequenceTTL(ttl time.Duration) Option {
+	return func(o *options) error {
+		if ttl <= 0 {
+			return errors.New("Sequence TTL must be positive")
+		}
+		o.sequenceTTL = ttl
+		return nil
+	}
+}
+
+// WithSendTimeout sets how long a single try to send an event may
+// take when the context passed to SendEvent has no earlier deadline
+func WithSendTimeout(d time.Duration) Option {
+	return func(o *options) error {
+		if d <= 0 {
+			return errors.New("Send timeout must be positive")
+		}
+		o.sendTimeout = d
+		return nil
+	}
+}
+
+// WithRetry sets how many times SendEvent tries to send an event
+// when it fails with a transient error, such as an unreachable peer
+// or a reset stream. The wait between tries starts at backoff and
+// is doubled after each one up to maxBackoff.
+func WithRetry(attempts int, backoff, maxBackoff time.Duration) Option {
+	return func(o *options) error {
+		if attempts < 1 || backoff < 0 || maxBackoff < backoff {
+			return errors.New("Invalid retry policy")
+		}
+		o.retry = retryPolicy{attempts: attempts, backoff: backoff, maxBackoff: maxBackoff}
+		return nil
+	}
+}
+
+// WithOutbox makes SendEvent queue the events it fails to deliver in
+// outbox instead of dropping them. Queued events are redelivered
+// every interval and whenever their target connects to the Node.
+func WithOutbox(outbox Outbox, interval time.Duration) Option {
+	return func(o *options) error {
+		if interval <= 0 {
+			return errors.New("Outbox interval must be positive")
+		}
+		o.outbox, o.outboxInterval = outbox, interval
+		return nil
+	}
+}
+
+// WithDiscoveryInterval sets how often the discovery loop of the
+// Node announces it, searches for other peers and evicts the
+// contacts that expired
+func WithDiscoveryInterval(d time.Duration) Option {
+	return func(o *options) error {
+		if d <= 0 {
+			return errors.New("Discovery interval must be positive")
+		}
+		o.discoveryInterval = d
+		return nil
+	}
+}
+
+// WithContactTTL sets how long contacts are kept without being seen
+// by the discovery loop or opening a stream to the Node. A ttl of 0
+// keeps them forever.
+func WithContactTTL(ttl time.Duration) Option {
+	return func(o *options) error {
+		if ttl < 0 {
+			return errors.New("Contact TTL must not be negative")
+		}
+		o.contactTTL = ttl
+		return nil
+	}
+}
+
+// WithDiscoveryMode sets how the Node finds other NaHS nodes, DHT
+// discovery through the public bootstrap peers by default. With
+// DiscoveryMDNS the Node searches for nodes in the local network
+// every discovery interval and exchanges protocols with them.
+func WithDiscoveryMode(mode DiscoveryMode) Option {
+	return func(o *options) error {
+		if mode != DiscoveryDHT && mode != DiscoveryMDNS {
+			return errors.New("Unknown discovery mode")
+		}
+		o.discoveryMode = mode
+		return nil
+	}
+}
+
+// WithBootstrapPeers sets the peers the Node connects to to join the
+// DHT instead of the public IPFS bootstrap peers. The addresses must
+// include the ID of the peer. No addresses leaves the Node out of any
+// DHT until other nodes connect to it.
+func WithBootstrapPeers(addrs ...multiaddr.Multiaddr) Option {
+	return func(o *options) error {
+		for _, addr := range addrs {
+			if _, err := peer.AddrInfoFromP2pAddr(addr); err != nil {
+				return fmt.Errorf("Invalid bootstrap address '%s': %s", addr, err)
+			}
+		}
+		o.bootstrapPeers = addrs
+		return nil
+	}
+}
+
+// WithBootstrapTimeout sets how long the Node tries to connect to the
+// bootstrap peers when it is created
+func WithBootstrapTimeout(d time.Duration) Option {
+	return func(o *options) error {
+		if d <= 0 {
+			return errors.New("Bootstrap timeout must be positive")
+		}
+		o.bootstrapTimeout = d
+		return nil
+	}
+}
+
+// WithDHTMode sets if the DHT of the Node answers the queries of other
+// peers (dht.ModeServer), only sends queries (dht.ModeClient) or
+// switches between both depending on its reachability (dht.ModeAuto,
+// the default)
+func WithDHTMode(mode dht.ModeOpt) Option {
+	return func(o *options) error {
+		switch mode {
+		case dht.ModeAuto, dht.ModeClient, dht.ModeServer, dht.ModeAutoServer:
+			o.dhtMode = mode
+			return nil
+		}
+		return errors.New("Unknown DHT mode")
+	}
+}
+
+// WithRendezvous sets the namespace the Node is announced and searches
+// for other nodes in, so separate deployments don't find each other.
+// It is also the mDNS service tag.
+func WithRendezvous(namespace string) Option {
+	return func(o *options) error {
+		if namespace == "" {
+			return errors.New("Rendezvous namespace must not be empty")
+		}
+		o.rendezvous = namespace
+		return nil
+	}
+}
+
+// WithPrivateNetwork makes the Node only connect to peers that share
+// the private network PSK, see LoadPSK, LoadPSKFromEnv and GeneratePSK.
+// Nodes in a private network can't reach the public bootstrap peers.
+func WithPrivateNetwork(psk pnet.PSK) Option {
+	return func(o *options) error {
+		if err := validPSK(psk); err != nil {
+			return err
+		}
+		o.libp2p = append(o.libp2p, libp2p.PrivateNetwork(psk))
+		return nil
+	}
+}
+
+// WithMetrics registers the Prometheus metrics of the Node in
+// registerer: streams opened, events received, applied and rejected,
+// event delivery latency, contacts, open instances and discovery pass
+// duration. The metrics are labelled with the ID of the Node, so
+// several nodes can share a registry, and unregistered when the Node
+// is closed.
+func WithMetrics(registerer prometheus.Registerer) Option {
+	return func(o *options) error {
+		if registerer == nil {
+			return errors.New("Metrics registerer can't be nil")
+		}
+		o.registerer = registerer
+		return nil
+	}
+}
@@ -0,0 +1,340 @@
+package net
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/libp2p/go-libp2p-core/peer"
+)
+
+// sequenceKey identifies the events exchanged with a peer on
+// an instance
+type sequenceKey struct {
+	peer        peer.ID
+	instanceKey string
+}
+
+// sequence is the last sequence number sent to, or the next one
+// expected from, a peer on an instance
+type sequence struct {
+	n uint64
+	// expires is when the entry is forgotten unless it is used
+	expires time.Time
+	// dropped is set once the instance was dropped, so using the
+	// entry doesn't keep it any longer
+	dropped bool
+}
+
+// delivery is an event being run or recently run
+type delivery struct {
+	// done is closed once the event was run
+	done chan struct{}
+	// err is the result of running the event
+	err error
+	// at is when the event finished running
+	at time.Time
+}
+
+// deliveredID identifies a delivery in the dedup window
+type deliveredID struct {
+	key string
+	at  time.Time
+}
+
+// eventOrder numbers the events sent by a Node and makes sure the
+// events it receives are run once and in the order they were sent.
+// Events are numbered per peer and instance, starting at 1. Events
+// that arrive before their predecessors are held until these are
+// run or the reorder timeout fires, in which case the missing
+// events are skipped. The sequences of an instance are forgotten
+// once it is dropped or after the sequence TTL without events.
+type eventOrder struct {
+	mutex sync.Mutex
+	// sent is the last sequence number sent to each peer
+	sent map[sequenceKey]sequence
+	// expected is the next sequence number expected from each peer
+	expected map[sequenceKey]sequence
+	// held are the events waiting for their predecessors, closing
+	// the channel wakes them up
+	held map[sequenceKey]map[uint64]chan struct{}
+	// nHeld is the number of held events
+	nHeld int
+	// deliveries maps events being run or run within the
+	// dedup window to their result
+	deliveries map[string]*delivery
+	// delivered lists run events by the time they finished
+	delivered []deliveredID
+	// nextSweep is when the expired sequences are forgotten next
+	nextSweep time.Time
+
+	window     time.Duration
+	timeout    time.Duration
+	bufferSize int
+	ttl        time.Duration
+}
+
+// newEventOrder is the default constructor for eventOrder
+func newEventOrder(window, timeout time.Duration, bufferSize int, ttl time.Duration) *eventOrder {
+	return &eventOrder{
+		sent:       make(map[sequenceKey]sequence),
+		expected:   make(map[sequenceKey]sequence),
+		held:       make(map[sequenceKey]map[uint64]chan struct{}),
+		deliveries: make(map[string]*delivery),
+		delivered:  make([]deliveredID, 0),
+		window:     window,
+		timeout:    timeout,
+		bufferSize: bufferSize,
+		ttl:        ttl,
+	}
+}
+
+// nextSequence reserves the sequence number of the next event sent
+// to a peer on an instance. If the event is neither delivered nor
+// queued the number must be released with releaseSequence.
+func (o *eventOrder) nextSequence(target peer.ID, instanceKey string) uint64 {
+	o.mutex.Lock()
+	defer o.mutex.Unlock()
+	now := time.Now()
+	o.sweep(now)
+	k := sequenceKey{peer: target, instanceKey: instanceKey}
+	s := o.sent[k]
+	s.n++
+	s.expires = now.Add(o.ttl)
+	o.sent[k] = s
+	return s.n
+}
+
+// releaseSequence gives back a sequence number reserved for an event
+// the peer didn't receive, so the next event doesn't leave a gap the
+// peer would wait for. Numbers reserved after it can't be released.
+func (o *eventOrder) releaseSequence(target peer.ID, instanceKey string, n uint64) {
+	o.mutex.Lock()
+	defer o.mutex.Unlock()
+	k := sequenceKey{peer: target, instanceKey: instanceKey}
+	if s, found := o.sent[k]; found && s.n == n {
+		s.n--
+		o.sent[k] = s
+	}
+}
+
+// forgetSent forgets the sequence numbers sent to a peer on an
+// instance, once the DropEvent of the instance was sent
+func (o *eventOrder) forgetSent(target peer.ID, instanceKey string) {
+	o.mutex.Lock()
+	defer o.mutex.Unlock()
+	delete(o.sent, sequenceKey{peer: target, instanceKey: instanceKey})
+}
+
+// dropped forgets the sequence numbers expected from every peer on
+// an instance once it was dropped. They are kept for the dedup
+// window so retries of the DropEvent are not held.
+func (o *eventOrder) dropped(instanceKey string) {
+	o.mutex.Lock()
+	defer o.mutex.Unlock()
+	expires := time.Now().Add(o.window)
+	for k, s := range o.expected {
+		if k.instanceKey == instanceKey {
+			s.expires, s.dropped = expires, true
+			o.expected[k] = s
+		}
+	}
+}
+
+// sweep forgets the sequences that expired, at most once every
+// sweepInterval. The mutex must be locked.
+func (o *eventOrder) sweep(now time.Time) {
+	if now.Before(o.nextSweep) {
+		return
+	}
+	o.nextSweep = now.Add(sweepInterval)
+	for k, s := range o.sent {
+		if now.After(s.expires) {
+			delete(o.sent, k)
+		}
+	}
+	for k, s := range o.expected {
+		// held events keep waiting for their predecessors
+		if now.After(s.expires) && len(o.held[k]) == 0 {
+			delete(o.expected, k)
+		}
+	}
+}
+
+// deliver runs an event received from sender. Duplicates of an
+// event that was run successfully within the dedup window are not
+// run again and return its result instead. Events with a sequence
+// number are run after their predecessors, events without one are
+// run as they arrive.
+func (o *eventOrder) deliver(sender peer.ID, id, instanceKey string, sequence uint64, run func() error) error {
+	d, duplicate := o.begin(sender, id)
+	if duplicate {
+		// a retried event may carry a new sequence number, which
+		// must be consumed so its successors aren't held
+		return o.deliverInOrder(sender, id, instanceKey, sequence, func() error {
+			<-d.done
+			return d.err
+		})
+	}
+	// a panicking event must not leave its delivery open, or its
+	// retries would wait for it forever
+	defer func() {
+		if r := recover(); r != nil {
+			o.end(sender, id, d, fmt.Errorf("Event panicked: %v", r))
+			panic(r)
+		}
+	}()
+	err := o.deliverInOrder(sender, id, instanceKey, sequence, run)
+	o.end(sender, id, d, err)
+	return err
+}
+
+// begin registers the delivery of an event. If the event is being
+// run or was run, the existing delivery is returned.
+func (o *eventOrder) begin(sender peer.ID, id string) (*delivery, bool) {
+	o.mutex.Lock()
+	defer o.mutex.Unlock()
+	o.prune()
+	o.sweep(time.Now())
+	key := deliveryKey(sender, id)
+	if d, found := o.deliveries[key]; found {
+		return d, true
+	}
+	d := &delivery{done: make(chan struct{})}
+	o.deliveries[key] = d
+	return d, false
+}
+
+// end stores the result of a delivery. Failed events are forgotten
+// so they can be retried.
+func (o *eventOrder) end(sender peer.ID, id string, d *delivery, err error) {
+	o.mutex.Lock()
+	defer o.mutex.Unlock()
+	key := deliveryKey(sender, id)
+	d.err, d.at = err, time.Now()
+	if err != nil {
+		delete(o.deliveries, key)
+	} else {
+		o.delivered = append(o.delivered, deliveredID{key: key, at: d.at})
+	}
+	close(d.done)
+}
+
+// prune forgets the events run before the dedup window.
+// The mutex must be locked.
+func (o *eventOrder) prune() {
+	limit := time.Now().Add(-o.window)
+	i := 0
+	for ; i < len(o.delivered) && o.delivered[i].at.Before(limit); i++ {
+		delete(o.deliveries, o.delivered[i].key)
+	}
+	o.delivered = o.delivered[i:]
+}
+
+// deliverInOrder waits for the turn of an event and runs it
+func (o *eventOrder) deliverInOrder(sender peer.ID, id, instanceKey string, sequence uint64, run func() error) error {
+	if sequence == 0 {
+		return run()
+	}
+	k := sequenceKey{peer: sender, instanceKey: instanceKey}
+	inOrder, err := o.await(k, id, sequence)
+	if err != nil {
+		return err
+	}
+	if !inOrder {
+		// events behind the expected sequence, e.g. sent after the
+		// sender restarted, are run as they arrive
+		return run()
+	}
+	defer o.advance(k, sequence)
+	return run()
+}
+
+// await blocks until the event is the next one expected or the reorder
+// timeout fires. It returns false if the event is behind the expected
+// sequence or another event is held with the same sequence.
+func (o *eventOrder) await(k sequenceKey, id string, sequence uint64) (bool, error) {
+	o.mutex.Lock()
+	defer o.mutex.Unlock()
+	expected := o.expectedSequence(k)
+	if sequence < expected {
+		return false, nil
+	}
+	if sequence == expected {
+		return true, nil
+	}
+	if _, found := o.held[k][sequence]; found {
+		// the slot is taken, e.g. by the first try of the event
+		return false, nil
+	}
+	if o.nHeld >= o.bufferSize {
+		return false, ErrOutOfOrder{ErrHandleEvent{ID: id, Status: StatusOutOfOrder,
+			Reason: "Too many events waiting for their predecessors"}}
+	}
+	wake := make(chan struct{})
+	if _, found := o.held[k]; !found {
+		o.held[k] = make(map[uint64]chan struct{})
+	}
+	o.held[k][sequence] = wake
+	o.nHeld++
+
+	o.mutex.Unlock()
+	timer := time.NewTimer(o.timeout)
+	select {
+	case <-wake:
+		timer.Stop()
+	case <-timer.C:
+	}
+	o.mutex.Lock()
+
+	delete(o.held[k], sequence)
+	if len(o.held[k]) == 0 {
+		delete(o.held, k)
+	}
+	o.nHeld--
+	if o.expectedSequence(k) < sequence {
+		logger.Warningf("Skipping events %d to %d from '%s' for instance '%s'",
+			o.expectedSequence(k), sequence-1, k.peer, k.instanceKey)
+		o.setExpected(k, sequence)
+	}
+	return o.expectedSequence(k) == sequence, nil
+}
+
+// advance marks an event as run and wakes up its successor
+func (o *eventOrder) advance(k sequenceKey, sequence uint64) {
+	o.mutex.Lock()
+	defer o.mutex.Unlock()
+	if o.expectedSequence(k) > sequence {
+		return
+	}
+	o.setExpected(k, sequence+1)
+	if wake, found := o.held[k][sequence+1]; found {
+		close(wake)
+		delete(o.held[k], sequence+1)
+	}
+}
+
+// expectedSequence returns the next sequence number expected from
+// a peer on an instance. The mutex must be locked.
+func (o *eventOrder) expectedSequence(k sequenceKey) uint64 {
+	if expected, found := o.expected[k]; found {
+		return expected.n
+	}
+	return 1
+}
+
+// setExpected sets the next sequence number expected from a peer on
+// an instance. The mutex must be locked.
+func (o *eventOrder) setExpected(k sequenceKey, n uint64) {
+	s := o.expected[k]
+	s.n = n
+	if !s.dropped {
+		s.expires = time.Now().Add(o.ttl)
+	}
+	o.expected[k] = s
+}
+
+// deliveryKey identifies an event sent by a peer
+func deliveryKey(sender peer.ID, id string) string {
+	return string(sender) + "/" + id
+}
@@ -0,0 +1,332 @@
+package net
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/mikelsr/nahs/events"
+)
+
+func TestEventOrder_nextSequence(t *testing.T) {
+	o := newEventOrder(time.Minute, time.Second, 1, time.Hour)
+	a, b := testID(0), testID(1)
+	if o.nextSequence(a, "i") != 1 || o.nextSequence(a, "i") != 2 {
+		t.FailNow()
+	}
+	// sequences are kept per peer and instance
+	if o.nextSequence(b, "i") != 1 || o.nextSequence(a, "j") != 1 {
+		t.FailNow()
+	}
+}
+
+func TestEventOrder_dedup(t *testing.T) {
+	o := newEventOrder(time.Minute, time.Second, 1, time.Hour)
+	sender := testID(0)
+	runs := 0
+	run := func() error {
+		runs++
+		return nil
+	}
+	fail := func() error {
+		runs++
+		return errMock
+	}
+
+	// run events are not run again
+	for i := 0; i < 3; i++ {
+		if err := o.deliver(sender, "a", "i", 0, run); err != nil || runs != 1 {
+			t.FailNow()
+		}
+	}
+	// the same ID from another sender is another event
+	o.deliver(testID(1), "a", "i", 0, run)
+	if runs != 2 {
+		t.FailNow()
+	}
+	// failed events may be retried
+	if err := o.deliver(sender, "b", "i", 0, fail); err != errMock {
+		t.FailNow()
+	}
+	if err := o.deliver(sender, "b", "i", 0, run); err != nil || runs != 4 {
+		t.FailNow()
+	}
+
+	// events are forgotten after the dedup window
+	o = newEventOrder(0, time.Second, 1, time.Hour)
+	o.deliver(sender, "a", "i", 0, run)
+	time.Sleep(time.Millisecond)
+	o.deliver(sender, "a", "i", 0, run)
+	if runs != 6 {
+		t.FailNow()
+	}
+}
+
+func TestEventOrder_reorder(t *testing.T) {
+	o := newEventOrder(time.Minute, 5*time.Second, 4, time.Hour)
+	sender := testID(0)
+	var mutex sync.Mutex
+	order := make([]uint64, 0)
+	run := func(sequence uint64) func() error {
+		return func() error {
+			mutex.Lock()
+			defer mutex.Unlock()
+			order = append(order, sequence)
+			return nil
+		}
+	}
+
+	// 3 and 2 arrive before 1
+	var wg sync.WaitGroup
+	for _, sequence := range []uint64{3, 2} {
+		wg.Add(1)
+		go func(sequence uint64) {
+			defer wg.Done()
+			if err := o.deliver(sender, string(rune('0'+sequence)), "i", sequence, run(sequence)); err != nil {
+				t.Error(err)
+			}
+		}(sequence)
+	}
+	// wait until both are held
+	for held := 0; held != 2; {
+		time.Sleep(10 * time.Millisecond)
+		o.mutex.Lock()
+		held = o.nHeld
+		o.mutex.Unlock()
+	}
+	if err := o.deliver(sender, "1", "i", 1, run(1)); err != nil {
+		t.FailNow()
+	}
+	wg.Wait()
+	if len(order) != 3 || order[0] != 1 || order[1] != 2 || order[2] != 3 {
+		t.Log(order)
+		t.FailNow()
+	}
+}
+
+func TestEventOrder_timeout(t *testing.T) {
+	o := newEventOrder(time.Minute, 50*time.Millisecond, 1, time.Hour)
+	sender := testID(0)
+	run := func() error { return nil }
+
+	// 1 never arrives, 2 is run after the timeout
+	start := time.Now()
+	if err := o.deliver(sender, "2", "i", 2, run); err != nil {
+		t.FailNow()
+	}
+	if time.Since(start) < 50*time.Millisecond {
+		t.FailNow()
+	}
+	o.mutex.Lock()
+	expected := o.expectedSequence(sequenceKey{peer: sender, instanceKey: "i"})
+	o.mutex.Unlock()
+	if expected != 3 {
+		t.FailNow()
+	}
+	// late events are run as they arrive
+	if err := o.deliver(sender, "1", "i", 1, run); err != nil {
+		t.FailNow()
+	}
+
+	// events are rejected when the buffer is full
+	o = newEventOrder(time.Minute, time.Second, 0, time.Hour)
+	err := o.deliver(sender, "2", "i", 2, run)
+	var errOutOfOrder ErrOutOfOrder
+	if !errors.As(err, &errOutOfOrder) {
+		t.Log(err)
+		t.FailNow()
+	}
+}
+
+func TestNode_eventOrder(t *testing.T) {
+	n := testNodes(2)
+	for _, node := range n {
+		node.reasoner = mockReasoner{}
+		defer node.Close(context.Background())
+	}
+	n1, n2 := n[0], n[1]
+	instance := testInstance()
+	sequenced := func(event events.Event, sequence uint64) []byte {
+		data, _ := event.Marshal()
+		data, _ = events.SetSequence(data, sequence)
+		data, _ = events.Sign(data, *testKeys[0])
+		return data
+	}
+
+	// the DropEvent arrives before the NewEvent
+	drop := events.MakeDropEvent(instance.Key(), "_")
+	done := make(chan error)
+	go func() {
+		done <- n1.sendEventData(context.Background(), n2.ID(), drop.ID(), sequenced(drop, 2))
+	}()
+	time.Sleep(100 * time.Millisecond)
+	create := events.MakeNewEvent(instance)
+	if err := n1.sendEventData(context.Background(), n2.ID(), create.ID(), sequenced(create, 1)); err != nil {
+		t.Log(err)
+		t.FailNow()
+	}
+	if err := <-done; err != nil {
+		t.Log(err)
+		t.FailNow()
+	}
+	if n2.OpenInstances().Len() != 0 {
+		t.FailNow()
+	}
+}
+
+func TestEventOrder_retry(t *testing.T) {
+	o := newEventOrder(time.Minute, 5*time.Second, 4, time.Hour)
+	sender := testID(0)
+	runs := 0
+	run := func() error {
+		runs++
+		return nil
+	}
+
+	// 2 is retried while it is held
+	done := make(chan error, 2)
+	for i := 0; i < 2; i++ {
+		go func() { done <- o.deliver(sender, "2", "i", 2, run) }()
+	}
+	time.Sleep(50 * time.Millisecond)
+	start := time.Now()
+	if err := o.deliver(sender, "1", "i", 1, run); err != nil {
+		t.FailNow()
+	}
+	for i := 0; i < 2; i++ {
+		if err := <-done; err != nil {
+			t.FailNow()
+		}
+	}
+	if runs != 2 || time.Since(start) > time.Second {
+		t.FailNow()
+	}
+	// a retry with a new sequence number consumes it
+	if err := o.deliver(sender, "2", "i", 3, run); err != nil {
+		t.FailNow()
+	}
+	start = time.Now()
+	if err := o.deliver(sender, "4", "i", 4, run); err != nil || runs != 3 {
+		t.FailNow()
+	}
+	if time.Since(start) > time.Second {
+		t.FailNow()
+	}
+}
+
+func TestEventOrder_panic(t *testing.T) {
+	o := newEventOrder(time.Minute, time.Second, 1, time.Hour)
+	sender := testID(0)
+	deliver := func(run func() error) (err error) {
+		defer func() {
+			if r := recover(); r != nil {
+				err = errMock
+			}
+		}()
+		return o.deliver(sender, "a", "i", 1, run)
+	}
+
+	// the panic is passed on and the delivery ended
+	if err := deliver(func() error { panic("run") }); err != errMock {
+		t.FailNow()
+	}
+	done := make(chan error, 1)
+	go func() { done <- deliver(func() error { return nil }) }()
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Log(err)
+			t.FailNow()
+		}
+	case <-time.After(time.Second):
+		t.Log("retry of a panicked event blocked")
+		t.FailNow()
+	}
+}
+
+func TestEventOrder_releaseSequence(t *testing.T) {
+	o := newEventOrder(time.Minute, time.Second, 1, time.Hour)
+	a := testID(0)
+	o.nextSequence(a, "i")
+	// the last number reserved is given back
+	o.releaseSequence(a, "i", o.nextSequence(a, "i"))
+	if o.nextSequence(a, "i") != 2 {
+		t.FailNow()
+	}
+	// but not one followed by other reservations
+	o.nextSequence(a, "i")
+	o.releaseSequence(a, "i", 2)
+	if o.nextSequence(a, "i") != 4 {
+		t.FailNow()
+	}
+}
+
+func TestEventOrder_sweep(t *testing.T) {
+	o := newEventOrder(time.Minute, time.Second, 1, time.Hour)
+	sender := testID(0)
+	run := func() error { return nil }
+	o.nextSequence(sender, "i")
+	for _, key := range []string{"i", "j"} {
+		if err := o.deliver(sender, key, key, 1, run); err != nil {
+			t.FailNow()
+		}
+	}
+	// dropped instances are forgotten after the dedup window
+	o.dropped("j")
+	o.mutex.Lock()
+	o.sweep(time.Now().Add(2 * time.Minute))
+	if len(o.sent) != 1 || len(o.expected) != 1 {
+		o.mutex.Unlock()
+		t.FailNow()
+	}
+	// and the rest after the TTL
+	o.nextSweep = time.Time{}
+	o.sweep(time.Now().Add(2 * time.Hour))
+	if len(o.sent) != 0 || len(o.expected) != 0 {
+		o.mutex.Unlock()
+		t.FailNow()
+	}
+	o.mutex.Unlock()
+}
+
+func TestNode_sequences(t *testing.T) {
+	n := testNodes(2)
+	for _, node := range n {
+		node.reasoner = mockReasoner{}
+		defer node.Close(context.Background())
+	}
+	n1, n2 := n[0], n[1]
+	n1.retry = retryPolicy{attempts: 1}
+	instance := testInstance()
+
+	// events that can't be delivered don't consume a number
+	unreachable := testID(2)
+	if err := n1.SendEvent(context.Background(), unreachable, events.MakeNewEvent(instance)); err == nil {
+		t.FailNow()
+	}
+	if s := n1.order.nextSequence(unreachable, instance.Key()); s != 1 {
+		t.Log(s)
+		t.FailNow()
+	}
+
+	// the numbers of an instance are forgotten once it is dropped
+	if err := n1.SendEvent(context.Background(), n2.ID(), events.MakeNewEvent(instance)); err != nil {
+		t.Log(err)
+		t.FailNow()
+	}
+	if err := n1.SendEvent(context.Background(), n2.ID(), events.MakeDropEvent(instance.Key(), "_")); err != nil {
+		t.Log(err)
+		t.FailNow()
+	}
+	if s := n1.order.nextSequence(n2.ID(), instance.Key()); s != 1 {
+		t.Log(s)
+		t.FailNow()
+	}
+	n2.order.mutex.Lock()
+	defer n2.order.mutex.Unlock()
+	if s := n2.order.expected[sequenceKey{peer: n1.ID(), instanceKey: instance.Key()}]; !s.dropped {
+		t.FailNow()
+	}
+}
@@ -0,0 +1,127 @@
+package net
+
+import (
+	"context"
+	"encoding/json"
+	"sort"
+	"time"
+
+	"github.com/libp2p/go-libp2p-core/network"
+	"github.com/libp2p/go-libp2p-core/peer"
+)
+
+// OutboundEvent is a signed event waiting to be delivered
+type OutboundEvent struct {
+	// Target is the peer the event is sent to
+	Target peer.ID `json:"target"`
+	// ID of the event
+	ID string `json:"id"`
+	// Data is the marshalled and signed event
+	Data []byte `json:"data"`
+	// Queued is when the event was queued
+	Queued time.Time `json:"queued"`
+}
+
+// Outbox persists the events that could not be delivered so they
+// are redelivered once their target is reachable. Events are
+// identified by their target and ID, receivers use the ID to
+// avoid running an event twice.
+type Outbox interface {
+	// LoadOutbound returns all the queued events
+	LoadOutbound() ([]OutboundEvent, error)
+	// PutOutbound queues an event
+	PutOutbound(e OutboundEvent) error
+	// DeleteOutbound removes an event from the queue
+	DeleteOutbound(target peer.ID, id string) error
+}
+
+// outboundKey identifies an OutboundEvent in an Outbox
+func outboundKey(target peer.ID, id string) string {
+	return string(target) + "/" + id
+}
+
+// sortOutbound sorts events by the time they were queued
+func sortOutbound(queued []OutboundEvent) {
+	sort.Slice(queued, func(i, j int) bool {
+		return queued[i].Queued.Before(queued[j].Queued)
+	})
+}
+
+// marshalOutbound marshals an OutboundEvent to bytes
+func marshalOutbound(e OutboundEvent) ([]byte, error) {
+	return json.Marshal(e)
+}
+
+// unmarshalOutbound unmarshals an OutboundEvent from bytes
+func unmarshalOutbound(data []byte) (OutboundEvent, error) {
+	var e OutboundEvent
+	err := json.Unmarshal(data, &e)
+	return e, err
+}
+
+// queueEvent puts an event that couldn't be delivered in the outbox
+func (n *Node) queueEvent(target peer.ID, id string, data []byte) error {
+	return n.outbox.PutOutbound(OutboundEvent{Target: target, ID: id, Data: data, Queued: time.Now()})
+}
+
+// startOutbox redelivers the queued events every interval and
+// whenever a connection to their target is established
+func (n *Node) startOutbox(interval time.Duration) {
+	n.outboxKick = make(chan peer.ID, 16)
+	n.host.Network().Notify(&network.NotifyBundle{
+		ConnectedF: func(_ network.Network, c network.Conn) {
+			select {
+			case n.outboxKick <- c.RemotePeer():
+			default:
+			}
+		},
+	})
+	n.background.Add(1)
+	go func() {
+		defer n.background.Done()
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-n.context.Done():
+				return
+			case <-ticker.C:
+				n.redeliver("")
+			case id := <-n.outboxKick:
+				n.redeliver(id)
+			}
+		}
+	}()
+}
+
+// redeliver tries to send the queued events for target, or every
+// queued event if target is empty. Delivered events and events
+// rejected by their target are removed from the outbox.
+func (n *Node) redeliver(target peer.ID) {
+	queued, err := n.outbox.LoadOutbound()
+	if err != nil {
+		logger.Errorf("Could not load outbox: %s", err)
+		return
+	}
+	for _, e := range queued {
+		if target != "" && e.Target != target {
+			continue
+		}
+		if n.context.Err() != nil {
+			return
+		}
+		ctx, cancel := context.WithTimeout(n.context, n.sendTimeout)
+		err := n.sendEventData(ctx, e.Target, e.ID, e.Data)
+		cancel()
+		if err != nil && isTransient(err) {
+			logger.Debugf("Could not redeliver event '%s' to '%s': %s", e.ID, e.Target, err)
+			continue
+		}
+		if err != nil {
+			logger.Warningf("Event '%s' redelivered to '%s' was rejected: %s", e.ID, e.Target, err)
+		}
+		if err := n.outbox.DeleteOutbound(e.Target, e.ID); err != nil {
+			logger.Errorf("Could not remove event '%s' from outbox: %s", e.ID, err)
+		}
+	}
+}
@@ -0,0 +1,121 @@
+package net
+
+import (
+	"encoding/json"
+	"reflect"
+
+	"github.com/libp2p/go-libp2p-core/peer"
+	"github.com/mikelsr/bspl"
+)
+
+// Ownership describes which peers may run events on an open
+// instance: the peer that created it and the peers bound to
+// each of its roles.
+type Ownership struct {
+	// Creator is the peer that sent the NewEvent
+	Creator peer.ID `json:"creator"`
+	// Roles maps roles of the instance to the peers bound to them
+	Roles map[bspl.Role]peer.ID `json:"roles,omitempty"`
+}
+
+// makeOwnership builds the Ownership of an instance created by
+// creator. Role bindings that are not peer IDs are ignored.
+func makeOwnership(creator peer.ID, roles bspl.Roles) Ownership {
+	o := Ownership{Creator: creator, Roles: make(map[bspl.Role]peer.ID)}
+	for role, binding := range roles {
+		id, err := peer.Decode(binding)
+		if err != nil {
+			continue
+		}
+		o.Roles[role] = id
+	}
+	return o
+}
+
+// bound reports whether any role is bound to a peer. Instances
+// with no bound roles belong only to their creator.
+func (o Ownership) bound() bool {
+	return len(o.Roles) > 0
+}
+
+// IsParticipant reports whether a peer is bound to any role of
+// the instance. If no role is bound to a peer only the creator
+// participates.
+func (o Ownership) IsParticipant(id peer.ID) bool {
+	if !o.bound() {
+		return id == o.Creator
+	}
+	for _, bound := range o.Roles {
+		if bound == id {
+			return true
+		}
+	}
+	return false
+}
+
+// Plays reports whether a peer is bound to a role of the instance.
+// If no role is bound to a peer the creator plays every role.
+func (o Ownership) Plays(id peer.ID, role bspl.Role) bool {
+	if !o.bound() {
+		return id == o.Creator
+	}
+	bound, found := o.Roles[role]
+	return found && bound == id
+}
+
+// marshalOwnership marshals an Ownership to bytes
+func marshalOwnership(o Ownership) ([]byte, error) {
+	return json.Marshal(o)
+}
+
+// unmarshalOwnership unmarshals an Ownership from bytes
+func unmarshalOwnership(data []byte) (Ownership, error) {
+	var o Ownership
+	err := json.Unmarshal(data, &o)
+	return o, err
+}
+
+// performingRoles returns the roles that may have performed the
+// action that turned current into next: the senders of the actions
+// that bind every parameter bound in next but not in current. The
+// actions are those of the protocol of current, so no roles are
+// returned if next carries a different protocol. No action may
+// change the values already bound in current, so no roles are
+// returned if any of them differs in next either.
+func performingRoles(current, next bspl.Instance) []bspl.Role {
+	p := current.Protocol()
+	roles := make([]bspl.Role, 0)
+	if !reflect.DeepEqual(p, next.Protocol()) {
+		return roles
+	}
+	bound := make([]string, 0)
+	for _, param := range p.Parameters() {
+		value := current.GetValue(param.Name)
+		if value != "" && next.GetValue(param.Name) != value {
+			return roles
+		}
+		if value == "" && next.GetValue(param.Name) != "" {
+			bound = append(bound, param.Name)
+		}
+	}
+	if len(bound) == 0 {
+		return roles
+	}
+	for _, action := range p.Actions {
+		outs := make(map[string]bool)
+		for _, out := range action.Outs() {
+			outs[out.Name] = true
+		}
+		matches := true
+		for _, name := range bound {
+			if !outs[name] {
+				matches = false
+				break
+			}
+		}
+		if matches {
+			roles = append(roles, action.From)
+		}
+	}
+	return roles
+}
@@ -0,0 +1,151 @@
+package net
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/libp2p/go-libp2p-core/peer"
+	"github.com/mikelsr/bspl"
+	imp "github.com/mikelsr/bspl/implementation"
+	"github.com/mikelsr/bspl/proto"
+	"github.com/mikelsr/nahs/events"
+)
+
+func TestMakeOwnership(t *testing.T) {
+	buyer, seller := testID(0), testID(1)
+	roles := bspl.Roles{
+		proto.Role("Buyer"):  buyer.Pretty(),
+		proto.Role("Seller"): "S",
+	}
+	o := makeOwnership(seller, roles)
+	if o.Creator != seller || len(o.Roles) != 1 || o.Roles[proto.Role("Buyer")] != buyer {
+		t.FailNow()
+	}
+	data, err := marshalOwnership(o)
+	if err != nil {
+		t.Log(err)
+		t.FailNow()
+	}
+	o2, err := unmarshalOwnership(data)
+	if err != nil || o2.Creator != o.Creator || o2.Roles[proto.Role("Buyer")] != buyer {
+		t.Log(err)
+		t.FailNow()
+	}
+}
+
+func TestOwnership(t *testing.T) {
+	a, b, c := testID(0), testID(1), testID(2)
+	buyer, seller := proto.Role("Buyer"), proto.Role("Seller")
+
+	// with no roles bound only the creator participates
+	unbound := Ownership{Creator: a}
+	if !unbound.IsParticipant(a) || unbound.IsParticipant(b) {
+		t.FailNow()
+	}
+	if !unbound.Plays(a, buyer) || !unbound.Plays(a, seller) || unbound.Plays(b, buyer) {
+		t.FailNow()
+	}
+
+	// with roles bound, the creator is just another peer
+	bound := Ownership{Creator: c, Roles: map[bspl.Role]peer.ID{buyer: a, seller: b}}
+	if !bound.IsParticipant(a) || !bound.IsParticipant(b) || bound.IsParticipant(c) {
+		t.FailNow()
+	}
+	if !bound.Plays(a, buyer) || bound.Plays(a, seller) || !bound.Plays(b, seller) {
+		t.FailNow()
+	}
+}
+
+func TestPerformingRoles(t *testing.T) {
+	p := testProtocol()
+	roles := bspl.Roles{
+		proto.Role("Buyer"):  "B",
+		proto.Role("Seller"): "S",
+	}
+	empty := imp.NewInstance(p, roles)
+	requested := imp.NewInstance(p, roles)
+	requested.SetValue("ID", "X")
+	requested.SetValue("item", "X")
+	offered := imp.NewInstance(p, roles)
+	offered.SetValue("ID", "X")
+	offered.SetValue("item", "X")
+	offered.SetValue("price", "X")
+	rewritten := imp.NewInstance(p, roles)
+	rewritten.SetValue("ID", "X")
+	rewritten.SetValue("item", "Y")
+	rewritten.SetValue("price", "X")
+	// a protocol with the same key in which Seller sends Offer
+	forged := testProtocol()
+	forged.Actions[0].From = proto.Role("Seller")
+	forgedOffer := imp.NewInstance(forged, roles)
+	forgedOffer.SetValue("ID", "X")
+	forgedOffer.SetValue("item", "X")
+	forgedOffer.SetValue("price", "X")
+
+	testCases := []struct {
+		name          string
+		current, next bspl.Instance
+		expected      int
+	}{
+		{"Request", empty, requested, 1},
+		{"Offer", requested, offered, 1},
+		{"Unknown", empty, offered, 0},
+		{"Unchanged", offered, offered, 0},
+		{"Rewritten", requested, rewritten, 0},
+		{"Forged", requested, forgedOffer, 0},
+	}
+	for _, tc := range testCases {
+		roles := performingRoles(tc.current, tc.next)
+		if len(roles) != tc.expected {
+			t.Logf("%s: expected %d roles, got %v", tc.name, tc.expected, roles)
+			t.FailNow()
+		}
+		if len(roles) == 1 && roles[0] != proto.Role("Buyer") {
+			t.Logf("%s: unexpected role '%s'", tc.name, roles[0])
+			t.FailNow()
+		}
+	}
+}
+
+func TestNode_boundOwnership(t *testing.T) {
+	n := testNodes(3)
+	for _, node := range n {
+		node.reasoner = mockReasoner{}
+		defer node.Close(context.Background())
+	}
+	n1, n2, n3 := n[0], n[1], n[2]
+
+	// the instance binds Buyer to n1 and Seller to n2
+	instance := testInstance()
+	instance.Roles()[proto.Role("Buyer")] = n1.ID().Pretty()
+	instance.Roles()[proto.Role("Seller")] = n2.ID().Pretty()
+
+	// n3 plays no role in the instance and can't create it
+	var errUnauthorized ErrUnauthorized
+	err := n3.SendEvent(context.Background(), n2.ID(), events.MakeNewEvent(instance))
+	if !errors.As(err, &errUnauthorized) {
+		t.Log(err)
+		t.FailNow()
+	}
+	if err := n1.SendEvent(context.Background(), n2.ID(), events.MakeNewEvent(instance)); err != nil {
+		t.Log(err)
+		t.FailNow()
+	}
+	o, found := n2.OpenInstances().Get(instance.Key())
+	if !found || o.Creator != n1.ID() || !o.Plays(n2.ID(), proto.Role("Seller")) {
+		t.FailNow()
+	}
+
+	// n3 can't drop it either
+	err = n3.SendEvent(context.Background(), n2.ID(), events.MakeDropEvent(instance.Key(), "_"))
+	if !errors.As(err, &errUnauthorized) {
+		t.Log(err)
+		t.FailNow()
+	}
+	// but any participant can
+	if err := n1.SendEvent(context.Background(), n2.ID(), events.MakeDropEvent(instance.Key(), "_")); err != nil {
+		t.Log(err)
+		t.FailNow()
+	}
+}
@@ -0,0 +1,63 @@
+package net
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/libp2p/go-libp2p-core/peer"
+)
+
+// Ping sends a random message to a peer with the echo protocol and
+// returns the round trip time once the peer writes it back. The
+// stream is reset if ctx is done before.
+func (n *Node) Ping(ctx context.Context, target peer.ID) (time.Duration, error) {
+	start := time.Now()
+	stream, err := n.host.NewStream(ctx, target, protocolEchoID, legacyProtocolEchoID)
+	if err != nil {
+		return 0, err
+	}
+	defer stream.Close()
+	n.metrics.streamOpened(stream.Protocol(), directionOutbound)
+	if deadline, ok := ctx.Deadline(); ok {
+		stream.SetDeadline(deadline)
+	}
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		select {
+		case <-ctx.Done():
+			stream.Reset()
+		case <-done:
+		}
+	}()
+
+	msg := []byte(uuid.New().String())
+	rw := bufio.NewReadWriter(bufio.NewReader(stream), bufio.NewWriter(stream))
+	var response []byte
+	if stream.Protocol() == legacyProtocolEchoID {
+		msg = append(msg, exchangeEnd)
+		if err = legacyEchoWrite(rw, msg); err == nil {
+			response, err = legacyEchoRead(rw)
+		}
+	} else {
+		if err = writeFrame(rw.Writer, frameEcho, msg); err == nil {
+			if err = rw.Flush(); err == nil {
+				response, err = readFrameOfType(rw.Reader, frameEcho)
+			}
+		}
+	}
+	if err != nil {
+		if ctx.Err() != nil {
+			return 0, ctx.Err()
+		}
+		return 0, err
+	}
+	if !bytes.Equal(response, msg) {
+		return 0, fmt.Errorf("Echo expected '%s' but got '%s'", msg, response)
+	}
+	return time.Since(start), nil
+}
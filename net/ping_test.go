@@ -0,0 +1,28 @@
+package net
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestNode_Ping(t *testing.T) {
+	n := testNodes(3)
+	n1, n2, n3 := n[0], n[1], n[2]
+	defer n1.Close(context.Background())
+	defer n2.Close(context.Background())
+
+	rtt, err := n1.Ping(context.Background(), n2.ID())
+	if err != nil || rtt <= 0 {
+		t.Log(err)
+		t.FailNow()
+	}
+
+	// peers that are down
+	n3.Close(context.Background())
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	if _, err := n1.Ping(ctx, n3.ID()); err == nil {
+		t.FailNow()
+	}
+}
@@ -1,26 +1,166 @@
 package net
 
 import (
+	"bytes"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"io/ioutil"
 	"os"
 	"path/filepath"
+	"strings"
 
 	"github.com/libp2p/go-libp2p-core/pnet"
 	"github.com/mikelsr/nahs/utils"
 )
 
-// loadPrivNetPSK reads a private network PSK
-func loadPrivNetPSK() pnet.PSK {
-	dir, err := utils.GetProjectDir()
+// ReadPSK reads a private network PSK in the V1 format written by
+// WritePSK and scripts/gen_psk.sh. Keys that are not pskLength bytes
+// long are refused with ErrInvalidPSK.
+func ReadPSK(r io.Reader) (pnet.PSK, error) {
+	b, err := ioutil.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+	// pnet.DecodeV1PSK would ignore the bytes after the first
+	// pskLength, so the key is decoded here to check its length
+	lines := strings.SplitN(string(b), "\n", 3)
+	if len(lines) != 3 || strings.TrimRight(lines[0], "\r") != pskHeader {
+		return nil, fmt.Errorf("Expected PSK header '%s'", pskHeader)
+	}
+	var psk []byte
+	switch encoding := strings.TrimRight(lines[1], "\r"); encoding {
+	case pskEncodingBase16:
+		psk, err = hex.DecodeString(strings.TrimSpace(lines[2]))
+	case pskEncodingBase64:
+		psk, err = base64.StdEncoding.DecodeString(strings.TrimSpace(lines[2]))
+	case pskEncodingBin:
+		psk = []byte(lines[2])
+	default:
+		return nil, fmt.Errorf("Unknown PSK encoding '%s'", encoding)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := validPSK(psk); err != nil {
+		return nil, err
+	}
+	return psk, nil
+}
+
+// LoadPSK reads a private network PSK from a file
+func LoadPSK(path string) (pnet.PSK, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	return ReadPSK(file)
+}
+
+// LoadPSKFromEnv reads a private network PSK from an environment
+// variable, PSKEnvKey if name is empty. The variable holds either
+// a PSK in the V1 format or only its 64 hexadecimal characters.
+func LoadPSKFromEnv(name string) (pnet.PSK, error) {
+	if name == "" {
+		name = PSKEnvKey
+	}
+	value, found := os.LookupEnv(name)
+	if !found {
+		return nil, fmt.Errorf("Environment variable '%s' is not set", name)
+	}
+	value = strings.TrimSpace(value)
+	if strings.HasPrefix(value, pskHeader) {
+		return ReadPSK(strings.NewReader(value))
+	}
+	psk, err := hex.DecodeString(value)
+	if err != nil {
+		return nil, err
+	}
+	if err := validPSK(psk); err != nil {
+		return nil, err
+	}
+	return psk, nil
+}
+
+// GeneratePSK creates a random private network PSK
+func GeneratePSK() (pnet.PSK, error) {
+	psk := make([]byte, pskLength)
+	if _, err := rand.Read(psk); err != nil {
+		return nil, err
+	}
+	return psk, nil
+}
+
+// WritePSK writes a private network PSK in the V1 format with
+// base16 encoding
+func WritePSK(w io.Writer, psk pnet.PSK) error {
+	if err := validPSK(psk); err != nil {
+		return err
+	}
+	_, err := fmt.Fprintf(w, "%s\n%s\n%s", pskHeader, pskEncodingBase16, hex.EncodeToString(psk))
+	return err
+}
+
+// WritePSKFile writes a private network PSK to a file only readable
+// by its owner, creating the directories in its path. The PSK is
+// written to a temporary file in the same directory that replaces
+// the file in path once written, so it is never readable by others,
+// even if the file existed with other permissions.
+func WritePSKFile(path string, psk pnet.PSK) error {
+	var b bytes.Buffer
+	if err := WritePSK(&b, psk); err != nil {
+		return err
+	}
+	dir := filepath.Dir(path)
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return err
+	}
+	f, err := ioutil.TempFile(dir, "."+filepath.Base(path)+".tmp")
 	if err != nil {
-		panic(err)
+		return err
+	}
+	tmp := f.Name()
+	err = writePSKFile(f, b.Bytes())
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil {
+		err = os.Rename(tmp, path)
 	}
-	file, err := os.Open(filepath.Join(dir, privNetPSKFile))
 	if err != nil {
-		panic(err)
+		os.Remove(tmp)
 	}
-	psk, err := pnet.DecodeV1PSK(file)
+	return err
+}
+
+// writePSKFile restricts the permissions of a new file and writes
+// an encoded PSK to it
+func writePSKFile(f *os.File, b []byte) error {
+	if err := f.Chmod(0600); err != nil {
+		return err
+	}
+	if _, err := f.Write(b); err != nil {
+		return err
+	}
+	return f.Sync()
+}
+
+// loadPrivNetPSK reads the private network PSK of the source tree
+func loadPrivNetPSK() (pnet.PSK, error) {
+	dir, err := utils.GetProjectDir()
 	if err != nil {
-		panic(err)
+		return nil, err
+	}
+	return LoadPSK(filepath.Join(dir, privNetPSKFile))
+}
+
+// validPSK checks the length of a private network PSK
+func validPSK(psk pnet.PSK) error {
+	if len(psk) != pskLength {
+		return ErrInvalidPSK
 	}
-	return psk
+	return nil
 }
@@ -1,22 +1,32 @@
 package net
 
 import (
+	"bytes"
+	"encoding/base64"
+	"encoding/hex"
 	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
 	"testing"
 	"time"
 
-	"github.com/libp2p/go-libp2p"
 	peerstore "github.com/libp2p/go-libp2p-peerstore"
 )
 
 func TestPrivateNetwork(t *testing.T) {
-	psk := loadPrivNetPSK()
-	privNetOption := libp2p.PrivateNetwork(psk)
+	psk, err := loadPrivNetPSK()
+	if err != nil {
+		t.Log(err)
+		t.FailNow()
+	}
+	privNetOption := WithPrivateNetwork(psk)
 	// nodes 1 and 2 will belong to the private network
 	// node 3 wont
-	n1 := nodeFromPrivKey(*testKeys[0], privNetOption)
-	n2 := nodeFromPrivKey(*testKeys[1], privNetOption)
-	n3 := nodeFromPrivKey(*testKeys[2])
+	n1, _ := nodeFromPrivKey(*testKeys[0], privNetOption)
+	n2, _ := nodeFromPrivKey(*testKeys[1], privNetOption)
+	n3, _ := nodeFromPrivKey(*testKeys[2])
 
 	// Add addresses of each peer to the others
 	n1.host.Peerstore().AddAddrs(n2.ID(), n2.Addrs(), peerstore.PermanentAddrTTL)
@@ -58,3 +68,121 @@ func TestPrivateNetwork(t *testing.T) {
 		t.FailNow()
 	}
 }
+
+func TestPSK(t *testing.T) {
+	psk, err := GeneratePSK()
+	if err != nil {
+		t.Log(err)
+		t.FailNow()
+	}
+	other, _ := GeneratePSK()
+	if len(psk) != pskLength || bytes.Equal(psk, other) {
+		t.FailNow()
+	}
+
+	// reader
+	var b bytes.Buffer
+	if err := WritePSK(&b, psk); err != nil {
+		t.Log(err)
+		t.FailNow()
+	}
+	if !strings.HasPrefix(b.String(), pskHeader+"\n"+pskEncodingBase16+"\n") {
+		t.FailNow()
+	}
+	if read, err := ReadPSK(&b); err != nil || !bytes.Equal(read, psk) {
+		t.Log(err)
+		t.FailNow()
+	}
+	if err := WritePSK(&b, psk[1:]); err != ErrInvalidPSK {
+		t.FailNow()
+	}
+
+	// file
+	dir, err := ioutil.TempDir("", "nahs-psk")
+	if err != nil {
+		t.Log(err)
+		t.FailNow()
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "config", "private_network.psk")
+	if err := WritePSKFile(path, psk); err != nil {
+		t.Log(err)
+		t.FailNow()
+	}
+	if info, err := os.Stat(path); err != nil || info.Mode().Perm() != 0600 {
+		t.FailNow()
+	}
+	if read, err := LoadPSK(path); err != nil || !bytes.Equal(read, psk) {
+		t.Log(err)
+		t.FailNow()
+	}
+	if _, err := LoadPSK(filepath.Join(dir, "missing.psk")); err == nil {
+		t.FailNow()
+	}
+	// existing files are replaced with one only readable by the owner
+	if err := os.Chmod(path, 0644); err != nil {
+		t.Log(err)
+		t.FailNow()
+	}
+	if err := WritePSKFile(path, other); err != nil {
+		t.Log(err)
+		t.FailNow()
+	}
+	if info, err := os.Stat(path); err != nil || info.Mode().Perm() != 0600 {
+		t.FailNow()
+	}
+	if files, _ := ioutil.ReadDir(filepath.Dir(path)); len(files) != 1 {
+		t.FailNow()
+	}
+	// keys of other lengths are refused
+	short := fmt.Sprintf("%s\n%s\n%s", pskHeader, pskEncodingBase16, hex.EncodeToString(psk[1:]))
+	if err := ioutil.WriteFile(path, []byte(short), 0600); err != nil {
+		t.Log(err)
+		t.FailNow()
+	}
+	if _, err := LoadPSK(path); err != ErrInvalidPSK {
+		t.Log(err)
+		t.FailNow()
+	}
+	long := fmt.Sprintf("%s\n%s\n%s", pskHeader, pskEncodingBase16, hex.EncodeToString(append(psk, 0)))
+	if _, err := ReadPSK(strings.NewReader(long)); err != ErrInvalidPSK {
+		t.FailNow()
+	}
+	// other encodings
+	for _, encoded := range []string{
+		fmt.Sprintf("%s\n%s\n%s\n", pskHeader, pskEncodingBase64, base64.StdEncoding.EncodeToString(psk)),
+		fmt.Sprintf("%s\n%s\n%s", pskHeader, pskEncodingBin, psk),
+	} {
+		if read, err := ReadPSK(strings.NewReader(encoded)); err != nil || !bytes.Equal(read, psk) {
+			t.Log(err)
+			t.FailNow()
+		}
+	}
+	if _, err := ReadPSK(strings.NewReader(pskHeader + "\n/base32/\n")); err == nil {
+		t.FailNow()
+	}
+
+	// environment, in the V1 format or only the key
+	defer os.Unsetenv(PSKEnvKey)
+	b.Reset()
+	WritePSK(&b, psk)
+	for _, value := range []string{b.String(), hex.EncodeToString(psk)} {
+		os.Setenv(PSKEnvKey, value)
+		if read, err := LoadPSKFromEnv(""); err != nil || !bytes.Equal(read, psk) {
+			t.Log(err)
+			t.FailNow()
+		}
+	}
+	os.Setenv(PSKEnvKey, hex.EncodeToString(psk[1:]))
+	if _, err := LoadPSKFromEnv(PSKEnvKey); err != ErrInvalidPSK {
+		t.FailNow()
+	}
+	os.Unsetenv(PSKEnvKey)
+	if _, err := LoadPSKFromEnv(""); err == nil {
+		t.FailNow()
+	}
+
+	if _, err := applyOptions(WithPrivateNetwork(psk[1:])); err != ErrInvalidPSK {
+		t.FailNow()
+	}
+}
@@ -0,0 +1,161 @@
+package net
+
+import (
+	"context"
+	"sync"
+
+	"github.com/libp2p/go-libp2p-core/peer"
+	"github.com/mikelsr/bspl"
+	"github.com/mikelsr/nahs/events"
+)
+
+// Publish sends an event to every participant of its instance
+// concurrently. Participants are the peers bound to the roles of
+// the instance; roles bound to something other than a peer ID are
+// resolved to the first contact found for them with FindContact
+// unless the node plays them. Once the instance is open the peers
+// recorded for its roles are used instead. The result of sending
+// the event to each participant is returned, nil meaning the
+// participant accepted it. If any participant failed the error is
+// an ErrPeers with the failures.
+//
+// Publishing a NewEvent opens the instance in the node too, owned by
+// it and with the participants bound to their roles, so they can
+// send it events for the instance. Publishing a DropEvent closes it.
+func (n *Node) Publish(ctx context.Context, event events.Event) (map[peer.ID]error, error) {
+	instance, err := n.eventInstance(event)
+	if err != nil {
+		return nil, err
+	}
+	// new events bind the roles of their instance anew
+	ownership, open := n.openInstances.Get(instance.Key())
+	if _, isNew := event.(events.NewEvent); isNew || !open {
+		ownership = n.ownership(instance)
+	}
+	participants := n.participants(ownership)
+	if len(participants) == 0 {
+		return nil, ErrNoParticipants
+	}
+	n.trackInstance(event, ownership)
+
+	results := make(map[peer.ID]error, len(participants))
+	var mutex sync.Mutex
+	var wg sync.WaitGroup
+	for _, id := range participants {
+		wg.Add(1)
+		go func(id peer.ID) {
+			defer wg.Done()
+			err := n.SendEvent(ctx, id, event)
+			mutex.Lock()
+			defer mutex.Unlock()
+			results[id] = err
+		}(id)
+	}
+	wg.Wait()
+
+	failed := make(ErrPeers)
+	for id, err := range results {
+		if err != nil {
+			failed[id] = err
+		}
+	}
+	if len(failed) > 0 {
+		return results, failed
+	}
+	return results, nil
+}
+
+// TrackInstance updates the instances open in the node with an event
+// it sends: a NewEvent opens its instance, owned by the node and with
+// its roles bound as in Publish, so the participants can send it
+// events for the instance, and a DropEvent closes it. Publish tracks
+// the events it sends, events sent to a single peer with SendEvent
+// must be tracked by the caller.
+func (n *Node) TrackInstance(event events.Event) {
+	if e, ok := event.(events.NewEvent); ok {
+		n.trackInstance(event, n.ownership(e.Instance()))
+		return
+	}
+	n.trackInstance(event, Ownership{})
+}
+
+// trackInstance opens the instance of a NewEvent with an Ownership
+// or closes the instance of a DropEvent
+func (n *Node) trackInstance(event events.Event, ownership Ownership) {
+	switch event.(type) {
+	case events.NewEvent:
+		n.openInstances.PutIfAbsent(event.InstanceKey(), ownership)
+	case events.DropEvent:
+		n.openInstances.Delete(event.InstanceKey())
+	}
+}
+
+// eventInstance returns the instance of an event. Events that
+// don't carry it are looked up in the reasoner.
+func (n *Node) eventInstance(event events.Event) (bspl.Instance, error) {
+	switch e := event.(type) {
+	case events.NewEvent:
+		return e.Instance(), nil
+	case events.UpdateEvent:
+		return e.Instance(), nil
+	}
+	instance, found := n.reasoner.GetInstance(event.InstanceKey())
+	if !found {
+		return nil, ErrInstanceUnknown
+	}
+	return instance, nil
+}
+
+// ownership returns the Ownership of an instance created by the
+// node. The roles it plays are bound to it and the other roles not
+// bound to a peer ID to the first contact found playing them.
+func (n *Node) ownership(instance bspl.Instance) Ownership {
+	protocolKey := instance.Protocol().Key()
+	n.protocolsMutex.RLock()
+	played := n.roles[protocolKey]
+	n.protocolsMutex.RUnlock()
+
+	o := makeOwnership(n.ID(), instance.Roles())
+	for role := range instance.Roles() {
+		if _, bound := o.Roles[role]; bound {
+			continue
+		}
+		if playsRole(played, role) {
+			o.Roles[role] = n.ID()
+			continue
+		}
+		ids := n.FindContact(protocolKey, role)
+		if len(ids) == 0 {
+			logger.Warningf("No contact found for role '%s' of '%s'", role, instance.Key())
+			continue
+		}
+		o.Roles[role] = ids[0]
+	}
+	return o
+}
+
+// participants returns the peers that participate in an instance,
+// excluding the node itself
+func (n *Node) participants(o Ownership) []peer.ID {
+	found := map[peer.ID]bool{o.Creator: true}
+	for _, id := range o.Roles {
+		found[id] = true
+	}
+	delete(found, n.ID())
+
+	participants := make([]peer.ID, 0, len(found))
+	for id := range found {
+		participants = append(participants, id)
+	}
+	return participants
+}
+
+// playsRole checks if a role is in a list of roles
+func playsRole(roles []bspl.Role, role bspl.Role) bool {
+	for _, r := range roles {
+		if r == role {
+			return true
+		}
+	}
+	return false
+}
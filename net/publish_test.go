@@ -0,0 +1,108 @@
+package net
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/mikelsr/bspl"
+	imp "github.com/mikelsr/bspl/implementation"
+	"github.com/mikelsr/bspl/proto"
+	"github.com/mikelsr/nahs/events"
+)
+
+func TestNode_Publish(t *testing.T) {
+	n := testNodes(3)
+	for _, node := range n {
+		node.reasoner = mockReasoner{}
+		defer node.Close(context.Background())
+	}
+	n1, n2, n3 := n[0], n[1], n[2]
+	ctx := context.Background()
+	buyer, seller := proto.Role("Buyer"), proto.Role("Seller")
+
+	// roles bound to peers
+	bound := testInstance()
+	bound.Roles()[buyer] = n1.ID().Pretty()
+	bound.Roles()[seller] = n2.ID().Pretty()
+	results, err := n1.Publish(ctx, events.MakeNewEvent(bound))
+	if err != nil || len(results) != 1 || results[n2.ID()] != nil {
+		t.Log(err)
+		t.FailNow()
+	}
+	if _, found := n2.OpenInstances().Get(bound.Key()); !found {
+		t.FailNow()
+	}
+	// the publisher owns the instance
+	if o, found := n1.OpenInstances().Get(bound.Key()); !found || o.Creator != n1.ID() {
+		t.FailNow()
+	}
+
+	// failures are reported per peer
+	results, err = n1.Publish(ctx, events.MakeNewEvent(bound))
+	var errPeers ErrPeers
+	var errExists ErrInstanceExists
+	if !errors.As(err, &errPeers) || len(errPeers) != 1 || !errors.As(results[n2.ID()], &errExists) {
+		t.Log(err)
+		t.FailNow()
+	}
+
+	// roles not bound to peers are resolved with the contacts
+	// of the node, except the ones it plays
+	n1.AddProtocol(testProtocol(), buyer)
+	n1.AddContact(n3.ID(), Service{Protocol: testProtocol(), Roles: []bspl.Role{seller}})
+	results, err = n1.Publish(ctx, events.MakeNewEvent(testInstance()))
+	if err != nil || len(results) != 1 || results[n3.ID()] != nil {
+		t.Log(err)
+		t.FailNow()
+	}
+
+	// events without an instance are looked up in the reasoner
+	other := imp.NewInstance(testProtocol(), testInstance().Roles())
+	other.SetValue("ID", "other")
+	if _, err := n1.Publish(ctx, events.MakeDropEvent(other.Key(), "_")); err != ErrInstanceUnknown {
+		t.FailNow()
+	}
+	// the node doesn't publish to itself
+	own := testInstance()
+	own.Roles()[buyer] = n1.ID().Pretty()
+	own.Roles()[seller] = n1.ID().Pretty()
+	if _, err := n1.Publish(ctx, events.MakeNewEvent(own)); err != ErrNoParticipants {
+		t.FailNow()
+	}
+}
+
+func TestNode_PublishResolved(t *testing.T) {
+	n := testNodes(2)
+	for _, node := range n {
+		defer node.Close(context.Background())
+	}
+	n1, n2 := n[0], n[1]
+	n1.reasoner = identifiedReasoner{}
+	n2.reasoner = mockReasoner{}
+	ctx := context.Background()
+	buyer, seller := proto.Role("Buyer"), proto.Role("Seller")
+
+	// the seller resolves the buyer with its contacts
+	n1.AddProtocol(testProtocol(), seller)
+	n1.AddContact(n2.ID(), Service{Protocol: testProtocol(), Roles: []bspl.Role{buyer}})
+	identified, _ := n1.reasoner.GetInstance(testInstance().Key())
+	if _, err := n1.Publish(ctx, events.MakeNewEvent(identified)); err != nil {
+		t.Log(err)
+		t.FailNow()
+	}
+	o, found := n1.OpenInstances().Get(identified.Key())
+	if !found || !o.Plays(n2.ID(), buyer) || !o.Plays(n1.ID(), seller) {
+		t.Log(o)
+		t.FailNow()
+	}
+
+	// so the buyer can send it a request
+	requested := imp.NewInstance(testProtocol(), testInstance().Roles())
+	requested.SetValue("ID", "X")
+	requested.SetValue("item", "X")
+	if err := n2.SendEvent(ctx, n1.ID(), events.MakeUpdateEvent(requested)); err != nil {
+		t.Log(err)
+		t.FailNow()
+	}
+}
@@ -0,0 +1,306 @@
+package net
+
+import (
+	"sync"
+	"time"
+
+	"github.com/libp2p/go-libp2p-core/peer"
+)
+
+// ContactRegistry is a concurrency-safe registry of the
+// Contacts of a Node and when they were last seen
+type ContactRegistry struct {
+	mutex    sync.RWMutex
+	contacts Contacts
+	// seen is when each contact was last seen
+	seen map[peer.ID]time.Time
+	// exchanges is when protocols were last exchanged with each
+	// peer, contact or not
+	exchanges map[peer.ID]time.Time
+	// store changes are written to, may be nil
+	store Store
+}
+
+// newContactRegistry is the default constructor for ContactRegistry.
+// If store is not nil the registry is loaded from it and every
+// change is written through to it. Loaded contacts are seen when
+// they are loaded.
+func newContactRegistry(store Store) (*ContactRegistry, error) {
+	r := &ContactRegistry{contacts: make(Contacts), seen: make(map[peer.ID]time.Time),
+		exchanges: make(map[peer.ID]time.Time), store: store}
+	if store == nil {
+		return r, nil
+	}
+	contacts, err := store.LoadContacts()
+	if err != nil {
+		return nil, err
+	}
+	r.contacts = contacts
+	now := time.Now()
+	for id := range contacts {
+		r.seen[id] = now
+	}
+	return r, nil
+}
+
+// Get returns a copy of the Services offered by a contact
+func (r *ContactRegistry) Get(id peer.ID) (Services, bool) {
+	r.mutex.RLock()
+	defer r.mutex.RUnlock()
+	services, found := r.contacts[id]
+	if !found {
+		return nil, false
+	}
+	return services.copy(), true
+}
+
+// Put sets the Services offered by a contact, replacing the
+// ones that were previously known. The contact is seen.
+func (r *ContactRegistry) Put(id peer.ID, services Services) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	r.contacts[id] = services.copy()
+	r.seen[id] = time.Now()
+	r.persist(id)
+}
+
+// LastSeen returns when a contact was last seen
+func (r *ContactRegistry) LastSeen(id peer.ID) (time.Time, bool) {
+	r.mutex.RLock()
+	defer r.mutex.RUnlock()
+	seen, found := r.seen[id]
+	return seen, found
+}
+
+// touch marks a contact as seen if it exists
+func (r *ContactRegistry) touch(id peer.ID) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	if _, found := r.contacts[id]; found {
+		r.seen[id] = time.Now()
+	}
+}
+
+// markExchanged records that protocols were exchanged with a peer
+func (r *ContactRegistry) markExchanged(id peer.ID) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	r.exchanges[id] = time.Now()
+}
+
+// exchangedWithin reports whether protocols were exchanged with a
+// peer within d
+func (r *ContactRegistry) exchangedWithin(id peer.ID, d time.Duration) bool {
+	r.mutex.RLock()
+	defer r.mutex.RUnlock()
+	exchanged, found := r.exchanges[id]
+	return found && time.Since(exchanged) < d
+}
+
+// forgetExchanges forgets when protocols were exchanged with every
+// peer, so they are exchanged again
+func (r *ContactRegistry) forgetExchanges() {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	r.exchanges = make(map[peer.ID]time.Time)
+}
+
+// expire removes the contacts not seen within ttl and returns them.
+// The exchanges before ttl are forgotten too.
+func (r *ContactRegistry) expire(ttl time.Duration) []peer.ID {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	limit := time.Now().Add(-ttl)
+	for id, exchanged := range r.exchanges {
+		if exchanged.Before(limit) {
+			delete(r.exchanges, id)
+		}
+	}
+	expired := make([]peer.ID, 0)
+	for id, seen := range r.seen {
+		if seen.Before(limit) {
+			expired = append(expired, id)
+			r.delete(id)
+		}
+	}
+	return expired
+}
+
+// Delete removes a contact
+func (r *ContactRegistry) Delete(id peer.ID) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	r.delete(id)
+}
+
+// delete removes a contact. The registry must be locked.
+func (r *ContactRegistry) delete(id peer.ID) {
+	delete(r.contacts, id)
+	delete(r.seen, id)
+	delete(r.exchanges, id)
+	if r.store != nil {
+		if err := r.store.DeleteContact(id); err != nil {
+			logger.Errorf("Could not delete contact '%s' from store: %s", id, err)
+		}
+	}
+}
+
+// Len returns the number of contacts
+func (r *ContactRegistry) Len() int {
+	r.mutex.RLock()
+	defer r.mutex.RUnlock()
+	return len(r.contacts)
+}
+
+// Range calls f for each contact until f returns false. f is
+// called over a snapshot of the registry so it may modify it.
+func (r *ContactRegistry) Range(f func(id peer.ID, services Services) bool) {
+	for id, services := range r.Snapshot() {
+		if !f(id, services) {
+			return
+		}
+	}
+}
+
+// Snapshot returns a copy of the Contacts in the registry
+func (r *ContactRegistry) Snapshot() Contacts {
+	r.mutex.RLock()
+	defer r.mutex.RUnlock()
+	contacts := make(Contacts, len(r.contacts))
+	for id, services := range r.contacts {
+		contacts[id] = services.copy()
+	}
+	return contacts
+}
+
+// addServices adds services to a contact, creating it if
+// needed. Services with the same protocol key are overwritten.
+// The contact is seen.
+func (r *ContactRegistry) addServices(id peer.ID, services ...Service) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	servs, found := r.contacts[id]
+	if !found {
+		servs = make(Services)
+		r.contacts[id] = servs
+	}
+	r.seen[id] = time.Now()
+	for _, s := range services {
+		servs[s.Protocol.Key()] = s
+	}
+	r.persist(id)
+}
+
+// persist writes a contact to the store. The registry must be locked.
+func (r *ContactRegistry) persist(id peer.ID) {
+	if r.store == nil {
+		return
+	}
+	if err := r.store.PutContact(id, r.contacts[id]); err != nil {
+		logger.Errorf("Could not write contact '%s' to store: %s", id, err)
+	}
+}
+
+// InstanceRegistry is a concurrency-safe registry mapping
+// instance keys to the Ownership of the instance
+type InstanceRegistry struct {
+	mutex     sync.RWMutex
+	instances map[string]Ownership
+	// store changes are written to, may be nil
+	store Store
+}
+
+// newInstanceRegistry is the default constructor for InstanceRegistry.
+// If store is not nil the registry is loaded from it and every
+// change is written through to it.
+func newInstanceRegistry(store Store) (*InstanceRegistry, error) {
+	r := &InstanceRegistry{instances: make(map[string]Ownership), store: store}
+	if store == nil {
+		return r, nil
+	}
+	instances, err := store.LoadInstances()
+	if err != nil {
+		return nil, err
+	}
+	r.instances = instances
+	return r, nil
+}
+
+// Get returns the Ownership of an instance
+func (r *InstanceRegistry) Get(instanceKey string) (Ownership, bool) {
+	r.mutex.RLock()
+	defer r.mutex.RUnlock()
+	o, found := r.instances[instanceKey]
+	return o, found
+}
+
+// Put sets the Ownership of an instance
+func (r *InstanceRegistry) Put(instanceKey string, o Ownership) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	r.instances[instanceKey] = o
+	r.persist(instanceKey)
+}
+
+// PutIfAbsent sets the Ownership of an instance only if the
+// instance wasn't open. It returns false if it was.
+func (r *InstanceRegistry) PutIfAbsent(instanceKey string, o Ownership) bool {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	if _, found := r.instances[instanceKey]; found {
+		return false
+	}
+	r.instances[instanceKey] = o
+	r.persist(instanceKey)
+	return true
+}
+
+// Delete removes an instance
+func (r *InstanceRegistry) Delete(instanceKey string) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	delete(r.instances, instanceKey)
+	if r.store != nil {
+		if err := r.store.DeleteInstance(instanceKey); err != nil {
+			logger.Errorf("Could not delete instance '%s' from store: %s", instanceKey, err)
+		}
+	}
+}
+
+// Len returns the number of instances
+func (r *InstanceRegistry) Len() int {
+	r.mutex.RLock()
+	defer r.mutex.RUnlock()
+	return len(r.instances)
+}
+
+// Range calls f for each instance until f returns false. f is
+// called over a snapshot of the registry so it may modify it.
+func (r *InstanceRegistry) Range(f func(instanceKey string, o Ownership) bool) {
+	for key, o := range r.Snapshot() {
+		if !f(key, o) {
+			return
+		}
+	}
+}
+
+// Snapshot returns a copy of the instances in the registry
+func (r *InstanceRegistry) Snapshot() map[string]Ownership {
+	r.mutex.RLock()
+	defer r.mutex.RUnlock()
+	instances := make(map[string]Ownership, len(r.instances))
+	for key, o := range r.instances {
+		instances[key] = o
+	}
+	return instances
+}
+
+// persist writes an instance to the store. The registry must be locked.
+func (r *InstanceRegistry) persist(instanceKey string) {
+	if r.store == nil {
+		return
+	}
+	if err := r.store.PutInstance(instanceKey, r.instances[instanceKey]); err != nil {
+		logger.Errorf("Could not write instance '%s' to store: %s", instanceKey, err)
+	}
+}
@@ -0,0 +1,200 @@
+package net
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/libp2p/go-libp2p-core/peer"
+	"github.com/mikelsr/bspl"
+	imp "github.com/mikelsr/bspl/implementation"
+	"github.com/mikelsr/bspl/proto"
+	"github.com/mikelsr/nahs/events"
+)
+
+func TestContactRegistry(t *testing.T) {
+	r, _ := newContactRegistry(nil)
+	id := peer.ID("contact")
+	p := testProtocol()
+	r.addServices(id, Service{Roles: []bspl.Role{"Buyer"}, Protocol: p})
+
+	services, found := r.Get(id)
+	if !found || len(services) != 1 {
+		t.FailNow()
+	}
+	// modifying a returned copy must not modify the registry
+	delete(services, p.Key())
+	if services, _ := r.Get(id); len(services) != 1 {
+		t.FailNow()
+	}
+	r.Put(id, Services{})
+	if services, _ := r.Get(id); len(services) != 0 {
+		t.FailNow()
+	}
+	if len(r.Snapshot()) != 1 || r.Len() != 1 {
+		t.FailNow()
+	}
+	r.Delete(id)
+	if _, found := r.Get(id); found || r.Len() != 0 {
+		t.FailNow()
+	}
+}
+
+func TestContactRegistry_expire(t *testing.T) {
+	r, _ := newContactRegistry(nil)
+	old, recent := peer.ID("old"), peer.ID("recent")
+	r.Put(old, Services{})
+	r.Put(recent, Services{})
+	if seen, found := r.LastSeen(old); !found || time.Since(seen) > time.Second {
+		t.FailNow()
+	}
+	r.mutex.Lock()
+	r.seen[old] = time.Now().Add(-time.Hour)
+	r.seen[recent] = time.Now().Add(-time.Hour)
+	r.mutex.Unlock()
+
+	// touching a contact marks it as seen, unknown peers are ignored
+	r.touch(recent)
+	r.touch(peer.ID("unknown"))
+	expired := r.expire(time.Minute)
+	if len(expired) != 1 || expired[0] != old || r.Len() != 1 {
+		t.FailNow()
+	}
+	if _, found := r.LastSeen(old); found {
+		t.FailNow()
+	}
+
+	// exchanges are forgotten after ttl too
+	r.markExchanged(old)
+	r.markExchanged(recent)
+	if !r.exchangedWithin(old, time.Minute) || r.exchangedWithin(peer.ID("unknown"), time.Minute) {
+		t.FailNow()
+	}
+	r.mutex.Lock()
+	r.exchanges[old] = time.Now().Add(-time.Hour)
+	r.mutex.Unlock()
+	r.expire(time.Minute)
+	if _, found := r.exchanges[old]; found || !r.exchangedWithin(recent, time.Minute) {
+		t.FailNow()
+	}
+	r.forgetExchanges()
+	if r.exchangedWithin(recent, time.Minute) {
+		t.FailNow()
+	}
+}
+
+func TestInstanceRegistry(t *testing.T) {
+	r, _ := newInstanceRegistry(nil)
+	a, b := Ownership{Creator: peer.ID("a")}, Ownership{Creator: peer.ID("b")}
+	if !r.PutIfAbsent("key", a) || r.PutIfAbsent("key", b) {
+		t.FailNow()
+	}
+	if o, found := r.Get("key"); !found || o.Creator != a.Creator {
+		t.FailNow()
+	}
+	r.Put("key", b)
+	if o, _ := r.Get("key"); o.Creator != b.Creator {
+		t.FailNow()
+	}
+	// Range over a snapshot may modify the registry
+	r.Range(func(key string, o Ownership) bool {
+		r.Delete(key)
+		return true
+	})
+	if r.Len() != 0 || len(r.Snapshot()) != 0 {
+		t.FailNow()
+	}
+}
+
+func TestRegistriesConcurrency(t *testing.T) {
+	contacts, _ := newContactRegistry(nil)
+	instances, _ := newInstanceRegistry(nil)
+	p := testProtocol()
+	workers, ops := 16, 200
+
+	var wg sync.WaitGroup
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func(w int) {
+			defer wg.Done()
+			for i := 0; i < ops; i++ {
+				id := peer.ID(fmt.Sprintf("peer-%d", i%10))
+				key := fmt.Sprintf("instance-%d", i%10)
+				switch (w + i) % 4 {
+				case 0:
+					contacts.addServices(id, Service{Protocol: p})
+					instances.PutIfAbsent(key, Ownership{Creator: id})
+				case 1:
+					contacts.Get(id)
+					instances.Get(key)
+				case 2:
+					contacts.Range(func(peer.ID, Services) bool { return true })
+					instances.Range(func(string, Ownership) bool { return true })
+				case 3:
+					contacts.Delete(id)
+					instances.Delete(key)
+				}
+			}
+		}(w)
+	}
+	wg.Wait()
+}
+
+// registeringReasoner registers every instance
+type registeringReasoner struct {
+	mockReasoner
+}
+
+func (r registeringReasoner) RegisterInstance(i bspl.Instance) error {
+	return nil
+}
+
+// TestNodeConcurrency hammers the discovery and event handlers of
+// a node from several peers at once. Run with -race.
+func TestNodeConcurrency(t *testing.T) {
+	nodes := testNodes(testNodeN)
+	target, peers := nodes[0], nodes[1:]
+	for _, node := range nodes {
+		node.reasoner = registeringReasoner{}
+		defer node.Close(context.Background())
+	}
+	target.AddProtocol(tp1, tp1.Roles...)
+	rounds := 5
+
+	var wg sync.WaitGroup
+	for i, node := range peers {
+		node.AddProtocol(tp2, tp2.Roles...)
+		wg.Add(2)
+		go func(node *Node) {
+			defer wg.Done()
+			for r := 0; r < rounds; r++ {
+				if err := node.discoverPeer(context.Background(), target.ID()); err != nil {
+					t.Error(err)
+				}
+			}
+		}(node)
+		go func(i int, node *Node) {
+			defer wg.Done()
+			for r := 0; r < rounds; r++ {
+				instance := imp.NewInstance(testProtocol(), imp.Roles{
+					proto.Role("Buyer"):  "B",
+					proto.Role("Seller"): "S",
+				})
+				instance.SetValue("ID", fmt.Sprintf("%d-%d", i, r))
+				if err := node.SendEvent(context.Background(), target.ID(), events.MakeNewEvent(instance)); err != nil {
+					t.Error(err)
+				}
+			}
+		}(i, node)
+	}
+	wg.Wait()
+
+	if target.Contacts().Len() != len(peers) {
+		t.FailNow()
+	}
+	if target.OpenInstances().Len() != len(peers)*rounds {
+		t.FailNow()
+	}
+}
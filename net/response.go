@@ -0,0 +1,112 @@
+package net
+
+import (
+	"encoding/json"
+	"errors"
+)
+
+// EventStatus is the result of handling an event
+type EventStatus int
+
+const (
+	// StatusOK the event was run successfully
+	StatusOK EventStatus = iota
+	// StatusUnknown the result of the event is unknown, used
+	// for responses of nodes using the legacy exchange
+	StatusUnknown
+	// StatusInvalidEvent the event could not be parsed
+	StatusInvalidEvent
+	// StatusInstanceNotFound the instance of the event is not open
+	StatusInstanceNotFound
+	// StatusUnauthorized the sender is not allowed to send the event
+	StatusUnauthorized
+	// StatusInstanceExists the instance of a new event was already open
+	StatusInstanceExists
+	// StatusRejected the reasoner rejected the event
+	StatusRejected
+	// StatusBadSignature the event wasn't signed or its
+	// signature could not be verified
+	StatusBadSignature
+	// StatusOutOfOrder the event arrived before its predecessors
+	// and could not be held until they arrived
+	StatusOutOfOrder
+)
+
+func (s EventStatus) String() string {
+	switch s {
+	case StatusOK:
+		return "ok"
+	case StatusInvalidEvent:
+		return "invalid event"
+	case StatusInstanceNotFound:
+		return "instance not found"
+	case StatusUnauthorized:
+		return "unauthorized"
+	case StatusInstanceExists:
+		return "instance exists"
+	case StatusRejected:
+		return "rejected"
+	case StatusBadSignature:
+		return "bad signature"
+	case StatusOutOfOrder:
+		return "out of order"
+	}
+	return "unknown"
+}
+
+// EventResponse is sent back to the sender of an event
+type EventResponse struct {
+	// EventID is the ID of the event
+	EventID string `json:"event_id"`
+	// Status of the event
+	Status EventStatus `json:"status"`
+	// Reason the event was not run
+	Reason string `json:"reason,omitempty"`
+	// ReasonerError is the error returned by the reasoner
+	// when it rejected the event
+	ReasonerError string `json:"reasoner_error,omitempty"`
+}
+
+// makeEventResponse builds the response to an event given the
+// error returned when running it
+func makeEventResponse(id string, err error) EventResponse {
+	if err == nil {
+		return EventResponse{EventID: id, Status: StatusOK}
+	}
+	var e ErrHandleEvent
+	if errors.As(err, &e) {
+		return EventResponse{
+			EventID:       e.ID,
+			Status:        e.Status,
+			Reason:        e.Reason,
+			ReasonerError: e.ReasonerError,
+		}
+	}
+	return EventResponse{EventID: id, Status: StatusUnknown, Reason: err.Error()}
+}
+
+// Err returns the error described by the response, nil if the
+// event was run successfully
+func (r EventResponse) Err() error {
+	if r.Status == StatusOK {
+		return nil
+	}
+	return newErrHandleEvent(ErrHandleEvent{
+		ID:            r.EventID,
+		Status:        r.Status,
+		Reason:        r.Reason,
+		ReasonerError: r.ReasonerError,
+	})
+}
+
+// marshalEventResponse marshals an EventResponse to bytes
+func marshalEventResponse(r EventResponse) ([]byte, error) {
+	return json.Marshal(r)
+}
+
+// unmarshalEventResponse unmarshals an EventResponse from bytes
+func unmarshalEventResponse(data []byte) (EventResponse, error) {
+	var r EventResponse
+	err := json.Unmarshal(data, &r)
+	return r, err
+}
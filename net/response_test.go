@@ -0,0 +1,30 @@
+package net
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestEventResponse(t *testing.T) {
+	var err error = ErrUnauthorized{ErrHandleEvent{ID: "id", Status: StatusUnauthorized, Reason: "Unauthorized"}}
+	response := makeEventResponse("id", err)
+	b, _ := marshalEventResponse(response)
+	unmarshalled, err := unmarshalEventResponse(b)
+	if err != nil || unmarshalled != response {
+		t.FailNow()
+	}
+	var errUnauthorized ErrUnauthorized
+	if !errors.As(unmarshalled.Err(), &errUnauthorized) || errUnauthorized.Reason != "Unauthorized" {
+		t.FailNow()
+	}
+
+	if makeEventResponse("id", nil).Err() != nil {
+		t.FailNow()
+	}
+	// errors unrelated to events have an unknown status
+	response = makeEventResponse("id", errMock)
+	var errHandle ErrHandleEvent
+	if response.Status != StatusUnknown || !errors.As(response.Err(), &errHandle) {
+		t.FailNow()
+	}
+}
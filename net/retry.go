@@ -0,0 +1,60 @@
+package net
+
+import (
+	"context"
+	"errors"
+	"time"
+)
+
+// retryPolicy establishes how many times an operation is tried
+// and how long to wait between tries
+type retryPolicy struct {
+	// attempts is the maximum number of tries
+	attempts int
+	// backoff is the wait before the first retry, doubled
+	// after each one up to maxBackoff
+	backoff    time.Duration
+	maxBackoff time.Duration
+}
+
+// do runs f until it succeeds, it fails with an error that is not
+// transient, the attempts are exhausted or ctx is done. The error
+// of the last try is returned.
+func (p retryPolicy) do(ctx context.Context, f func() error) error {
+	backoff := p.backoff
+	for attempt := 1; ; attempt++ {
+		err := f()
+		if err == nil || !isTransient(err) || attempt >= p.attempts {
+			return err
+		}
+		logger.Debugf("Retrying in %s after attempt %d failed: %s", backoff, attempt, err)
+		timer := time.NewTimer(backoff)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return err
+		case <-timer.C:
+		}
+		if backoff *= 2; backoff > p.maxBackoff {
+			backoff = p.maxBackoff
+		}
+	}
+}
+
+// isTransient reports whether an error sending an event may go
+// away by trying again, e.g. the peer is unreachable or the stream
+// was reset. Events the peer handled and rejected are not retried
+// unless they arrived out of order.
+func isTransient(err error) bool {
+	var errOutOfOrder ErrOutOfOrder
+	var errHandle ErrHandleEvent
+	switch {
+	case errors.As(err, &errOutOfOrder):
+		return true
+	case errors.As(err, &errHandle):
+		return false
+	case errors.Is(err, ErrFrameTooLarge), errors.Is(err, ErrFrameVersion):
+		return false
+	}
+	return true
+}
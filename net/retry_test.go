@@ -0,0 +1,130 @@
+package net
+
+import (
+	"context"
+	"errors"
+	"io"
+	"testing"
+	"time"
+
+	"github.com/libp2p/go-libp2p-core/network"
+	"github.com/libp2p/go-libp2p-core/peer"
+	"github.com/mikelsr/nahs/events"
+)
+
+func TestIsTransient(t *testing.T) {
+	testCases := []struct {
+		err       error
+		transient bool
+	}{
+		{io.EOF, true},
+		{context.DeadlineExceeded, true},
+		{ErrOutOfOrder{ErrHandleEvent{Status: StatusOutOfOrder}}, true},
+		{ErrUnauthorized{ErrHandleEvent{Status: StatusUnauthorized}}, false},
+		{ErrHandleEvent{Status: StatusUnknown}, false},
+		{ErrFrameTooLarge, false},
+	}
+	for _, tc := range testCases {
+		if isTransient(tc.err) != tc.transient {
+			t.Log(tc.err)
+			t.FailNow()
+		}
+	}
+}
+
+func TestRetryPolicy(t *testing.T) {
+	p := retryPolicy{attempts: 3, backoff: time.Millisecond, maxBackoff: 2 * time.Millisecond}
+	tries := 0
+	transient := func() error {
+		tries++
+		return io.EOF
+	}
+	if err := p.do(context.Background(), transient); err != io.EOF || tries != 3 {
+		t.FailNow()
+	}
+	// errors that are not transient are not retried
+	tries = 0
+	rejected := func() error {
+		tries++
+		return ErrUnauthorized{ErrHandleEvent{Status: StatusUnauthorized}}
+	}
+	if err := p.do(context.Background(), rejected); err == nil || tries != 1 {
+		t.FailNow()
+	}
+	// retries stop when the context is done
+	tries = 0
+	p.backoff, p.maxBackoff = time.Hour, time.Hour
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	if err := p.do(ctx, transient); err != io.EOF || tries != 1 {
+		t.FailNow()
+	}
+}
+
+func TestNode_SendEventTimeout(t *testing.T) {
+	n1, _ := nodeFromPrivKey(*testKeys[0], WithRetry(2, 10*time.Millisecond, 10*time.Millisecond))
+	n2, _ := nodeFromPrivKey(*testKeys[1])
+	defer n1.Close(context.Background())
+	defer n2.Close(context.Background())
+	n1.host.Peerstore().AddAddrs(n2.ID(), n2.Addrs(), time.Hour)
+
+	// n2 never answers
+	hang := make(chan struct{})
+	defer close(hang)
+	n2.host.SetStreamHandler(protocolEventID, func(stream network.Stream) {
+		<-hang
+		stream.Reset()
+	})
+
+	ctx, cancel := context.WithTimeout(context.Background(), 200*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	err := n1.SendEvent(ctx, n2.ID(), events.MakeNewEvent(testInstance()))
+	if !errors.Is(err, context.DeadlineExceeded) || time.Since(start) > 2*time.Second {
+		t.Log(err)
+		t.FailNow()
+	}
+}
+
+func TestNode_outbox(t *testing.T) {
+	store := NewMemoryStore()
+	n1, err := nodeFromPrivKey(*testKeys[0], WithOutbox(store, time.Hour),
+		WithRetry(2, 10*time.Millisecond, 10*time.Millisecond))
+	if err != nil {
+		t.Log(err)
+		t.FailNow()
+	}
+	defer n1.Close(context.Background())
+
+	// the address of the target is unknown
+	target := testID(1)
+	err = n1.SendEvent(context.Background(), target, events.MakeNewEvent(testInstance()))
+	var errQueued ErrEventQueued
+	if !errors.As(err, &errQueued) {
+		t.Log(err)
+		t.FailNow()
+	}
+	if queued, _ := store.LoadOutbound(); len(queued) != 1 || queued[0].Target != target {
+		t.FailNow()
+	}
+
+	// the event is delivered once the target connects
+	n2, _ := nodeFromPrivKey(*testKeys[1])
+	n2.reasoner = mockReasoner{}
+	defer n2.Close(context.Background())
+	if err := n2.host.Connect(context.Background(), peer.AddrInfo{ID: n1.ID(), Addrs: n1.Addrs()}); err != nil {
+		t.Log(err)
+		t.FailNow()
+	}
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		queued, _ := store.LoadOutbound()
+		if len(queued) == 0 && n2.OpenInstances().Len() == 1 {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.FailNow()
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+}
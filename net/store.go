@@ -0,0 +1,183 @@
+package net
+
+import (
+	"encoding/json"
+	"sync"
+
+	"github.com/libp2p/go-libp2p-core/peer"
+)
+
+// Store persists what a Node learns so it can be recovered
+// after a restart: its contacts, the instances opened by other
+// peers and the protocols the node offers.
+type Store interface {
+	// LoadContacts returns all the stored contacts
+	LoadContacts() (Contacts, error)
+	// PutContact stores the services offered by a contact
+	PutContact(id peer.ID, services Services) error
+	// DeleteContact removes a contact
+	DeleteContact(id peer.ID) error
+	// LoadInstances returns all the stored open instances
+	LoadInstances() (map[string]Ownership, error)
+	// PutInstance stores the Ownership of an instance
+	PutInstance(instanceKey string, o Ownership) error
+	// DeleteInstance removes an instance
+	DeleteInstance(instanceKey string) error
+	// LoadProtocols returns the stored protocols offered by
+	// the node and the roles it plays in them
+	LoadProtocols() ([]Service, error)
+	// PutProtocol stores a protocol offered by the node and
+	// the roles it plays in it
+	PutProtocol(service Service) error
+}
+
+// MemoryStore is a Store and Outbox that keeps everything in
+// memory. It doesn't survive the process but may be shared by
+// the Nodes created by it.
+type MemoryStore struct {
+	mutex     sync.RWMutex
+	contacts  Contacts
+	instances map[string]Ownership
+	protocols Services
+	outbound  map[string]OutboundEvent
+}
+
+// NewMemoryStore is the default constructor for MemoryStore
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{
+		contacts:  make(Contacts),
+		instances: make(map[string]Ownership),
+		protocols: make(Services),
+		outbound:  make(map[string]OutboundEvent),
+	}
+}
+
+// LoadContacts returns all the stored contacts
+func (s *MemoryStore) LoadContacts() (Contacts, error) {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+	contacts := make(Contacts, len(s.contacts))
+	for id, services := range s.contacts {
+		contacts[id] = services.copy()
+	}
+	return contacts, nil
+}
+
+// PutContact stores the services offered by a contact
+func (s *MemoryStore) PutContact(id peer.ID, services Services) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.contacts[id] = services.copy()
+	return nil
+}
+
+// DeleteContact removes a contact
+func (s *MemoryStore) DeleteContact(id peer.ID) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	delete(s.contacts, id)
+	return nil
+}
+
+// LoadInstances returns all the stored open instances
+func (s *MemoryStore) LoadInstances() (map[string]Ownership, error) {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+	instances := make(map[string]Ownership, len(s.instances))
+	for key, o := range s.instances {
+		instances[key] = o
+	}
+	return instances, nil
+}
+
+// PutInstance stores the Ownership of an instance
+func (s *MemoryStore) PutInstance(instanceKey string, o Ownership) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.instances[instanceKey] = o
+	return nil
+}
+
+// DeleteInstance removes an instance
+func (s *MemoryStore) DeleteInstance(instanceKey string) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	delete(s.instances, instanceKey)
+	return nil
+}
+
+// LoadProtocols returns the stored protocols
+func (s *MemoryStore) LoadProtocols() ([]Service, error) {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+	services := make([]Service, 0, len(s.protocols))
+	for _, service := range s.protocols {
+		services = append(services, service)
+	}
+	return services, nil
+}
+
+// PutProtocol stores a protocol offered by the node and
+// the roles it plays in it
+func (s *MemoryStore) PutProtocol(service Service) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.protocols[service.Protocol.Key()] = service
+	return nil
+}
+
+// LoadOutbound returns all the queued events
+func (s *MemoryStore) LoadOutbound() ([]OutboundEvent, error) {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+	queued := make([]OutboundEvent, 0, len(s.outbound))
+	for _, e := range s.outbound {
+		queued = append(queued, e)
+	}
+	sortOutbound(queued)
+	return queued, nil
+}
+
+// PutOutbound queues an event
+func (s *MemoryStore) PutOutbound(e OutboundEvent) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.outbound[outboundKey(e.Target, e.ID)] = e
+	return nil
+}
+
+// DeleteOutbound removes an event from the queue
+func (s *MemoryStore) DeleteOutbound(target peer.ID, id string) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	delete(s.outbound, outboundKey(target, id))
+	return nil
+}
+
+// marshalServices marshals Services wrapping each protocol
+// as it is done in the protocol exchange
+func marshalServices(services Services) ([]byte, error) {
+	wrapped := make([]json.RawMessage, 0, len(services))
+	for _, s := range services {
+		wrapped = append(wrapped, wrapProtocol(s.Protocol, s.Roles...))
+	}
+	return json.Marshal(wrapped)
+}
+
+// unmarshalServices unmarshals Services marshalled with
+// marshalServices
+func unmarshalServices(data []byte) (Services, error) {
+	var wrapped []json.RawMessage
+	if err := json.Unmarshal(data, &wrapped); err != nil {
+		return nil, err
+	}
+	services := make(Services, len(wrapped))
+	for _, w := range wrapped {
+		p, roles, err := unwrapProtocol(w)
+		if err != nil {
+			return nil, err
+		}
+		services[p.Key()] = Service{Protocol: p, Roles: roles}
+	}
+	return services, nil
+}
@@ -0,0 +1,130 @@
+package net
+
+import (
+	"context"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/libp2p/go-libp2p-core/peer"
+	"github.com/mikelsr/bspl"
+)
+
+func TestStores(t *testing.T) {
+	os.Remove(testDBPath)
+	defer os.Remove(testDBPath)
+	bolt, err := OpenBoltStore(testDBPath)
+	if err != nil {
+		t.Log(err)
+		t.FailNow()
+	}
+	defer bolt.Close()
+
+	for name, store := range map[string]Store{
+		"memory": NewMemoryStore(),
+		"bolt":   bolt,
+	} {
+		t.Run(name, func(t *testing.T) {
+			testStore(t, store)
+		})
+	}
+}
+
+func testStore(t *testing.T, s Store) {
+	id := testID(1)
+	service := Service{Protocol: tp1, Roles: []bspl.Role{tp1.Roles[0]}}
+
+	// contacts
+	if err := s.PutContact(id, Services{tp1.Key(): service}); err != nil {
+		t.Log(err)
+		t.FailNow()
+	}
+	contacts, err := s.LoadContacts()
+	if err != nil || len(contacts) != 1 {
+		t.FailNow()
+	}
+	loaded := contacts[id][tp1.Key()]
+	if loaded.Protocol.String() != tp1.String() || len(loaded.Roles) != 1 {
+		t.FailNow()
+	}
+	s.DeleteContact(id)
+	if contacts, _ := s.LoadContacts(); len(contacts) != 0 {
+		t.FailNow()
+	}
+
+	// instances
+	ownership := Ownership{Creator: id, Roles: map[bspl.Role]peer.ID{tp1.Roles[0]: id}}
+	if err := s.PutInstance("key", ownership); err != nil {
+		t.FailNow()
+	}
+	instances, err := s.LoadInstances()
+	if err != nil || instances["key"].Creator != id || !instances["key"].Plays(id, tp1.Roles[0]) {
+		t.Log(err)
+		t.FailNow()
+	}
+	s.DeleteInstance("key")
+	if instances, _ := s.LoadInstances(); len(instances) != 0 {
+		t.FailNow()
+	}
+
+	// outbound events are sorted by the time they were queued
+	if outbox, ok := s.(Outbox); ok {
+		now := time.Now()
+		outbox.PutOutbound(OutboundEvent{Target: id, ID: "b", Data: []byte("b"), Queued: now.Add(time.Second)})
+		outbox.PutOutbound(OutboundEvent{Target: id, ID: "a", Data: []byte("a"), Queued: now})
+		queued, err := outbox.LoadOutbound()
+		if err != nil || len(queued) != 2 || queued[0].ID != "a" || string(queued[1].Data) != "b" {
+			t.Log(err)
+			t.FailNow()
+		}
+		outbox.DeleteOutbound(id, "a")
+		if queued, _ := outbox.LoadOutbound(); len(queued) != 1 || queued[0].Target != id {
+			t.FailNow()
+		}
+	}
+
+	// protocols are overwritten by key
+	s.PutProtocol(service)
+	s.PutProtocol(Service{Protocol: tp1, Roles: tp1.Roles})
+	protocols, err := s.LoadProtocols()
+	if err != nil || len(protocols) != 1 || len(protocols[0].Roles) != len(tp1.Roles) {
+		t.FailNow()
+	}
+}
+
+func TestNodeStore(t *testing.T) {
+	os.Remove(testDBPath)
+	defer os.Remove(testDBPath)
+	store, err := OpenBoltStore(testDBPath)
+	if err != nil {
+		t.Log(err)
+		t.FailNow()
+	}
+	defer store.Close()
+
+	n1, _ := nodeFromPrivKey(*testKeys[0], WithStore(store))
+	n1.AddProtocol(tp1, tp1.Roles[0])
+	contact, owner := testID(1), testID(2)
+	n1.AddContact(contact, Service{Protocol: tp2, Roles: tp2.Roles})
+	n1.OpenInstances().Put("key", Ownership{Creator: owner})
+	n1.OpenInstances().Put("dropped", Ownership{Creator: owner})
+	n1.OpenInstances().Delete("dropped")
+	n1.Close(context.Background())
+
+	// a node created with the same store recovers the state
+	n2, err := nodeFromPrivKey(*testKeys[0], WithStore(store))
+	if err != nil {
+		t.Log(err)
+		t.FailNow()
+	}
+	defer n2.Close(context.Background())
+	if services, found := n2.Contacts().Get(contact); !found || len(services) != 1 {
+		t.FailNow()
+	}
+	if o, found := n2.OpenInstances().Get("key"); !found || o.Creator != owner || n2.OpenInstances().Len() != 1 {
+		t.FailNow()
+	}
+	if len(n2.protocols) != 1 || len(n2.roles[tp1.Key()]) != 1 {
+		t.FailNow()
+	}
+}
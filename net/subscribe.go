@@ -0,0 +1,187 @@
+package net
+
+import (
+	"sync"
+
+	"github.com/libp2p/go-libp2p-core/peer"
+	"github.com/mikelsr/nahs/events"
+)
+
+// EventFilter selects the events delivered to a subscription.
+// Empty fields match every event.
+type EventFilter struct {
+	// Types of the events
+	Types []events.EventType
+	// ProtocolKey of the instance of the events
+	ProtocolKey string
+	// InstanceKey of the events
+	InstanceKey string
+	// Rejected also delivers the events that were not run,
+	// wrapped in a RejectedEvent
+	Rejected bool
+	// Buffer is the size of the channel of the subscription,
+	// defaultSubscriptionBuffer if not set
+	Buffer int
+}
+
+// RejectedEvent is delivered to subscriptions with
+// EventFilter.Rejected set for events that were not run
+type RejectedEvent struct {
+	events.Event
+	// Sender of the event
+	Sender peer.ID
+	// Err is why the event was not run, it wraps an ErrHandleEvent
+	Err error
+}
+
+// matches checks if an event on an instance of the protocol with
+// protocolKey passes the filter
+func (f EventFilter) matches(event events.Event, protocolKey string, rejected bool) bool {
+	if rejected && !f.Rejected {
+		return false
+	}
+	if f.InstanceKey != "" && event.InstanceKey() != f.InstanceKey {
+		return false
+	}
+	if f.ProtocolKey != "" && protocolKey != f.ProtocolKey {
+		return false
+	}
+	if len(f.Types) == 0 {
+		return true
+	}
+	for _, t := range f.Types {
+		if event.Type() == t {
+			return true
+		}
+	}
+	return false
+}
+
+// subscription is a channel events are delivered to
+type subscription struct {
+	filter  EventFilter
+	channel chan events.Event
+}
+
+// subscriptions keeps the subscriptions of a Node
+type subscriptions struct {
+	mutex sync.RWMutex
+	next  int
+	subs  map[int]*subscription
+}
+
+// newSubscriptions is the default constructor for subscriptions
+func newSubscriptions() *subscriptions {
+	return &subscriptions{subs: make(map[int]*subscription)}
+}
+
+// add registers a subscription and returns its ID
+func (s *subscriptions) add(sub *subscription) int {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	id := s.next
+	s.next++
+	s.subs[id] = sub
+	return id
+}
+
+// remove unregisters a subscription and closes its channel
+func (s *subscriptions) remove(id int) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if sub, found := s.subs[id]; found {
+		close(sub.channel)
+		delete(s.subs, id)
+	}
+}
+
+// removeAll unregisters every subscription
+func (s *subscriptions) removeAll() {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	for id, sub := range s.subs {
+		close(sub.channel)
+		delete(s.subs, id)
+	}
+}
+
+// empty reports whether there are no subscriptions
+func (s *subscriptions) empty() bool {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+	return len(s.subs) == 0
+}
+
+// publish delivers an event on an instance of the protocol with
+// protocolKey to the matching subscriptions without blocking.
+// Subscriptions with a full buffer miss the event.
+func (s *subscriptions) publish(event events.Event, protocolKey string, rejected bool) {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+	for _, sub := range s.subs {
+		if !sub.filter.matches(event, protocolKey, rejected) {
+			continue
+		}
+		select {
+		case sub.channel <- event:
+		default:
+			logger.Warningf("Subscription buffer full, dropping event '%s'", event.ID())
+		}
+	}
+}
+
+// Subscribe returns a channel the events run by the Node that match
+// filter are delivered to, and a function to cancel the subscription
+// and close the channel. Events are delivered in the order they are
+// run. The Node never waits for slow subscribers: if the buffer of
+// the channel is full when an event is run, the event is dropped for
+// that subscription and a warning is logged. The channel is closed
+// when the subscription is cancelled or the Node is closed.
+func (n *Node) Subscribe(filter EventFilter) (<-chan events.Event, func()) {
+	if filter.Buffer <= 0 {
+		filter.Buffer = defaultSubscriptionBuffer
+	}
+	sub := &subscription{filter: filter, channel: make(chan events.Event, filter.Buffer)}
+	id := n.subscriptions.add(sub)
+	var once sync.Once
+	return sub.channel, func() {
+		once.Do(func() { n.subscriptions.remove(id) })
+	}
+}
+
+// notify delivers a marshalled event that was run, or rejected if
+// err is set, to the subscriptions of the Node. protocolKey is the
+// key of the protocol of its instance, see eventProtocolKey.
+func (n *Node) notify(b []byte, sender peer.ID, protocolKey string, err error) {
+	if n.subscriptions.empty() {
+		return
+	}
+	event, uerr := events.Unmarshal(b)
+	if uerr != nil {
+		return
+	}
+	if err != nil {
+		n.subscriptions.publish(RejectedEvent{Event: event, Sender: sender, Err: err}, protocolKey, true)
+		return
+	}
+	n.subscriptions.publish(event, protocolKey, false)
+}
+
+// eventProtocolKey returns the key of the protocol of the instance of
+// a marshalled event, empty if it is unknown. It must be called
+// before the event is run, a DropEvent removes the instance from the
+// reasoner.
+func (n *Node) eventProtocolKey(b []byte) string {
+	if n.subscriptions.empty() || n.reasoner == nil {
+		return ""
+	}
+	event, err := events.Unmarshal(b)
+	if err != nil {
+		return ""
+	}
+	instance, err := n.eventInstance(event)
+	if err != nil {
+		return ""
+	}
+	return instance.Protocol().Key()
+}
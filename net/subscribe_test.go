@@ -0,0 +1,135 @@
+package net
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/mikelsr/nahs/events"
+)
+
+func TestEventFilter(t *testing.T) {
+	i := testInstance()
+	key := i.Protocol().Key()
+	newEvent := events.MakeNewEvent(i)
+	dropEvent := events.MakeDropEvent(i.Key(), "_")
+	testCases := []struct {
+		name     string
+		filter   EventFilter
+		event    events.Event
+		rejected bool
+		matches  bool
+	}{
+		{"Empty", EventFilter{}, newEvent, false, true},
+		{"Type", EventFilter{Types: []events.EventType{events.TypeDropEvent}}, newEvent, false, false},
+		{"Types", EventFilter{Types: []events.EventType{events.TypeNewEvent, events.TypeDropEvent}},
+			dropEvent, false, true},
+		{"Protocol", EventFilter{ProtocolKey: key}, dropEvent, false, true},
+		{"OtherProtocol", EventFilter{ProtocolKey: "other"}, newEvent, false, false},
+		{"PrefixProtocol", EventFilter{ProtocolKey: key[:len(key)-1]}, newEvent, false, false},
+		{"Instance", EventFilter{InstanceKey: i.Key()}, newEvent, false, true},
+		{"OtherInstance", EventFilter{InstanceKey: "other"}, newEvent, false, false},
+		{"Rejected", EventFilter{}, newEvent, true, false},
+		{"WithRejected", EventFilter{Rejected: true}, newEvent, true, true},
+	}
+	for _, tc := range testCases {
+		if tc.filter.matches(tc.event, key, tc.rejected) != tc.matches {
+			t.Log(tc.name)
+			t.FailNow()
+		}
+	}
+}
+
+// receive waits for an event on a subscription
+func receive(c <-chan events.Event) (events.Event, bool) {
+	select {
+	case event, ok := <-c:
+		return event, ok
+	case <-time.After(2 * time.Second):
+		return nil, false
+	}
+}
+
+func TestNode_Subscribe(t *testing.T) {
+	n := testNodes(2)
+	for _, node := range n {
+		node.reasoner = mockReasoner{}
+		defer node.Close(context.Background())
+	}
+	n1, n2 := n[0], n[1]
+	instance := testInstance()
+
+	all, cancelAll := n2.Subscribe(EventFilter{})
+	defer cancelAll()
+	rejected, cancelRejected := n2.Subscribe(EventFilter{
+		Types:    []events.EventType{events.TypeNewEvent},
+		Rejected: true,
+	})
+	defer cancelRejected()
+	slow, cancelSlow := n2.Subscribe(EventFilter{Buffer: 1})
+	defer cancelSlow()
+	protocol, cancelProtocol := n2.Subscribe(EventFilter{ProtocolKey: instance.Protocol().Key()})
+	defer cancelProtocol()
+
+	created := events.MakeNewEvent(instance)
+	if err := n1.SendEvent(context.Background(), n2.ID(), created); err != nil {
+		t.Log(err)
+		t.FailNow()
+	}
+	if event, ok := receive(all); !ok || event.ID() != created.ID() || event.Type() != events.TypeNewEvent {
+		t.FailNow()
+	}
+	if event, ok := receive(rejected); !ok || event.ID() != created.ID() {
+		t.FailNow()
+	}
+
+	// creating the instance again is rejected
+	again := events.MakeNewEvent(instance)
+	n1.SendEvent(context.Background(), n2.ID(), again)
+	event, ok := receive(rejected)
+	if !ok {
+		t.FailNow()
+	}
+	r, isRejected := event.(RejectedEvent)
+	var errExists ErrInstanceExists
+	if !isRejected || r.ID() != again.ID() || r.Sender != n1.ID() || !errors.As(r.Err, &errExists) {
+		t.FailNow()
+	}
+
+	// slow subscribers miss events instead of blocking the node
+	dropped := events.MakeDropEvent(instance.Key(), "_")
+	if err := n1.SendEvent(context.Background(), n2.ID(), dropped); err != nil {
+		t.Log(err)
+		t.FailNow()
+	}
+	if event, ok := receive(all); !ok || event.ID() != dropped.ID() {
+		t.FailNow()
+	}
+	if event, ok := receive(slow); !ok || event.ID() != created.ID() {
+		t.FailNow()
+	}
+	// the protocol of dropped instances is known too
+	for _, id := range []string{created.ID(), dropped.ID()} {
+		if event, ok := receive(protocol); !ok || event.ID() != id {
+			t.FailNow()
+		}
+	}
+	select {
+	case <-slow:
+		t.FailNow()
+	default:
+	}
+
+	// cancelling closes the channel
+	cancelAll()
+	cancelAll()
+	if _, ok := <-all; ok {
+		t.FailNow()
+	}
+	// closing the node cancels the remaining subscriptions
+	n2.Close(context.Background())
+	if _, ok := <-rejected; ok {
+		t.FailNow()
+	}
+}
@@ -9,6 +9,7 @@ import (
 	"path/filepath"
 
 	"github.com/libp2p/go-libp2p-core/crypto"
+	"github.com/libp2p/go-libp2p-core/peer"
 	peerstore "github.com/libp2p/go-libp2p-peerstore"
 	"github.com/mikelsr/bspl"
 	imp "github.com/mikelsr/bspl/implementation"
@@ -153,10 +154,23 @@ func (m mockReasoner) UpdateInstance(newVersion bspl.Instance) error {
 	return nil
 }
 
+// testID returns the peer.ID of the i-th test key
+func testID(i int) peer.ID {
+	id, err := peer.IDFromPrivateKey(*testKeys[i])
+	if err != nil {
+		panic(err)
+	}
+	return id
+}
+
 func testNodes(n int) []*Node {
 	nodes := make([]*Node, n)
 	for i := 0; i < n; i++ {
-		nodes[i] = nodeFromPrivKey(*testKeys[i])
+		node, err := nodeFromPrivKey(*testKeys[i])
+		if err != nil {
+			panic(err)
+		}
+		nodes[i] = node
 	}
 	// Add addresses of each peer to the others
 	for i := 0; i < n; i++ {
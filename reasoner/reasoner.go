@@ -0,0 +1,240 @@
+// Package reasoner implements a bspl.Reasoner that keeps the protocol
+// instances in a bolt database, so a node keeps its instances between
+// runs.
+package reasoner
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/mikelsr/bspl"
+	imp "github.com/mikelsr/bspl/implementation"
+	bolt "go.etcd.io/bbolt"
+)
+
+var (
+	instancesBucket = []byte("instances")
+	droppedBucket   = []byte("dropped")
+)
+
+var (
+	// ErrInstanceExists is returned when instantiating or registering
+	// an instance with the key of another one
+	ErrInstanceExists = errors.New("Instance already exists")
+	// ErrInstanceUnknown is returned when updating or dropping an
+	// instance the reasoner doesn't have
+	ErrInstanceUnknown = errors.New("Unknown instance")
+)
+
+// Reasoner is a bspl.Reasoner persisted to disk in a bolt database.
+// Instances are validated by the bspl implementation: updates must
+// only bind parameters that are unbound, and be the out parameters of
+// an action of the protocol.
+type Reasoner struct {
+	db        *bolt.DB
+	mutex     sync.RWMutex
+	instances map[string]bspl.Instance
+}
+
+// Open opens the bolt database in path, creating it and its parent
+// directories if they don't exist, and loads the stored instances
+func Open(path string) (*Reasoner, error) {
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return nil, err
+	}
+	db, err := bolt.Open(path, 0600, &bolt.Options{Timeout: time.Second})
+	if err != nil {
+		return nil, err
+	}
+	r := &Reasoner{db: db, instances: make(map[string]bspl.Instance)}
+	err = db.Update(func(tx *bolt.Tx) error {
+		for _, bucket := range [][]byte{instancesBucket, droppedBucket} {
+			if _, err := tx.CreateBucketIfNotExists(bucket); err != nil {
+				return err
+			}
+		}
+		return tx.Bucket(instancesBucket).ForEach(func(k, v []byte) error {
+			instance := new(imp.Instance)
+			if err := instance.Unmarshal(v); err != nil {
+				return fmt.Errorf("Invalid instance '%s': %s", k, err)
+			}
+			r.instances[string(k)] = instance
+			return nil
+		})
+	})
+	if err != nil {
+		db.Close()
+		return nil, err
+	}
+	return r, nil
+}
+
+// Close the bolt database
+func (r *Reasoner) Close() error {
+	return r.db.Close()
+}
+
+// DropInstance removes an instance, the motive is kept and returned
+// by Dropped
+func (r *Reasoner) DropInstance(instanceKey string, motive string) error {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	if _, found := r.instances[instanceKey]; !found {
+		return ErrInstanceUnknown
+	}
+	err := r.db.Update(func(tx *bolt.Tx) error {
+		if err := tx.Bucket(instancesBucket).Delete([]byte(instanceKey)); err != nil {
+			return err
+		}
+		return tx.Bucket(droppedBucket).Put([]byte(instanceKey), []byte(motive))
+	})
+	if err != nil {
+		return err
+	}
+	delete(r.instances, instanceKey)
+	return nil
+}
+
+// Dropped returns the motive an instance was dropped with
+func (r *Reasoner) Dropped(instanceKey string) (string, bool) {
+	var motive []byte
+	r.db.View(func(tx *bolt.Tx) error {
+		if v := tx.Bucket(droppedBucket).Get([]byte(instanceKey)); v != nil {
+			motive = append([]byte{}, v...)
+		}
+		return nil
+	})
+	return string(motive), motive != nil
+}
+
+// GetInstance returns a copy of an instance given its key
+func (r *Reasoner) GetInstance(instanceKey string) (bspl.Instance, bool) {
+	r.mutex.RLock()
+	defer r.mutex.RUnlock()
+	instance, found := r.instances[instanceKey]
+	if !found {
+		return nil, false
+	}
+	return copyInstance(instance), true
+}
+
+// Instances returns a copy of all the instances of a protocol
+func (r *Reasoner) Instances(p bspl.Protocol) []bspl.Instance {
+	r.mutex.RLock()
+	defer r.mutex.RUnlock()
+	instances := make([]bspl.Instance, 0)
+	for _, instance := range r.instances {
+		if instance.Protocol().Key() == p.Key() {
+			instances = append(instances, copyInstance(instance))
+		}
+	}
+	return instances
+}
+
+// Instantiate creates an instance of a protocol with the values of
+// some of its parameters. The key parameters must be set.
+func (r *Reasoner) Instantiate(p bspl.Protocol, roles bspl.Roles, ins bspl.Values) (bspl.Instance, error) {
+	instance := imp.NewInstance(p, roles)
+	for name, value := range ins {
+		instance.SetValue(name, value)
+	}
+	for _, param := range p.Keys() {
+		if instance.GetValue(param.Name) == "" {
+			return nil, fmt.Errorf("Key parameter '%s' is not set", param.Name)
+		}
+	}
+	if err := r.RegisterInstance(instance); err != nil {
+		return nil, err
+	}
+	return copyInstance(instance), nil
+}
+
+// RegisterInstance stores an instance created by another reasoner
+func (r *Reasoner) RegisterInstance(i bspl.Instance) error {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	if _, found := r.instances[i.Key()]; found {
+		return ErrInstanceExists
+	}
+	instance := copyInstance(i)
+	if err := r.put(instance); err != nil {
+		return err
+	}
+	r.instances[instance.Key()] = instance
+	return nil
+}
+
+// UpdateInstance updates an instance with a newer version of itself.
+// The values bound in the newer version must be parameters of the
+// protocol bound by one of its actions, and the values that were
+// already bound must not change.
+func (r *Reasoner) UpdateInstance(newVersion bspl.Instance) error {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	current, found := r.instances[newVersion.Key()]
+	if !found {
+		return ErrInstanceUnknown
+	}
+	defined := make(map[string]bool)
+	for _, param := range current.Protocol().Parameters() {
+		defined[param.String()] = true
+	}
+	updated := copyInstance(current)
+	diff := imp.NewInstance(current.Protocol(), current.Roles())
+	for param, value := range newVersion.Parameters() {
+		if !defined[param] {
+			return fmt.Errorf("Unknown param '%s'", param)
+		}
+		switch updated.Parameters()[param] {
+		case "":
+			diff.Parameters()[param] = value
+		case value:
+		default:
+			return fmt.Errorf("Mismatched values for param '%s'", param)
+		}
+	}
+	if len(diff.Parameters()) > 0 {
+		if err := updated.Update(diff); err != nil {
+			return err
+		}
+	}
+	for role, id := range newVersion.Roles() {
+		if updated.Roles()[role] == "" {
+			updated.Roles()[role] = id
+		}
+	}
+	if err := r.put(updated); err != nil {
+		return err
+	}
+	r.instances[updated.Key()] = updated
+	return nil
+}
+
+// put writes an instance to the database
+func (r *Reasoner) put(i bspl.Instance) error {
+	b, err := i.Marshal()
+	if err != nil {
+		return err
+	}
+	return r.db.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket(instancesBucket).Put([]byte(i.Key()), b)
+	})
+}
+
+// copyInstance returns a copy of an instance that doesn't share
+// its roles and values
+func copyInstance(i bspl.Instance) bspl.Instance {
+	roles := make(bspl.Roles, len(i.Roles()))
+	for role, id := range i.Roles() {
+		roles[role] = id
+	}
+	instance := imp.NewInstance(i.Protocol(), roles)
+	for param, value := range i.Parameters() {
+		instance.Parameters()[param] = value
+	}
+	return instance
+}
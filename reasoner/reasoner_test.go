@@ -0,0 +1,122 @@
+package reasoner
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/mikelsr/bspl"
+	imp "github.com/mikelsr/bspl/implementation"
+)
+
+const testProtocol = `Purchase {
+	role Buyer, Seller
+	parameter out ID key, out item, out price
+
+	Buyer -> Seller: Request[out ID, out item]
+	Seller -> Buyer: Offer[in ID, in item, out price]
+}`
+
+// testReasoner opens a reasoner in a temporary directory
+func testReasoner(t *testing.T) (*Reasoner, string) {
+	dir, err := ioutil.TempDir("", "nahs-reasoner")
+	if err != nil {
+		t.Log(err)
+		t.FailNow()
+	}
+	r, err := Open(filepath.Join(dir, "reasoner.db"))
+	if err != nil {
+		t.Log(err)
+		t.FailNow()
+	}
+	return r, dir
+}
+
+func TestReasoner(t *testing.T) {
+	p, err := bspl.Parse(strings.NewReader(testProtocol))
+	if err != nil {
+		t.Log(err)
+		t.FailNow()
+	}
+	r, dir := testReasoner(t)
+	defer os.RemoveAll(dir)
+	roles := bspl.Roles{"Buyer": "b", "Seller": "s"}
+
+	// key parameters are required
+	if _, err := r.Instantiate(p, roles, bspl.Values{"item": "apple"}); err == nil {
+		t.FailNow()
+	}
+	instance, err := r.Instantiate(p, roles, bspl.Values{"ID": "1", "item": "apple"})
+	if err != nil {
+		t.Log(err)
+		t.FailNow()
+	}
+	if _, err := r.Instantiate(p, roles, bspl.Values{"ID": "1"}); err != ErrInstanceExists {
+		t.FailNow()
+	}
+	// copies are returned
+	instance.SetValue("price", "10")
+	if i, found := r.GetInstance(instance.Key()); !found || i.GetValue("price") != "" {
+		t.FailNow()
+	}
+
+	// updates
+	if err := r.UpdateInstance(instance); err != nil {
+		t.Log(err)
+		t.FailNow()
+	}
+	changed, _ := r.GetInstance(instance.Key())
+	changed.SetValue("item", "pear")
+	if err := r.UpdateInstance(changed); err == nil {
+		t.FailNow()
+	}
+	// parameters not defined by the protocol are rejected
+	unknown, _ := r.GetInstance(instance.Key())
+	unknown.Parameters()["bogus"] = "x"
+	if err := r.UpdateInstance(unknown); err == nil {
+		t.FailNow()
+	}
+	other := imp.NewInstance(p, roles)
+	other.SetValue("ID", "2")
+	if err := r.UpdateInstance(other); err != ErrInstanceUnknown {
+		t.FailNow()
+	}
+	if err := r.RegisterInstance(other); err != nil {
+		t.Log(err)
+		t.FailNow()
+	}
+	if len(r.Instances(p)) != 2 {
+		t.FailNow()
+	}
+
+	// instances are kept between runs
+	r.Close()
+	if r, err = Open(filepath.Join(dir, "reasoner.db")); err != nil {
+		t.Log(err)
+		t.FailNow()
+	}
+	if i, found := r.GetInstance(instance.Key()); !found || i.GetValue("price") != "10" {
+		t.FailNow()
+	}
+	if err := r.DropInstance(other.Key(), "cancelled"); err != nil {
+		t.Log(err)
+		t.FailNow()
+	}
+	if err := r.DropInstance(other.Key(), "cancelled"); err != ErrInstanceUnknown {
+		t.FailNow()
+	}
+	r.Close()
+	if r, err = Open(filepath.Join(dir, "reasoner.db")); err != nil {
+		t.Log(err)
+		t.FailNow()
+	}
+	defer r.Close()
+	if _, found := r.GetInstance(other.Key()); found {
+		t.FailNow()
+	}
+	if motive, found := r.Dropped(other.Key()); !found || motive != "cancelled" {
+		t.FailNow()
+	}
+}
@@ -0,0 +1,1055 @@
+// Code generated by protoc-gen-go. DO NOT EDIT.
+// source: nahs.proto
+
+package pb
+
+import (
+	context "context"
+	fmt "fmt"
+	proto "github.com/golang/protobuf/proto"
+	grpc "google.golang.org/grpc"
+	codes "google.golang.org/grpc/codes"
+	status "google.golang.org/grpc/status"
+	math "math"
+)
+
+// Reference imports to suppress errors if they are not otherwise used.
+var _ = proto.Marshal
+var _ = fmt.Errorf
+var _ = math.Inf
+
+// This is a compile-time assertion to ensure that this generated file
+// is compatible with the proto package it is being compiled against.
+// A compilation error at this line likely means your copy of the
+// proto package needs to be updated.
+const _ = proto.ProtoPackageIsVersion3 // please upgrade the proto package
+
+type IDRequest struct {
+	XXX_NoUnkeyedLiteral struct{} `json:"-"`
+	XXX_unrecognized     []byte   `json:"-"`
+	XXX_sizecache        int32    `json:"-"`
+}
+
+func (m *IDRequest) Reset()         { *m = IDRequest{} }
+func (m *IDRequest) String() string { return proto.CompactTextString(m) }
+func (*IDRequest) ProtoMessage()    {}
+func (*IDRequest) Descriptor() ([]byte, []int) {
+	return fileDescriptor_80aa5495981f5974, []int{0}
+}
+
+func (m *IDRequest) XXX_Unmarshal(b []byte) error {
+	return xxx_messageInfo_IDRequest.Unmarshal(m, b)
+}
+func (m *IDRequest) XXX_Marshal(b []byte, deterministic bool) ([]byte, error) {
+	return xxx_messageInfo_IDRequest.Marshal(b, m, deterministic)
+}
+func (m *IDRequest) XXX_Merge(src proto.Message) {
+	xxx_messageInfo_IDRequest.Merge(m, src)
+}
+func (m *IDRequest) XXX_Size() int {
+	return xxx_messageInfo_IDRequest.Size(m)
+}
+func (m *IDRequest) XXX_DiscardUnknown() {
+	xxx_messageInfo_IDRequest.DiscardUnknown(m)
+}
+
+var xxx_messageInfo_IDRequest proto.InternalMessageInfo
+
+type IDResponse struct {
+	Id                   string   `protobuf:"bytes,1,opt,name=id,proto3" json:"id,omitempty"`
+	XXX_NoUnkeyedLiteral struct{} `json:"-"`
+	XXX_unrecognized     []byte   `json:"-"`
+	XXX_sizecache        int32    `json:"-"`
+}
+
+func (m *IDResponse) Reset()         { *m = IDResponse{} }
+func (m *IDResponse) String() string { return proto.CompactTextString(m) }
+func (*IDResponse) ProtoMessage()    {}
+func (*IDResponse) Descriptor() ([]byte, []int) {
+	return fileDescriptor_80aa5495981f5974, []int{1}
+}
+
+func (m *IDResponse) XXX_Unmarshal(b []byte) error {
+	return xxx_messageInfo_IDResponse.Unmarshal(m, b)
+}
+func (m *IDResponse) XXX_Marshal(b []byte, deterministic bool) ([]byte, error) {
+	return xxx_messageInfo_IDResponse.Marshal(b, m, deterministic)
+}
+func (m *IDResponse) XXX_Merge(src proto.Message) {
+	xxx_messageInfo_IDResponse.Merge(m, src)
+}
+func (m *IDResponse) XXX_Size() int {
+	return xxx_messageInfo_IDResponse.Size(m)
+}
+func (m *IDResponse) XXX_DiscardUnknown() {
+	xxx_messageInfo_IDResponse.DiscardUnknown(m)
+}
+
+var xxx_messageInfo_IDResponse proto.InternalMessageInfo
+
+func (m *IDResponse) GetId() string {
+	if m != nil {
+		return m.Id
+	}
+	return ""
+}
+
+type AddrsRequest struct {
+	XXX_NoUnkeyedLiteral struct{} `json:"-"`
+	XXX_unrecognized     []byte   `json:"-"`
+	XXX_sizecache        int32    `json:"-"`
+}
+
+func (m *AddrsRequest) Reset()         { *m = AddrsRequest{} }
+func (m *AddrsRequest) String() string { return proto.CompactTextString(m) }
+func (*AddrsRequest) ProtoMessage()    {}
+func (*AddrsRequest) Descriptor() ([]byte, []int) {
+	return fileDescriptor_80aa5495981f5974, []int{2}
+}
+
+func (m *AddrsRequest) XXX_Unmarshal(b []byte) error {
+	return xxx_messageInfo_AddrsRequest.Unmarshal(m, b)
+}
+func (m *AddrsRequest) XXX_Marshal(b []byte, deterministic bool) ([]byte, error) {
+	return xxx_messageInfo_AddrsRequest.Marshal(b, m, deterministic)
+}
+func (m *AddrsRequest) XXX_Merge(src proto.Message) {
+	xxx_messageInfo_AddrsRequest.Merge(m, src)
+}
+func (m *AddrsRequest) XXX_Size() int {
+	return xxx_messageInfo_AddrsRequest.Size(m)
+}
+func (m *AddrsRequest) XXX_DiscardUnknown() {
+	xxx_messageInfo_AddrsRequest.DiscardUnknown(m)
+}
+
+var xxx_messageInfo_AddrsRequest proto.InternalMessageInfo
+
+type AddrsResponse struct {
+	Addrs                []string `protobuf:"bytes,1,rep,name=addrs,proto3" json:"addrs,omitempty"`
+	XXX_NoUnkeyedLiteral struct{} `json:"-"`
+	XXX_unrecognized     []byte   `json:"-"`
+	XXX_sizecache        int32    `json:"-"`
+}
+
+func (m *AddrsResponse) Reset()         { *m = AddrsResponse{} }
+func (m *AddrsResponse) String() string { return proto.CompactTextString(m) }
+func (*AddrsResponse) ProtoMessage()    {}
+func (*AddrsResponse) Descriptor() ([]byte, []int) {
+	return fileDescriptor_80aa5495981f5974, []int{3}
+}
+
+func (m *AddrsResponse) XXX_Unmarshal(b []byte) error {
+	return xxx_messageInfo_AddrsResponse.Unmarshal(m, b)
+}
+func (m *AddrsResponse) XXX_Marshal(b []byte, deterministic bool) ([]byte, error) {
+	return xxx_messageInfo_AddrsResponse.Marshal(b, m, deterministic)
+}
+func (m *AddrsResponse) XXX_Merge(src proto.Message) {
+	xxx_messageInfo_AddrsResponse.Merge(m, src)
+}
+func (m *AddrsResponse) XXX_Size() int {
+	return xxx_messageInfo_AddrsResponse.Size(m)
+}
+func (m *AddrsResponse) XXX_DiscardUnknown() {
+	xxx_messageInfo_AddrsResponse.DiscardUnknown(m)
+}
+
+var xxx_messageInfo_AddrsResponse proto.InternalMessageInfo
+
+func (m *AddrsResponse) GetAddrs() []string {
+	if m != nil {
+		return m.Addrs
+	}
+	return nil
+}
+
+// ContactQuery selects contacts, empty fields match every contact
+type ContactQuery struct {
+	ProtocolKey  string `protobuf:"bytes,1,opt,name=protocol_key,json=protocolKey,proto3" json:"protocol_key,omitempty"`
+	ProtocolName string `protobuf:"bytes,2,opt,name=protocol_name,json=protocolName,proto3" json:"protocol_name,omitempty"`
+	// roles the contact plays, all of them in the same service
+	Roles []string `protobuf:"bytes,3,rep,name=roles,proto3" json:"roles,omitempty"`
+	// connected only matches contacts the node is connected to
+	Connected bool `protobuf:"varint,4,opt,name=connected,proto3" json:"connected,omitempty"`
+	// seen_within_ms only matches contacts seen within the duration
+	SeenWithinMs         int64    `protobuf:"varint,5,opt,name=seen_within_ms,json=seenWithinMs,proto3" json:"seen_within_ms,omitempty"`
+	XXX_NoUnkeyedLiteral struct{} `json:"-"`
+	XXX_unrecognized     []byte   `json:"-"`
+	XXX_sizecache        int32    `json:"-"`
+}
+
+func (m *ContactQuery) Reset()         { *m = ContactQuery{} }
+func (m *ContactQuery) String() string { return proto.CompactTextString(m) }
+func (*ContactQuery) ProtoMessage()    {}
+func (*ContactQuery) Descriptor() ([]byte, []int) {
+	return fileDescriptor_80aa5495981f5974, []int{4}
+}
+
+func (m *ContactQuery) XXX_Unmarshal(b []byte) error {
+	return xxx_messageInfo_ContactQuery.Unmarshal(m, b)
+}
+func (m *ContactQuery) XXX_Marshal(b []byte, deterministic bool) ([]byte, error) {
+	return xxx_messageInfo_ContactQuery.Marshal(b, m, deterministic)
+}
+func (m *ContactQuery) XXX_Merge(src proto.Message) {
+	xxx_messageInfo_ContactQuery.Merge(m, src)
+}
+func (m *ContactQuery) XXX_Size() int {
+	return xxx_messageInfo_ContactQuery.Size(m)
+}
+func (m *ContactQuery) XXX_DiscardUnknown() {
+	xxx_messageInfo_ContactQuery.DiscardUnknown(m)
+}
+
+var xxx_messageInfo_ContactQuery proto.InternalMessageInfo
+
+func (m *ContactQuery) GetProtocolKey() string {
+	if m != nil {
+		return m.ProtocolKey
+	}
+	return ""
+}
+
+func (m *ContactQuery) GetProtocolName() string {
+	if m != nil {
+		return m.ProtocolName
+	}
+	return ""
+}
+
+func (m *ContactQuery) GetRoles() []string {
+	if m != nil {
+		return m.Roles
+	}
+	return nil
+}
+
+func (m *ContactQuery) GetConnected() bool {
+	if m != nil {
+		return m.Connected
+	}
+	return false
+}
+
+func (m *ContactQuery) GetSeenWithinMs() int64 {
+	if m != nil {
+		return m.SeenWithinMs
+	}
+	return 0
+}
+
+type ContactsResponse struct {
+	Contacts             []*Contact `protobuf:"bytes,1,rep,name=contacts,proto3" json:"contacts,omitempty"`
+	XXX_NoUnkeyedLiteral struct{}   `json:"-"`
+	XXX_unrecognized     []byte     `json:"-"`
+	XXX_sizecache        int32      `json:"-"`
+}
+
+func (m *ContactsResponse) Reset()         { *m = ContactsResponse{} }
+func (m *ContactsResponse) String() string { return proto.CompactTextString(m) }
+func (*ContactsResponse) ProtoMessage()    {}
+func (*ContactsResponse) Descriptor() ([]byte, []int) {
+	return fileDescriptor_80aa5495981f5974, []int{5}
+}
+
+func (m *ContactsResponse) XXX_Unmarshal(b []byte) error {
+	return xxx_messageInfo_ContactsResponse.Unmarshal(m, b)
+}
+func (m *ContactsResponse) XXX_Marshal(b []byte, deterministic bool) ([]byte, error) {
+	return xxx_messageInfo_ContactsResponse.Marshal(b, m, deterministic)
+}
+func (m *ContactsResponse) XXX_Merge(src proto.Message) {
+	xxx_messageInfo_ContactsResponse.Merge(m, src)
+}
+func (m *ContactsResponse) XXX_Size() int {
+	return xxx_messageInfo_ContactsResponse.Size(m)
+}
+func (m *ContactsResponse) XXX_DiscardUnknown() {
+	xxx_messageInfo_ContactsResponse.DiscardUnknown(m)
+}
+
+var xxx_messageInfo_ContactsResponse proto.InternalMessageInfo
+
+func (m *ContactsResponse) GetContacts() []*Contact {
+	if m != nil {
+		return m.Contacts
+	}
+	return nil
+}
+
+type Contact struct {
+	Id string `protobuf:"bytes,1,opt,name=id,proto3" json:"id,omitempty"`
+	// last_seen_ms is when the contact was last seen, in milliseconds
+	// since the Unix epoch
+	LastSeenMs           int64      `protobuf:"varint,2,opt,name=last_seen_ms,json=lastSeenMs,proto3" json:"last_seen_ms,omitempty"`
+	Services             []*Service `protobuf:"bytes,3,rep,name=services,proto3" json:"services,omitempty"`
+	XXX_NoUnkeyedLiteral struct{}   `json:"-"`
+	XXX_unrecognized     []byte     `json:"-"`
+	XXX_sizecache        int32      `json:"-"`
+}
+
+func (m *Contact) Reset()         { *m = Contact{} }
+func (m *Contact) String() string { return proto.CompactTextString(m) }
+func (*Contact) ProtoMessage()    {}
+func (*Contact) Descriptor() ([]byte, []int) {
+	return fileDescriptor_80aa5495981f5974, []int{6}
+}
+
+func (m *Contact) XXX_Unmarshal(b []byte) error {
+	return xxx_messageInfo_Contact.Unmarshal(m, b)
+}
+func (m *Contact) XXX_Marshal(b []byte, deterministic bool) ([]byte, error) {
+	return xxx_messageInfo_Contact.Marshal(b, m, deterministic)
+}
+func (m *Contact) XXX_Merge(src proto.Message) {
+	xxx_messageInfo_Contact.Merge(m, src)
+}
+func (m *Contact) XXX_Size() int {
+	return xxx_messageInfo_Contact.Size(m)
+}
+func (m *Contact) XXX_DiscardUnknown() {
+	xxx_messageInfo_Contact.DiscardUnknown(m)
+}
+
+var xxx_messageInfo_Contact proto.InternalMessageInfo
+
+func (m *Contact) GetId() string {
+	if m != nil {
+		return m.Id
+	}
+	return ""
+}
+
+func (m *Contact) GetLastSeenMs() int64 {
+	if m != nil {
+		return m.LastSeenMs
+	}
+	return 0
+}
+
+func (m *Contact) GetServices() []*Service {
+	if m != nil {
+		return m.Services
+	}
+	return nil
+}
+
+// Service is a protocol offered by a node and the roles it plays
+type Service struct {
+	ProtocolKey          string   `protobuf:"bytes,1,opt,name=protocol_key,json=protocolKey,proto3" json:"protocol_key,omitempty"`
+	Protocol             string   `protobuf:"bytes,2,opt,name=protocol,proto3" json:"protocol,omitempty"`
+	Roles                []string `protobuf:"bytes,3,rep,name=roles,proto3" json:"roles,omitempty"`
+	XXX_NoUnkeyedLiteral struct{} `json:"-"`
+	XXX_unrecognized     []byte   `json:"-"`
+	XXX_sizecache        int32    `json:"-"`
+}
+
+func (m *Service) Reset()         { *m = Service{} }
+func (m *Service) String() string { return proto.CompactTextString(m) }
+func (*Service) ProtoMessage()    {}
+func (*Service) Descriptor() ([]byte, []int) {
+	return fileDescriptor_80aa5495981f5974, []int{7}
+}
+
+func (m *Service) XXX_Unmarshal(b []byte) error {
+	return xxx_messageInfo_Service.Unmarshal(m, b)
+}
+func (m *Service) XXX_Marshal(b []byte, deterministic bool) ([]byte, error) {
+	return xxx_messageInfo_Service.Marshal(b, m, deterministic)
+}
+func (m *Service) XXX_Merge(src proto.Message) {
+	xxx_messageInfo_Service.Merge(m, src)
+}
+func (m *Service) XXX_Size() int {
+	return xxx_messageInfo_Service.Size(m)
+}
+func (m *Service) XXX_DiscardUnknown() {
+	xxx_messageInfo_Service.DiscardUnknown(m)
+}
+
+var xxx_messageInfo_Service proto.InternalMessageInfo
+
+func (m *Service) GetProtocolKey() string {
+	if m != nil {
+		return m.ProtocolKey
+	}
+	return ""
+}
+
+func (m *Service) GetProtocol() string {
+	if m != nil {
+		return m.Protocol
+	}
+	return ""
+}
+
+func (m *Service) GetRoles() []string {
+	if m != nil {
+		return m.Roles
+	}
+	return nil
+}
+
+type AddProtocolRequest struct {
+	// protocol is the BSPL source of the protocol
+	Protocol             string   `protobuf:"bytes,1,opt,name=protocol,proto3" json:"protocol,omitempty"`
+	Roles                []string `protobuf:"bytes,2,rep,name=roles,proto3" json:"roles,omitempty"`
+	XXX_NoUnkeyedLiteral struct{} `json:"-"`
+	XXX_unrecognized     []byte   `json:"-"`
+	XXX_sizecache        int32    `json:"-"`
+}
+
+func (m *AddProtocolRequest) Reset()         { *m = AddProtocolRequest{} }
+func (m *AddProtocolRequest) String() string { return proto.CompactTextString(m) }
+func (*AddProtocolRequest) ProtoMessage()    {}
+func (*AddProtocolRequest) Descriptor() ([]byte, []int) {
+	return fileDescriptor_80aa5495981f5974, []int{8}
+}
+
+func (m *AddProtocolRequest) XXX_Unmarshal(b []byte) error {
+	return xxx_messageInfo_AddProtocolRequest.Unmarshal(m, b)
+}
+func (m *AddProtocolRequest) XXX_Marshal(b []byte, deterministic bool) ([]byte, error) {
+	return xxx_messageInfo_AddProtocolRequest.Marshal(b, m, deterministic)
+}
+func (m *AddProtocolRequest) XXX_Merge(src proto.Message) {
+	xxx_messageInfo_AddProtocolRequest.Merge(m, src)
+}
+func (m *AddProtocolRequest) XXX_Size() int {
+	return xxx_messageInfo_AddProtocolRequest.Size(m)
+}
+func (m *AddProtocolRequest) XXX_DiscardUnknown() {
+	xxx_messageInfo_AddProtocolRequest.DiscardUnknown(m)
+}
+
+var xxx_messageInfo_AddProtocolRequest proto.InternalMessageInfo
+
+func (m *AddProtocolRequest) GetProtocol() string {
+	if m != nil {
+		return m.Protocol
+	}
+	return ""
+}
+
+func (m *AddProtocolRequest) GetRoles() []string {
+	if m != nil {
+		return m.Roles
+	}
+	return nil
+}
+
+type SendEventRequest struct {
+	// peer the event is sent to, every participant of the instance of
+	// the event if empty
+	Peer string `protobuf:"bytes,1,opt,name=peer,proto3" json:"peer,omitempty"`
+	// event marshalled as by events.Event.Marshal
+	Event                []byte   `protobuf:"bytes,2,opt,name=event,proto3" json:"event,omitempty"`
+	XXX_NoUnkeyedLiteral struct{} `json:"-"`
+	XXX_unrecognized     []byte   `json:"-"`
+	XXX_sizecache        int32    `json:"-"`
+}
+
+func (m *SendEventRequest) Reset()         { *m = SendEventRequest{} }
+func (m *SendEventRequest) String() string { return proto.CompactTextString(m) }
+func (*SendEventRequest) ProtoMessage()    {}
+func (*SendEventRequest) Descriptor() ([]byte, []int) {
+	return fileDescriptor_80aa5495981f5974, []int{9}
+}
+
+func (m *SendEventRequest) XXX_Unmarshal(b []byte) error {
+	return xxx_messageInfo_SendEventRequest.Unmarshal(m, b)
+}
+func (m *SendEventRequest) XXX_Marshal(b []byte, deterministic bool) ([]byte, error) {
+	return xxx_messageInfo_SendEventRequest.Marshal(b, m, deterministic)
+}
+func (m *SendEventRequest) XXX_Merge(src proto.Message) {
+	xxx_messageInfo_SendEventRequest.Merge(m, src)
+}
+func (m *SendEventRequest) XXX_Size() int {
+	return xxx_messageInfo_SendEventRequest.Size(m)
+}
+func (m *SendEventRequest) XXX_DiscardUnknown() {
+	xxx_messageInfo_SendEventRequest.DiscardUnknown(m)
+}
+
+var xxx_messageInfo_SendEventRequest proto.InternalMessageInfo
+
+func (m *SendEventRequest) GetPeer() string {
+	if m != nil {
+		return m.Peer
+	}
+	return ""
+}
+
+func (m *SendEventRequest) GetEvent() []byte {
+	if m != nil {
+		return m.Event
+	}
+	return nil
+}
+
+type SendEventResponse struct {
+	// peers the event was sent to, mapped to the error they returned or
+	// an empty string
+	Peers                map[string]string `protobuf:"bytes,1,rep,name=peers,proto3" json:"peers,omitempty" protobuf_key:"bytes,1,opt,name=key,proto3" protobuf_val:"bytes,2,opt,name=value,proto3"`
+	XXX_NoUnkeyedLiteral struct{}          `json:"-"`
+	XXX_unrecognized     []byte            `json:"-"`
+	XXX_sizecache        int32             `json:"-"`
+}
+
+func (m *SendEventResponse) Reset()         { *m = SendEventResponse{} }
+func (m *SendEventResponse) String() string { return proto.CompactTextString(m) }
+func (*SendEventResponse) ProtoMessage()    {}
+func (*SendEventResponse) Descriptor() ([]byte, []int) {
+	return fileDescriptor_80aa5495981f5974, []int{10}
+}
+
+func (m *SendEventResponse) XXX_Unmarshal(b []byte) error {
+	return xxx_messageInfo_SendEventResponse.Unmarshal(m, b)
+}
+func (m *SendEventResponse) XXX_Marshal(b []byte, deterministic bool) ([]byte, error) {
+	return xxx_messageInfo_SendEventResponse.Marshal(b, m, deterministic)
+}
+func (m *SendEventResponse) XXX_Merge(src proto.Message) {
+	xxx_messageInfo_SendEventResponse.Merge(m, src)
+}
+func (m *SendEventResponse) XXX_Size() int {
+	return xxx_messageInfo_SendEventResponse.Size(m)
+}
+func (m *SendEventResponse) XXX_DiscardUnknown() {
+	xxx_messageInfo_SendEventResponse.DiscardUnknown(m)
+}
+
+var xxx_messageInfo_SendEventResponse proto.InternalMessageInfo
+
+func (m *SendEventResponse) GetPeers() map[string]string {
+	if m != nil {
+		return m.Peers
+	}
+	return nil
+}
+
+// EventFilter selects events, empty fields match every event
+type EventFilter struct {
+	Types       []string `protobuf:"bytes,1,rep,name=types,proto3" json:"types,omitempty"`
+	ProtocolKey string   `protobuf:"bytes,2,opt,name=protocol_key,json=protocolKey,proto3" json:"protocol_key,omitempty"`
+	InstanceKey string   `protobuf:"bytes,3,opt,name=instance_key,json=instanceKey,proto3" json:"instance_key,omitempty"`
+	// rejected also delivers the events that were not run
+	Rejected             bool     `protobuf:"varint,4,opt,name=rejected,proto3" json:"rejected,omitempty"`
+	XXX_NoUnkeyedLiteral struct{} `json:"-"`
+	XXX_unrecognized     []byte   `json:"-"`
+	XXX_sizecache        int32    `json:"-"`
+}
+
+func (m *EventFilter) Reset()         { *m = EventFilter{} }
+func (m *EventFilter) String() string { return proto.CompactTextString(m) }
+func (*EventFilter) ProtoMessage()    {}
+func (*EventFilter) Descriptor() ([]byte, []int) {
+	return fileDescriptor_80aa5495981f5974, []int{11}
+}
+
+func (m *EventFilter) XXX_Unmarshal(b []byte) error {
+	return xxx_messageInfo_EventFilter.Unmarshal(m, b)
+}
+func (m *EventFilter) XXX_Marshal(b []byte, deterministic bool) ([]byte, error) {
+	return xxx_messageInfo_EventFilter.Marshal(b, m, deterministic)
+}
+func (m *EventFilter) XXX_Merge(src proto.Message) {
+	xxx_messageInfo_EventFilter.Merge(m, src)
+}
+func (m *EventFilter) XXX_Size() int {
+	return xxx_messageInfo_EventFilter.Size(m)
+}
+func (m *EventFilter) XXX_DiscardUnknown() {
+	xxx_messageInfo_EventFilter.DiscardUnknown(m)
+}
+
+var xxx_messageInfo_EventFilter proto.InternalMessageInfo
+
+func (m *EventFilter) GetTypes() []string {
+	if m != nil {
+		return m.Types
+	}
+	return nil
+}
+
+func (m *EventFilter) GetProtocolKey() string {
+	if m != nil {
+		return m.ProtocolKey
+	}
+	return ""
+}
+
+func (m *EventFilter) GetInstanceKey() string {
+	if m != nil {
+		return m.InstanceKey
+	}
+	return ""
+}
+
+func (m *EventFilter) GetRejected() bool {
+	if m != nil {
+		return m.Rejected
+	}
+	return false
+}
+
+type Event struct {
+	Id          string `protobuf:"bytes,1,opt,name=id,proto3" json:"id,omitempty"`
+	Type        string `protobuf:"bytes,2,opt,name=type,proto3" json:"type,omitempty"`
+	InstanceKey string `protobuf:"bytes,3,opt,name=instance_key,json=instanceKey,proto3" json:"instance_key,omitempty"`
+	// rejected events were not run, sender is the peer that sent them
+	// and error why they were rejected
+	Rejected bool   `protobuf:"varint,4,opt,name=rejected,proto3" json:"rejected,omitempty"`
+	Sender   string `protobuf:"bytes,5,opt,name=sender,proto3" json:"sender,omitempty"`
+	Error    string `protobuf:"bytes,6,opt,name=error,proto3" json:"error,omitempty"`
+	// event marshalled as by events.Event.Marshal
+	Event                []byte   `protobuf:"bytes,7,opt,name=event,proto3" json:"event,omitempty"`
+	XXX_NoUnkeyedLiteral struct{} `json:"-"`
+	XXX_unrecognized     []byte   `json:"-"`
+	XXX_sizecache        int32    `json:"-"`
+}
+
+func (m *Event) Reset()         { *m = Event{} }
+func (m *Event) String() string { return proto.CompactTextString(m) }
+func (*Event) ProtoMessage()    {}
+func (*Event) Descriptor() ([]byte, []int) {
+	return fileDescriptor_80aa5495981f5974, []int{12}
+}
+
+func (m *Event) XXX_Unmarshal(b []byte) error {
+	return xxx_messageInfo_Event.Unmarshal(m, b)
+}
+func (m *Event) XXX_Marshal(b []byte, deterministic bool) ([]byte, error) {
+	return xxx_messageInfo_Event.Marshal(b, m, deterministic)
+}
+func (m *Event) XXX_Merge(src proto.Message) {
+	xxx_messageInfo_Event.Merge(m, src)
+}
+func (m *Event) XXX_Size() int {
+	return xxx_messageInfo_Event.Size(m)
+}
+func (m *Event) XXX_DiscardUnknown() {
+	xxx_messageInfo_Event.DiscardUnknown(m)
+}
+
+var xxx_messageInfo_Event proto.InternalMessageInfo
+
+func (m *Event) GetId() string {
+	if m != nil {
+		return m.Id
+	}
+	return ""
+}
+
+func (m *Event) GetType() string {
+	if m != nil {
+		return m.Type
+	}
+	return ""
+}
+
+func (m *Event) GetInstanceKey() string {
+	if m != nil {
+		return m.InstanceKey
+	}
+	return ""
+}
+
+func (m *Event) GetRejected() bool {
+	if m != nil {
+		return m.Rejected
+	}
+	return false
+}
+
+func (m *Event) GetSender() string {
+	if m != nil {
+		return m.Sender
+	}
+	return ""
+}
+
+func (m *Event) GetError() string {
+	if m != nil {
+		return m.Error
+	}
+	return ""
+}
+
+func (m *Event) GetEvent() []byte {
+	if m != nil {
+		return m.Event
+	}
+	return nil
+}
+
+func init() {
+	proto.RegisterType((*IDRequest)(nil), "nahs.rpc.IDRequest")
+	proto.RegisterType((*IDResponse)(nil), "nahs.rpc.IDResponse")
+	proto.RegisterType((*AddrsRequest)(nil), "nahs.rpc.AddrsRequest")
+	proto.RegisterType((*AddrsResponse)(nil), "nahs.rpc.AddrsResponse")
+	proto.RegisterType((*ContactQuery)(nil), "nahs.rpc.ContactQuery")
+	proto.RegisterType((*ContactsResponse)(nil), "nahs.rpc.ContactsResponse")
+	proto.RegisterType((*Contact)(nil), "nahs.rpc.Contact")
+	proto.RegisterType((*Service)(nil), "nahs.rpc.Service")
+	proto.RegisterType((*AddProtocolRequest)(nil), "nahs.rpc.AddProtocolRequest")
+	proto.RegisterType((*SendEventRequest)(nil), "nahs.rpc.SendEventRequest")
+	proto.RegisterType((*SendEventResponse)(nil), "nahs.rpc.SendEventResponse")
+	proto.RegisterMapType((map[string]string)(nil), "nahs.rpc.SendEventResponse.PeersEntry")
+	proto.RegisterType((*EventFilter)(nil), "nahs.rpc.EventFilter")
+	proto.RegisterType((*Event)(nil), "nahs.rpc.Event")
+}
+
+func init() { proto.RegisterFile("nahs.proto", fileDescriptor_80aa5495981f5974) }
+
+var fileDescriptor_80aa5495981f5974 = []byte{
+	// 671 bytes of a gzipped FileDescriptorProto
+	0x1f, 0x8b, 0x08, 0x00, 0x00, 0x00, 0x00, 0x00, 0x02, 0xff, 0xa4, 0x54, 0xcb, 0x6e, 0xd3, 0x40,
+	0x14, 0x95, 0x9d, 0xa4, 0x8d, 0x6f, 0xdc, 0xd2, 0x0e, 0xa5, 0x58, 0xa6, 0x8b, 0xd4, 0x05, 0x94,
+	0x0d, 0x09, 0x2a, 0x42, 0xaa, 0xa0, 0x42, 0x6a, 0x69, 0x2b, 0x55, 0xa8, 0x55, 0x71, 0x16, 0x48,
+	0x2c, 0x88, 0x1c, 0xfb, 0x8a, 0xba, 0x4d, 0xc6, 0x66, 0x66, 0x12, 0x94, 0x0f, 0x60, 0xc5, 0xbf,
+	0x20, 0xbe, 0x8d, 0x2f, 0x40, 0x33, 0x1e, 0x3f, 0x92, 0x14, 0x84, 0xc4, 0xce, 0xe7, 0xf8, 0xdc,
+	0xc7, 0xdc, 0x73, 0x67, 0x00, 0x68, 0x70, 0xcd, 0xbb, 0x29, 0x4b, 0x44, 0x42, 0x9a, 0xea, 0x9b,
+	0xa5, 0xa1, 0xd7, 0x02, 0xeb, 0xfc, 0xc4, 0xc7, 0x2f, 0x13, 0xe4, 0xc2, 0xdb, 0x01, 0x90, 0x80,
+	0xa7, 0x09, 0xe5, 0x48, 0xd6, 0xc1, 0x8c, 0x23, 0xc7, 0x68, 0x1b, 0x1d, 0xcb, 0x37, 0xe3, 0xc8,
+	0x5b, 0x07, 0xfb, 0x28, 0x8a, 0x18, 0xcf, 0xd5, 0x4f, 0x60, 0x4d, 0x63, 0x1d, 0xb0, 0x05, 0x8d,
+	0x40, 0x12, 0x8e, 0xd1, 0xae, 0x75, 0x2c, 0x3f, 0x03, 0xde, 0x4f, 0x03, 0xec, 0xb7, 0x09, 0x15,
+	0x41, 0x28, 0xde, 0x4f, 0x90, 0xcd, 0xc8, 0x2e, 0xd8, 0xaa, 0x8b, 0x30, 0x19, 0x0d, 0x6e, 0x71,
+	0xa6, 0x2b, 0xb4, 0x72, 0xee, 0x1d, 0xce, 0xc8, 0x1e, 0xac, 0x15, 0x12, 0x1a, 0x8c, 0xd1, 0x31,
+	0x95, 0xa6, 0x88, 0xbb, 0x0c, 0xc6, 0xaa, 0x1c, 0x4b, 0x46, 0xc8, 0x9d, 0x5a, 0x56, 0x4e, 0x01,
+	0xb2, 0x03, 0x56, 0x98, 0x50, 0x8a, 0xa1, 0xc0, 0xc8, 0xa9, 0xb7, 0x8d, 0x4e, 0xd3, 0x2f, 0x09,
+	0xf2, 0x18, 0xd6, 0x39, 0x22, 0x1d, 0x7c, 0x8d, 0xc5, 0x75, 0x4c, 0x07, 0x63, 0xee, 0x34, 0xda,
+	0x46, 0xa7, 0xe6, 0xdb, 0x92, 0xfd, 0xa0, 0xc8, 0x0b, 0xee, 0x1d, 0xc1, 0x86, 0xee, 0xb8, 0x3c,
+	0xdc, 0x33, 0x68, 0x86, 0x9a, 0x53, 0xe7, 0x6b, 0xed, 0x6f, 0x76, 0xf3, 0x29, 0x76, 0xb5, 0xda,
+	0x2f, 0x24, 0xde, 0x0d, 0xac, 0x6a, 0x72, 0x71, 0x8e, 0xa4, 0x0d, 0xf6, 0x28, 0xe0, 0x62, 0xa0,
+	0x1a, 0x19, 0x73, 0x75, 0xb6, 0x9a, 0x0f, 0x92, 0xeb, 0x23, 0xd2, 0x0b, 0x2e, 0x6b, 0x71, 0x64,
+	0xd3, 0x38, 0xd4, 0x87, 0x9b, 0xab, 0xd5, 0xcf, 0xfe, 0xf8, 0x85, 0xc4, 0xfb, 0x04, 0xab, 0x9a,
+	0xfc, 0x97, 0xd9, 0xba, 0xd0, 0xcc, 0xa1, 0x1e, 0x6b, 0x81, 0xef, 0x1e, 0xa9, 0x77, 0x06, 0xe4,
+	0x28, 0x8a, 0xae, 0xb4, 0x48, 0xdb, 0x3f, 0x97, 0xc7, 0xf8, 0x53, 0x1e, 0xb3, 0x9a, 0xe7, 0x10,
+	0x36, 0xfa, 0x48, 0xa3, 0xd3, 0x29, 0x52, 0x91, 0x67, 0x21, 0x50, 0x4f, 0x11, 0x99, 0xce, 0xa0,
+	0xbe, 0x65, 0x34, 0x4a, 0x8d, 0x6a, 0xcf, 0xf6, 0x33, 0xe0, 0x7d, 0x37, 0x60, 0xb3, 0x12, 0xae,
+	0x6d, 0x39, 0x84, 0x86, 0x8c, 0xc9, 0x3d, 0x79, 0x5a, 0x9d, 0xd3, 0x82, 0xb6, 0x7b, 0x25, 0x85,
+	0xa7, 0x54, 0xb0, 0x99, 0x9f, 0x05, 0xb9, 0x07, 0x00, 0x25, 0x49, 0x36, 0xa0, 0x56, 0xce, 0x4c,
+	0x7e, 0xca, 0x4e, 0xa6, 0xc1, 0x68, 0x92, 0xef, 0x5f, 0x06, 0x5e, 0x99, 0x07, 0x86, 0xf7, 0xcd,
+	0x80, 0x96, 0xca, 0x7e, 0x16, 0x8f, 0x44, 0xd6, 0xb3, 0x98, 0xa5, 0x58, 0xec, 0xbe, 0x02, 0x4b,
+	0x76, 0x98, 0xcb, 0x76, 0xec, 0x82, 0x1d, 0x53, 0x2e, 0x02, 0x1a, 0xa2, 0x92, 0xd4, 0x32, 0x49,
+	0xce, 0x69, 0xc7, 0x18, 0xde, 0x54, 0x37, 0xba, 0xc0, 0xde, 0x0f, 0x03, 0x1a, 0xaa, 0x8f, 0xa5,
+	0x35, 0x23, 0x50, 0x97, 0x4d, 0xe8, 0x9a, 0xea, 0xfb, 0x3f, 0x8b, 0x91, 0x6d, 0x58, 0xe1, 0x48,
+	0x23, 0x64, 0xea, 0xd6, 0x58, 0xbe, 0x46, 0xca, 0x30, 0xc6, 0x12, 0xe6, 0xac, 0x64, 0x63, 0x52,
+	0xa0, 0xb4, 0x71, 0xb5, 0x62, 0xe3, 0xfe, 0x2f, 0x13, 0xea, 0x97, 0x49, 0x84, 0xa4, 0x07, 0xe6,
+	0xf9, 0x09, 0xb9, 0x5f, 0x1a, 0x56, 0xbc, 0x43, 0xee, 0xd6, 0x3c, 0xa9, 0xad, 0x3e, 0x80, 0x86,
+	0x7a, 0x6f, 0xc8, 0x76, 0xf9, 0xbb, 0xfa, 0x20, 0xb9, 0x0f, 0x97, 0x78, 0x1d, 0x79, 0x0c, 0xf6,
+	0x59, 0x4c, 0xa3, 0xfc, 0x4e, 0x57, 0x13, 0x54, 0x5f, 0x26, 0xd7, 0x5d, 0xe2, 0xcb, 0x1c, 0x6f,
+	0xa0, 0x55, 0xb9, 0x04, 0x64, 0x67, 0xae, 0xd6, 0xc2, 0xdd, 0x70, 0x97, 0xaf, 0x2b, 0x39, 0x01,
+	0xab, 0xd8, 0x48, 0xe2, 0xde, 0xb9, 0xa6, 0x59, 0xec, 0xa3, 0xbf, 0xac, 0x30, 0x79, 0x09, 0x56,
+	0x7f, 0x32, 0xe4, 0x21, 0x8b, 0x87, 0x48, 0x1e, 0x94, 0xca, 0xca, 0x2a, 0xba, 0xf7, 0x16, 0xe8,
+	0xe7, 0xc6, 0xf1, 0xde, 0xc7, 0xdd, 0xcf, 0xb1, 0xb8, 0x9e, 0x0c, 0xbb, 0x61, 0x32, 0xee, 0x8d,
+	0xe3, 0x5b, 0x1c, 0x71, 0xd6, 0x93, 0xb2, 0x1e, 0x4b, 0xc3, 0x5e, 0x3a, 0x7c, 0x9d, 0x0e, 0x87,
+	0x2b, 0x6a, 0x2d, 0x5f, 0xfc, 0x1e, 0x00, 0x4a, 0xc2, 0xb7, 0x3e, 0x29, 0x06, 0x00, 0x00,
+}
+
+// Reference imports to suppress errors if they are not otherwise used.
+var _ context.Context
+var _ grpc.ClientConn
+
+// This is a compile-time assertion to ensure that this generated file
+// is compatible with the grpc package it is being compiled against.
+const _ = grpc.SupportPackageIsVersion4
+
+// NodeClient is the client API for Node service.
+//
+// For semantics around ctx use and closing/ending streaming RPCs, please refer to https://godoc.org/google.golang.org/grpc#ClientConn.NewStream.
+type NodeClient interface {
+	// ID of the node
+	ID(ctx context.Context, in *IDRequest, opts ...grpc.CallOption) (*IDResponse, error)
+	// Addrs the node listens on
+	Addrs(ctx context.Context, in *AddrsRequest, opts ...grpc.CallOption) (*AddrsResponse, error)
+	// FindContacts returns the contacts matching a query
+	FindContacts(ctx context.Context, in *ContactQuery, opts ...grpc.CallOption) (*ContactsResponse, error)
+	// AddProtocol adds a BSPL protocol and the roles the node plays in it
+	AddProtocol(ctx context.Context, in *AddProtocolRequest, opts ...grpc.CallOption) (*Service, error)
+	// SendEvent sends a marshalled event to a peer, or publishes it to
+	// every participant of its instance if no peer is set
+	SendEvent(ctx context.Context, in *SendEventRequest, opts ...grpc.CallOption) (*SendEventResponse, error)
+	// Subscribe streams the events run by the node that match a filter.
+	// The headers are sent once the subscription is registered.
+	Subscribe(ctx context.Context, in *EventFilter, opts ...grpc.CallOption) (Node_SubscribeClient, error)
+}
+
+type nodeClient struct {
+	cc *grpc.ClientConn
+}
+
+func NewNodeClient(cc *grpc.ClientConn) NodeClient {
+	return &nodeClient{cc}
+}
+
+func (c *nodeClient) ID(ctx context.Context, in *IDRequest, opts ...grpc.CallOption) (*IDResponse, error) {
+	out := new(IDResponse)
+	err := c.cc.Invoke(ctx, "/nahs.rpc.Node/ID", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *nodeClient) Addrs(ctx context.Context, in *AddrsRequest, opts ...grpc.CallOption) (*AddrsResponse, error) {
+	out := new(AddrsResponse)
+	err := c.cc.Invoke(ctx, "/nahs.rpc.Node/Addrs", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *nodeClient) FindContacts(ctx context.Context, in *ContactQuery, opts ...grpc.CallOption) (*ContactsResponse, error) {
+	out := new(ContactsResponse)
+	err := c.cc.Invoke(ctx, "/nahs.rpc.Node/FindContacts", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *nodeClient) AddProtocol(ctx context.Context, in *AddProtocolRequest, opts ...grpc.CallOption) (*Service, error) {
+	out := new(Service)
+	err := c.cc.Invoke(ctx, "/nahs.rpc.Node/AddProtocol", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *nodeClient) SendEvent(ctx context.Context, in *SendEventRequest, opts ...grpc.CallOption) (*SendEventResponse, error) {
+	out := new(SendEventResponse)
+	err := c.cc.Invoke(ctx, "/nahs.rpc.Node/SendEvent", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *nodeClient) Subscribe(ctx context.Context, in *EventFilter, opts ...grpc.CallOption) (Node_SubscribeClient, error) {
+	stream, err := c.cc.NewStream(ctx, &_Node_serviceDesc.Streams[0], "/nahs.rpc.Node/Subscribe", opts...)
+	if err != nil {
+		return nil, err
+	}
+	x := &nodeSubscribeClient{stream}
+	if err := x.ClientStream.SendMsg(in); err != nil {
+		return nil, err
+	}
+	if err := x.ClientStream.CloseSend(); err != nil {
+		return nil, err
+	}
+	return x, nil
+}
+
+type Node_SubscribeClient interface {
+	Recv() (*Event, error)
+	grpc.ClientStream
+}
+
+type nodeSubscribeClient struct {
+	grpc.ClientStream
+}
+
+func (x *nodeSubscribeClient) Recv() (*Event, error) {
+	m := new(Event)
+	if err := x.ClientStream.RecvMsg(m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+// NodeServer is the server API for Node service.
+type NodeServer interface {
+	// ID of the node
+	ID(context.Context, *IDRequest) (*IDResponse, error)
+	// Addrs the node listens on
+	Addrs(context.Context, *AddrsRequest) (*AddrsResponse, error)
+	// FindContacts returns the contacts matching a query
+	FindContacts(context.Context, *ContactQuery) (*ContactsResponse, error)
+	// AddProtocol adds a BSPL protocol and the roles the node plays in it
+	AddProtocol(context.Context, *AddProtocolRequest) (*Service, error)
+	// SendEvent sends a marshalled event to a peer, or publishes it to
+	// every participant of its instance if no peer is set
+	SendEvent(context.Context, *SendEventRequest) (*SendEventResponse, error)
+	// Subscribe streams the events run by the node that match a filter.
+	// The headers are sent once the subscription is registered.
+	Subscribe(*EventFilter, Node_SubscribeServer) error
+}
+
+// UnimplementedNodeServer can be embedded to have forward compatible implementations.
+type UnimplementedNodeServer struct {
+}
+
+func (*UnimplementedNodeServer) ID(ctx context.Context, req *IDRequest) (*IDResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method ID not implemented")
+}
+func (*UnimplementedNodeServer) Addrs(ctx context.Context, req *AddrsRequest) (*AddrsResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method Addrs not implemented")
+}
+func (*UnimplementedNodeServer) FindContacts(ctx context.Context, req *ContactQuery) (*ContactsResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method FindContacts not implemented")
+}
+func (*UnimplementedNodeServer) AddProtocol(ctx context.Context, req *AddProtocolRequest) (*Service, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method AddProtocol not implemented")
+}
+func (*UnimplementedNodeServer) SendEvent(ctx context.Context, req *SendEventRequest) (*SendEventResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method SendEvent not implemented")
+}
+func (*UnimplementedNodeServer) Subscribe(req *EventFilter, srv Node_SubscribeServer) error {
+	return status.Errorf(codes.Unimplemented, "method Subscribe not implemented")
+}
+
+func RegisterNodeServer(s *grpc.Server, srv NodeServer) {
+	s.RegisterService(&_Node_serviceDesc, srv)
+}
+
+func _Node_ID_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(IDRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(NodeServer).ID(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/nahs.rpc.Node/ID",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(NodeServer).ID(ctx, req.(*IDRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Node_Addrs_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(AddrsRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(NodeServer).Addrs(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/nahs.rpc.Node/Addrs",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(NodeServer).Addrs(ctx, req.(*AddrsRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Node_FindContacts_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(ContactQuery)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(NodeServer).FindContacts(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/nahs.rpc.Node/FindContacts",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(NodeServer).FindContacts(ctx, req.(*ContactQuery))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Node_AddProtocol_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(AddProtocolRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(NodeServer).AddProtocol(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/nahs.rpc.Node/AddProtocol",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(NodeServer).AddProtocol(ctx, req.(*AddProtocolRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Node_SendEvent_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(SendEventRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(NodeServer).SendEvent(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/nahs.rpc.Node/SendEvent",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(NodeServer).SendEvent(ctx, req.(*SendEventRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Node_Subscribe_Handler(srv interface{}, stream grpc.ServerStream) error {
+	m := new(EventFilter)
+	if err := stream.RecvMsg(m); err != nil {
+		return err
+	}
+	return srv.(NodeServer).Subscribe(m, &nodeSubscribeServer{stream})
+}
+
+type Node_SubscribeServer interface {
+	Send(*Event) error
+	grpc.ServerStream
+}
+
+type nodeSubscribeServer struct {
+	grpc.ServerStream
+}
+
+func (x *nodeSubscribeServer) Send(m *Event) error {
+	return x.ServerStream.SendMsg(m)
+}
+
+var _Node_serviceDesc = grpc.ServiceDesc{
+	ServiceName: "nahs.rpc.Node",
+	HandlerType: (*NodeServer)(nil),
+	Methods: []grpc.MethodDesc{
+		{
+			MethodName: "ID",
+			Handler:    _Node_ID_Handler,
+		},
+		{
+			MethodName: "Addrs",
+			Handler:    _Node_Addrs_Handler,
+		},
+		{
+			MethodName: "FindContacts",
+			Handler:    _Node_FindContacts_Handler,
+		},
+		{
+			MethodName: "AddProtocol",
+			Handler:    _Node_AddProtocol_Handler,
+		},
+		{
+			MethodName: "SendEvent",
+			Handler:    _Node_SendEvent_Handler,
+		},
+	},
+	Streams: []grpc.StreamDesc{
+		{
+			StreamName:    "Subscribe",
+			Handler:       _Node_Subscribe_Handler,
+			ServerStreams: true,
+		},
+	},
+	Metadata: "nahs.proto",
+}
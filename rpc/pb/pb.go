@@ -0,0 +1,5 @@
+// Package pb has the protobuf definition of the gRPC service of a
+// NaHS node and the code generated from it.
+package pb
+
+//go:generate protoc --go_out=plugins=grpc,paths=source_relative:. nahs.proto
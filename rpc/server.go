@@ -0,0 +1,267 @@
+// Package rpc serves the API of a NaHS node as the gRPC service
+// defined in the pb package.
+package rpc
+
+import (
+	"context"
+	"errors"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/libp2p/go-libp2p-core/peer"
+	"github.com/mikelsr/bspl"
+	"github.com/mikelsr/nahs/events"
+	"github.com/mikelsr/nahs/net"
+	"github.com/mikelsr/nahs/rpc/pb"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+// Server implements pb.NodeServer for a node
+type Server struct {
+	node *net.Node
+	// done is closed to end the subscriptions
+	done      chan struct{}
+	closeOnce sync.Once
+}
+
+// NewServer is the default constructor for Server
+func NewServer(node *net.Node) *Server {
+	return &Server{node: node, done: make(chan struct{})}
+}
+
+// Register creates a Server for a node and registers it in a gRPC
+// server
+func Register(s *grpc.Server, node *net.Node) *Server {
+	server := NewServer(node)
+	pb.RegisterNodeServer(s, server)
+	return server
+}
+
+// Close ends the open subscriptions, which would otherwise keep
+// grpc.Server.GracefulStop waiting
+func (s *Server) Close() {
+	s.closeOnce.Do(func() { close(s.done) })
+}
+
+// ID of the node
+func (s *Server) ID(ctx context.Context, req *pb.IDRequest) (*pb.IDResponse, error) {
+	return &pb.IDResponse{Id: s.node.ID().Pretty()}, nil
+}
+
+// Addrs the node listens on
+func (s *Server) Addrs(ctx context.Context, req *pb.AddrsRequest) (*pb.AddrsResponse, error) {
+	addrs := s.node.Addrs()
+	res := &pb.AddrsResponse{Addrs: make([]string, len(addrs))}
+	for i, addr := range addrs {
+		res.Addrs[i] = addr.String()
+	}
+	return res, nil
+}
+
+// FindContacts returns the contacts matching a query sorted by ID
+func (s *Server) FindContacts(ctx context.Context, req *pb.ContactQuery) (*pb.ContactsResponse, error) {
+	query := net.ContactQuery{
+		ProtocolKey:  req.ProtocolKey,
+		ProtocolName: req.ProtocolName,
+		Connected:    req.Connected,
+		SeenWithin:   time.Duration(req.SeenWithinMs) * time.Millisecond,
+	}
+	for _, role := range req.Roles {
+		query.Roles = append(query.Roles, bspl.Role(role))
+	}
+	res := &pb.ContactsResponse{Contacts: make([]*pb.Contact, 0)}
+	for _, id := range s.node.FindContacts(query) {
+		services, found := s.node.Contacts().Get(id)
+		if !found {
+			continue
+		}
+		contact := &pb.Contact{Id: id.Pretty(), Services: makeServices(services)}
+		if seen, found := s.node.Contacts().LastSeen(id); found {
+			contact.LastSeenMs = seen.UnixNano() / int64(time.Millisecond)
+		}
+		res.Contacts = append(res.Contacts, contact)
+	}
+	return res, nil
+}
+
+// AddProtocol adds a BSPL protocol and the roles the node plays in it
+func (s *Server) AddProtocol(ctx context.Context, req *pb.AddProtocolRequest) (*pb.Service, error) {
+	p, err := bspl.Parse(strings.NewReader(req.Protocol))
+	if err != nil {
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+	roles := make([]bspl.Role, len(req.Roles))
+	for i, r := range req.Roles {
+		roles[i] = bspl.Role(r)
+		if !hasRole(p, roles[i]) {
+			return nil, status.Errorf(codes.InvalidArgument, "Protocol '%s' has no role '%s'", p.Name, r)
+		}
+	}
+	s.node.AddProtocol(p, roles...)
+	return makeService(net.Service{Protocol: p, Roles: roles}), nil
+}
+
+// SendEvent sends a marshalled event to a peer, or publishes it to
+// every participant of its instance if no peer is set. The event is
+// run by the reasoner of the node first, except drops, which are run
+// once the participants were told. Failures of the peers are returned
+// in the response.
+func (s *Server) SendEvent(ctx context.Context, req *pb.SendEventRequest) (*pb.SendEventResponse, error) {
+	event, err := events.Unmarshal(req.Event)
+	if err != nil {
+		return nil, status.Errorf(codes.InvalidArgument, "Invalid event: %s", err)
+	}
+	var target peer.ID
+	if req.Peer != "" {
+		if target, err = peer.Decode(req.Peer); err != nil {
+			return nil, status.Errorf(codes.InvalidArgument, "Invalid peer '%s': %s", req.Peer, err)
+		}
+	}
+	reasoner := s.node.Reasoner()
+	if reasoner == nil {
+		return nil, status.Error(codes.FailedPrecondition, "Node has no reasoner")
+	}
+	if err := runEvent(reasoner, event, req.Event); err != nil {
+		return nil, err
+	}
+
+	var results map[peer.ID]error
+	told := true
+	if req.Peer != "" {
+		// the instance is tracked only once the peer accepted it
+		err := s.node.SendEvent(ctx, target, event)
+		if err == nil {
+			s.node.TrackInstance(event)
+		}
+		told = err == nil
+		results = map[peer.ID]error{target: err}
+	} else {
+		results, err = s.node.Publish(ctx, event)
+		var errPeers net.ErrPeers
+		if err != nil && !errors.As(err, &errPeers) {
+			return nil, status.Error(codes.FailedPrecondition, err.Error())
+		}
+	}
+	res := &pb.SendEventResponse{Peers: make(map[string]string, len(results))}
+	for id, err := range results {
+		res.Peers[id.Pretty()] = ""
+		if err != nil {
+			res.Peers[id.Pretty()] = err.Error()
+		}
+	}
+	// the instance is dropped once the participants were told
+	if e, ok := event.(events.DropEvent); ok && told {
+		if err := reasoner.DropInstance(e.InstanceKey(), e.Motive()); err != nil {
+			return nil, status.Error(codes.Internal, err.Error())
+		}
+	}
+	return res, nil
+}
+
+// runEvent runs an event about to be sent with the reasoner of the
+// node. Drops are only checked to refer to a known instance.
+func runEvent(r bspl.Reasoner, event events.Event, b []byte) error {
+	switch event.(type) {
+	case events.DropEvent:
+		if _, found := r.GetInstance(event.InstanceKey()); !found {
+			return status.Errorf(codes.NotFound, "Unknown instance '%s'", event.InstanceKey())
+		}
+		return nil
+	case events.NewEvent:
+		if err := events.RunEvent(r, b); err != nil {
+			return status.Error(codes.AlreadyExists, err.Error())
+		}
+		return nil
+	}
+	if err := events.RunEvent(r, b); err != nil {
+		return status.Error(codes.InvalidArgument, err.Error())
+	}
+	return nil
+}
+
+// Subscribe streams the events run by the node that match a filter
+// until the client cancels it, the server is closed or the node is
+// closed. The headers are sent once the subscription is registered,
+// so clients can wait for them with Header before triggering events.
+func (s *Server) Subscribe(req *pb.EventFilter, stream pb.Node_SubscribeServer) error {
+	filter := net.EventFilter{
+		ProtocolKey: req.ProtocolKey,
+		InstanceKey: req.InstanceKey,
+		Rejected:    req.Rejected,
+	}
+	for _, t := range req.Types {
+		filter.Types = append(filter.Types, events.EventType(t))
+	}
+	subscription, cancel := s.node.Subscribe(filter)
+	defer cancel()
+	if err := stream.SendHeader(metadata.MD{}); err != nil {
+		return err
+	}
+	for {
+		select {
+		case <-stream.Context().Done():
+			return stream.Context().Err()
+		case <-s.done:
+			return status.Error(codes.Unavailable, "Server closed")
+		case event, ok := <-subscription:
+			if !ok {
+				return status.Error(codes.Unavailable, "Node closed")
+			}
+			e, err := makeEvent(event)
+			if err != nil {
+				continue
+			}
+			if err := stream.Send(e); err != nil {
+				return err
+			}
+		}
+	}
+}
+
+// makeEvent converts an event delivered to a subscription
+func makeEvent(event events.Event) (*pb.Event, error) {
+	b, err := event.Marshal()
+	if err != nil {
+		return nil, err
+	}
+	e := &pb.Event{Id: event.ID(), Type: string(event.Type()), InstanceKey: event.InstanceKey(), Event: b}
+	if rejected, ok := event.(net.RejectedEvent); ok {
+		e.Rejected, e.Sender, e.Error = true, rejected.Sender.Pretty(), rejected.Err.Error()
+	}
+	return e, nil
+}
+
+// makeServices converts the services of a contact sorted by
+// protocol key
+func makeServices(services net.Services) []*pb.Service {
+	converted := make([]*pb.Service, 0, len(services))
+	for _, s := range services {
+		converted = append(converted, makeService(s))
+	}
+	sort.Slice(converted, func(i, j int) bool { return converted[i].ProtocolKey < converted[j].ProtocolKey })
+	return converted
+}
+
+// makeService converts a service
+func makeService(s net.Service) *pb.Service {
+	roles := make([]string, len(s.Roles))
+	for i, r := range s.Roles {
+		roles[i] = string(r)
+	}
+	return &pb.Service{ProtocolKey: s.Protocol.Key(), Protocol: s.Protocol.Name, Roles: roles}
+}
+
+// hasRole checks if a role is defined in a protocol
+func hasRole(p bspl.Protocol, role bspl.Role) bool {
+	for _, r := range p.Roles {
+		if r == role {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,335 @@
+package rpc
+
+import (
+	"context"
+	"io/ioutil"
+	stdnet "net"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/libp2p/go-libp2p-core/peerstore"
+	"github.com/mikelsr/bspl"
+	imp "github.com/mikelsr/bspl/implementation"
+	"github.com/mikelsr/nahs/events"
+	"github.com/mikelsr/nahs/net"
+	"github.com/mikelsr/nahs/reasoner"
+	"github.com/mikelsr/nahs/rpc/pb"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/grpc/test/bufconn"
+)
+
+const testProtocol = `Purchase {
+	role Buyer, Seller
+	parameter out ID key, out item, out price
+
+	Buyer -> Seller: Request[out ID, out item]
+	Seller -> Buyer: Offer[in ID, in item, out price]
+}`
+
+// testNode creates a node with a persistent reasoner
+func testNode(t *testing.T, path string) (*net.Node, *reasoner.Reasoner) {
+	r, err := reasoner.Open(path)
+	if err != nil {
+		t.Log(err)
+		t.FailNow()
+	}
+	node, err := net.LocalNode(r, net.WithListenAddrs("/ip4/127.0.0.1/tcp/0"))
+	if err != nil {
+		t.Log(err)
+		t.FailNow()
+	}
+	return node, r
+}
+
+// dialBufconn serves a node on an in-memory listener and returns a
+// client connected to it
+func dialBufconn(t *testing.T, node *net.Node, serverOpts []grpc.ServerOption, dialOpts ...grpc.DialOption) (pb.NodeClient, func()) {
+	listener := bufconn.Listen(1 << 20)
+	s := grpc.NewServer(serverOpts...)
+	server := Register(s, node)
+	go s.Serve(listener)
+	dialOpts = append(dialOpts,
+		grpc.WithContextDialer(func(ctx context.Context, _ string) (stdnet.Conn, error) {
+			return listener.Dial()
+		}),
+		grpc.WithInsecure())
+	conn, err := grpc.DialContext(context.Background(), "bufconn", dialOpts...)
+	if err != nil {
+		t.Log(err)
+		t.FailNow()
+	}
+	return pb.NewNodeClient(conn), func() {
+		conn.Close()
+		server.Close()
+		s.GracefulStop()
+	}
+}
+
+func TestServer(t *testing.T) {
+	dir, err := ioutil.TempDir("", "nahs-rpc")
+	if err != nil {
+		t.Log(err)
+		t.FailNow()
+	}
+	defer os.RemoveAll(dir)
+	seller, sellerReasoner := testNode(t, filepath.Join(dir, "seller.db"))
+	defer sellerReasoner.Close()
+	defer seller.Close(context.Background())
+	buyer, buyerReasoner := testNode(t, filepath.Join(dir, "buyer.db"))
+	defer buyerReasoner.Close()
+	defer buyer.Close(context.Background())
+	seller.Peerstore().AddAddrs(buyer.ID(), buyer.Addrs(), peerstore.PermanentAddrTTL)
+	buyer.Peerstore().AddAddrs(seller.ID(), seller.Addrs(), peerstore.PermanentAddrTTL)
+	client, stop := dialBufconn(t, seller, nil)
+	defer stop()
+	ctx := context.Background()
+
+	// identity
+	id, err := client.ID(ctx, &pb.IDRequest{})
+	if err != nil || id.Id != seller.ID().Pretty() {
+		t.Log(err)
+		t.FailNow()
+	}
+	addrs, err := client.Addrs(ctx, &pb.AddrsRequest{})
+	if err != nil || len(addrs.Addrs) != len(seller.Addrs()) {
+		t.Log(err)
+		t.FailNow()
+	}
+
+	// protocols
+	service, err := client.AddProtocol(ctx, &pb.AddProtocolRequest{Protocol: testProtocol, Roles: []string{"Seller"}})
+	if err != nil || service.Protocol != "Purchase" || len(seller.Services()) != 1 {
+		t.Log(err)
+		t.FailNow()
+	}
+	if _, err := client.AddProtocol(ctx, &pb.AddProtocolRequest{Protocol: testProtocol, Roles: []string{"Other"}}); status.Code(err) != codes.InvalidArgument {
+		t.FailNow()
+	}
+	p, _ := bspl.Parse(strings.NewReader(testProtocol))
+	buyer.AddProtocol(p, "Buyer")
+
+	// contacts
+	seller.Contacts().Put(buyer.ID(), net.Services{p.Key(): {Protocol: p, Roles: []bspl.Role{"Buyer"}}})
+	contacts, err := client.FindContacts(ctx, &pb.ContactQuery{ProtocolKey: p.Key(), Roles: []string{"Buyer"}})
+	if err != nil || len(contacts.Contacts) != 1 || contacts.Contacts[0].Id != buyer.ID().Pretty() ||
+		contacts.Contacts[0].Services[0].ProtocolKey != p.Key() || contacts.Contacts[0].LastSeenMs == 0 {
+		t.Log(err)
+		t.FailNow()
+	}
+	if contacts, err := client.FindContacts(ctx, &pb.ContactQuery{Roles: []string{"Seller"}}); err != nil || len(contacts.Contacts) != 0 {
+		t.Log(err)
+		t.FailNow()
+	}
+
+	// the seller subscribes and the buyer creates an instance
+	streamCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	stream, err := client.Subscribe(streamCtx, &pb.EventFilter{Types: []string{string(events.TypeNewEvent)}})
+	if err != nil {
+		t.Log(err)
+		t.FailNow()
+	}
+	if _, err := stream.Header(); err != nil {
+		t.Log(err)
+		t.FailNow()
+	}
+	instance := imp.NewInstance(p, bspl.Roles{"Buyer": buyer.ID().Pretty(), "Seller": seller.ID().Pretty()})
+	instance.SetValue("ID", "1")
+	instance.SetValue("item", "apple")
+	if err := buyerReasoner.RegisterInstance(instance); err != nil {
+		t.Log(err)
+		t.FailNow()
+	}
+	created := events.MakeNewEvent(instance)
+	if _, err := buyer.Publish(ctx, created); err != nil {
+		t.Log(err)
+		t.FailNow()
+	}
+	received, err := stream.Recv()
+	if err != nil || received.Id != created.ID() || received.InstanceKey != instance.Key() || len(received.Event) == 0 {
+		t.Log(err)
+		t.FailNow()
+	}
+
+	// the seller makes an offer through the service
+	current, _ := sellerReasoner.GetInstance(instance.Key())
+	b, _ := current.Marshal()
+	offer := new(imp.Instance)
+	if err := offer.Unmarshal(b); err != nil {
+		t.Log(err)
+		t.FailNow()
+	}
+	offer.SetValue("price", "10")
+	b, _ = events.MakeUpdateEvent(offer).Marshal()
+	sent, err := client.SendEvent(ctx, &pb.SendEventRequest{Event: b})
+	if err != nil || len(sent.Peers) != 1 || sent.Peers[buyer.ID().Pretty()] != "" {
+		t.Log(err, sent)
+		t.FailNow()
+	}
+	// the update is run by both reasoners
+	for _, r := range []*reasoner.Reasoner{sellerReasoner, buyerReasoner} {
+		if i, found := r.GetInstance(instance.Key()); !found || i.GetValue("price") != "10" {
+			t.FailNow()
+		}
+	}
+
+	// the seller drops it sending the event to the buyer
+	b, _ = events.MakeDropEvent(instance.Key(), "too expensive").Marshal()
+	sent, err = client.SendEvent(ctx, &pb.SendEventRequest{Peer: buyer.ID().Pretty(), Event: b})
+	if err != nil || sent.Peers[buyer.ID().Pretty()] != "" {
+		t.Log(err, sent)
+		t.FailNow()
+	}
+	if _, found := seller.OpenInstances().Get(instance.Key()); found {
+		t.FailNow()
+	}
+	if _, found := buyer.OpenInstances().Get(instance.Key()); found {
+		t.FailNow()
+	}
+	if _, found := sellerReasoner.Dropped(instance.Key()); !found {
+		t.FailNow()
+	}
+
+	// invalid events
+	for _, req := range []*pb.SendEventRequest{
+		{Event: []byte("invalid")},
+		{Peer: "invalid", Event: b},
+	} {
+		if _, err := client.SendEvent(ctx, req); status.Code(err) != codes.InvalidArgument {
+			t.Log(err)
+			t.FailNow()
+		}
+	}
+}
+
+func TestServer_rejectedEvents(t *testing.T) {
+	dir, err := ioutil.TempDir("", "nahs-rpc")
+	if err != nil {
+		t.Log(err)
+		t.FailNow()
+	}
+	defer os.RemoveAll(dir)
+	seller, sellerReasoner := testNode(t, filepath.Join(dir, "seller.db"))
+	defer sellerReasoner.Close()
+	defer seller.Close(context.Background())
+	buyer, buyerReasoner := testNode(t, filepath.Join(dir, "buyer.db"))
+	defer buyerReasoner.Close()
+	defer buyer.Close(context.Background())
+	buyer.Peerstore().AddAddrs(seller.ID(), seller.Addrs(), peerstore.PermanentAddrTTL)
+	client, stop := dialBufconn(t, buyer, nil)
+	defer stop()
+	ctx := context.Background()
+	p, _ := bspl.Parse(strings.NewReader(testProtocol))
+	roles := bspl.Roles{"Buyer": buyer.ID().Pretty(), "Seller": seller.ID().Pretty()}
+
+	// the seller rejects an instance it already holds, so the buyer
+	// doesn't open it
+	existing := imp.NewInstance(p, roles)
+	existing.SetValue("ID", "1")
+	existing.SetValue("item", "apple")
+	if err := sellerReasoner.RegisterInstance(existing); err != nil {
+		t.Log(err)
+		t.FailNow()
+	}
+	b, _ := events.MakeNewEvent(existing).Marshal()
+	sent, err := client.SendEvent(ctx, &pb.SendEventRequest{Peer: seller.ID().Pretty(), Event: b})
+	if err != nil || sent.Peers[seller.ID().Pretty()] == "" {
+		t.Log(err, sent)
+		t.FailNow()
+	}
+	if buyer.OpenInstances().Len() != 0 {
+		t.FailNow()
+	}
+
+	// the seller rejects dropping an instance it doesn't know, so the
+	// buyer keeps it
+	unknown := imp.NewInstance(p, roles)
+	unknown.SetValue("ID", "2")
+	if err := buyerReasoner.RegisterInstance(unknown); err != nil {
+		t.Log(err)
+		t.FailNow()
+	}
+	buyer.OpenInstances().Put(unknown.Key(), net.Ownership{Creator: buyer.ID()})
+	b, _ = events.MakeDropEvent(unknown.Key(), "_").Marshal()
+	sent, err = client.SendEvent(ctx, &pb.SendEventRequest{Peer: seller.ID().Pretty(), Event: b})
+	if err != nil || sent.Peers[seller.ID().Pretty()] == "" {
+		t.Log(err, sent)
+		t.FailNow()
+	}
+	if o, found := buyer.OpenInstances().Get(unknown.Key()); !found || o.Creator != buyer.ID() || buyer.OpenInstances().Len() != 1 {
+		t.FailNow()
+	}
+	if _, found := buyerReasoner.GetInstance(unknown.Key()); !found {
+		t.FailNow()
+	}
+}
+
+func TestServer_Close(t *testing.T) {
+	node, err := net.LocalNode(nil, net.WithListenAddrs("/ip4/127.0.0.1/tcp/0"))
+	if err != nil {
+		t.Log(err)
+		t.FailNow()
+	}
+	defer node.Close(context.Background())
+	client, stop := dialBufconn(t, node, nil)
+	stream, err := client.Subscribe(context.Background(), &pb.EventFilter{})
+	if err != nil {
+		t.Log(err)
+		t.FailNow()
+	}
+	if _, err := stream.Header(); err != nil {
+		t.Log(err)
+		t.FailNow()
+	}
+	// open subscriptions don't block the graceful stop
+	done := make(chan struct{})
+	go func() {
+		stop()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.FailNow()
+	}
+	if _, err := stream.Recv(); err == nil {
+		t.FailNow()
+	}
+}
+
+func TestTokenAuth(t *testing.T) {
+	node, err := net.LocalNode(nil, net.WithListenAddrs("/ip4/127.0.0.1/tcp/0"))
+	if err != nil {
+		t.Log(err)
+		t.FailNow()
+	}
+	defer node.Close(context.Background())
+	for _, token := range []string{"", "wrong", "secret"} {
+		var dialOpts []grpc.DialOption
+		if token != "" {
+			dialOpts = append(dialOpts, TokenCredentials(token))
+		}
+		client, stop := dialBufconn(t, node, TokenAuth("secret"), dialOpts...)
+		_, err := client.ID(context.Background(), &pb.IDRequest{})
+		stream, streamErr := client.Subscribe(context.Background(), &pb.EventFilter{})
+		if streamErr == nil && token == "secret" {
+			_, streamErr = stream.Header()
+		} else if streamErr == nil {
+			_, streamErr = stream.Recv()
+		}
+		stop()
+		if token == "secret" && (err != nil || streamErr != nil) {
+			t.Log(err, streamErr)
+			t.FailNow()
+		}
+		if token != "secret" && (status.Code(err) != codes.Unauthenticated || streamErr == nil) {
+			t.Log(token, err, streamErr)
+			t.FailNow()
+		}
+	}
+}